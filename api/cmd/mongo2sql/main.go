@@ -0,0 +1,153 @@
+// Command mongo2sql copies the attendance data of a mongo database into
+// a sqlite or postgres database, creating its schema first. Documents
+// already in the sql database are skipped so a copy can be resumed.
+// Run normalizeemails on databases saved by older servers first.
+//
+//	mongo2sql -mongo localhost/classmate -driver sqlite -dsn classmate.db
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/edwintcloud/classmate/api/services/attendance"
+	"github.com/globalsign/mgo"
+
+	// import sql drivers
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+func main() {
+	mongoURI := flag.String("mongo", "localhost/classmate", "mongodb uri of the database to copy")
+	driver := flag.String("driver", "sqlite", "sql driver, sqlite or postgres")
+	dsn := flag.String("dsn", "classmate.db", "sql data source name")
+	flag.Parse()
+
+	if *driver != "sqlite" && *driver != "postgres" {
+		log.Fatalln("Unknown sql driver:", *driver)
+	}
+
+	// connect to mongo
+	session, err := mgo.DialWithTimeout(*mongoURI, time.Second*3)
+	if err != nil {
+		log.Fatalln("Unable to connect to mongo:", err.Error())
+	}
+	defer session.Close()
+	uriParts := strings.Split(*mongoURI, "/")
+	database := session.DB(uriParts[len(uriParts)-1])
+
+	// open sql database and migrate its schema
+	sqlDB, err := sql.Open(*driver, *dsn)
+	if err != nil {
+		log.Fatalln("Unable to open sql database:", err.Error())
+	}
+	defer sqlDB.Close()
+	store, err := attendance.NewSQLStore(sqlDB, *driver)
+	if err != nil {
+		log.Fatalln("Unable to migrate sql database:", err.Error())
+	}
+
+	// copy each collection
+	collections := []struct {
+		name   string
+		insert func(iter *mgo.Iter) (bool, error)
+	}{
+		{"persons", func(iter *mgo.Iter) (bool, error) {
+			doc := attendance.Person{}
+			if !iter.Next(&doc) {
+				return false, nil
+			}
+			return true, store.Persons.Insert(&doc)
+		}},
+		{"classes", func(iter *mgo.Iter) (bool, error) {
+			doc := attendance.Class{}
+			if !iter.Next(&doc) {
+				return false, nil
+			}
+			return true, store.Classes.Insert(&doc)
+		}},
+		{"attendance", func(iter *mgo.Iter) (bool, error) {
+			doc := attendance.Attendance{}
+			if !iter.Next(&doc) {
+				return false, nil
+			}
+			return true, store.Attendance.Insert(&doc)
+		}},
+		{"excuses", func(iter *mgo.Iter) (bool, error) {
+			doc := attendance.Excuse{}
+			if !iter.Next(&doc) {
+				return false, nil
+			}
+			return true, store.Excuses.Insert(&doc)
+		}},
+		{"locations", func(iter *mgo.Iter) (bool, error) {
+			doc := attendance.Location{}
+			if !iter.Next(&doc) {
+				return false, nil
+			}
+			return true, store.Locations.Insert(&doc)
+		}},
+		{"logins", func(iter *mgo.Iter) (bool, error) {
+			doc := attendance.Login{}
+			if !iter.Next(&doc) {
+				return false, nil
+			}
+			return true, store.Logins.Insert(&doc)
+		}},
+		{"terms", func(iter *mgo.Iter) (bool, error) {
+			doc := attendance.Term{}
+			if !iter.Next(&doc) {
+				return false, nil
+			}
+			return true, store.Terms.Insert(&doc)
+		}},
+		{"webhooks", func(iter *mgo.Iter) (bool, error) {
+			doc := attendance.Webhook{}
+			if !iter.Next(&doc) {
+				return false, nil
+			}
+			return true, store.Webhooks.Insert(&doc)
+		}},
+		{"deliveries", func(iter *mgo.Iter) (bool, error) {
+			doc := attendance.Delivery{}
+			if !iter.Next(&doc) {
+				return false, nil
+			}
+			return true, store.Deliveries.Insert(&doc)
+		}},
+	}
+	failed := false
+	for _, collection := range collections {
+		copied, skipped, errors := 0, 0, 0
+		iter := database.C(collection.name).Find(nil).Sort("_id").Iter()
+		for {
+			more, err := collection.insert(iter)
+			if !more {
+				break
+			}
+			switch {
+			case err == attendance.ErrDuplicate:
+				skipped++
+			case err != nil:
+				errors++
+				log.Println(collection.name+":", err.Error())
+			default:
+				copied++
+			}
+		}
+		if err := iter.Close(); err != nil {
+			log.Fatalln("Unable to read", collection.name+":", err.Error())
+		}
+		fmt.Printf("%-10s copied %d, skipped %d existing, %d failed\n", collection.name, copied, skipped, errors)
+		failed = failed || errors > 0
+	}
+
+	if failed {
+		log.Fatalln("Some documents could not be copied")
+	}
+}
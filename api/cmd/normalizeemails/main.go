@@ -0,0 +1,46 @@
+// Command normalizeemails lowercases the emails of persons a mongo
+// database saved before emails were normalised. Emails saved by several
+// persons in different cases are listed and left as they are for an
+// admin to resolve. It only needs to run once, before mongo2sql copies
+// a database saved by an older server, and is safe to run again.
+//
+//	normalizeemails -mongo localhost/classmate
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/edwintcloud/classmate/api/services/attendance"
+	"github.com/globalsign/mgo"
+)
+
+func main() {
+	mongoURI := flag.String("mongo", "localhost/classmate", "mongodb uri of the database to normalise")
+	flag.Parse()
+
+	// connect to mongo
+	session, err := mgo.DialWithTimeout(*mongoURI, time.Second*3)
+	if err != nil {
+		log.Fatalln("Unable to connect to mongo:", err.Error())
+	}
+	defer session.Close()
+	uriParts := strings.Split(*mongoURI, "/")
+	database := session.DB(uriParts[len(uriParts)-1])
+
+	conflicts, err := attendance.NormalizeMgoEmails(database)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	for _, conflict := range conflicts {
+		ids := make([]string, len(conflict.Persons))
+		for i, id := range conflict.Persons {
+			ids[i] = id.Hex()
+		}
+		fmt.Printf("%s is saved by persons %s, rename all but one of them to sign in with it\n", conflict.Email, strings.Join(ids, ", "))
+	}
+	fmt.Printf("normalised emails, %d left with conflicts\n", len(conflicts))
+}
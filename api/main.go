@@ -11,11 +11,8 @@ import (
 func main() {
 	server := server.EchoHandler()
 
-	// defer log file close
-	defer server.Log.Close()
-
-	// defer mgo session close
-	defer server.Session.Close()
+	// defer log file and mgo session close
+	defer server.Close()
 
 	// register other services with server
 	attendance.Register(server)
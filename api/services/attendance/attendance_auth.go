@@ -0,0 +1,121 @@
+package attendance
+
+import (
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/edwintcloud/classmate/api/services/server"
+	"github.com/globalsign/mgo/bson"
+	"github.com/labstack/echo"
+)
+
+// roles of a person
+const (
+	RoleStudent = "student"
+	RoleTeacher = "teacher"
+	RoleAdmin   = "admin"
+)
+
+// permissions granted to roles
+const (
+	PermTeach           = "teach"
+	PermManageClasses   = "manage:classes"
+	PermManageLocations = "manage:locations"
+	PermManagePersons   = "manage:persons"
+	PermManageKeys      = "manage:keys"
+	PermManageTerms     = "manage:terms"
+	PermManageWebhooks  = "manage:webhooks"
+)
+
+// rolePermissions lists the permissions of each role
+var rolePermissions = map[string][]string{
+	RoleStudent: {},
+	RoleTeacher: {PermTeach},
+	RoleAdmin:   {PermManageClasses, PermManageLocations, PermManagePersons, PermManageKeys, PermManageTerms, PermManageWebhooks},
+}
+
+// context keys of the current person and login
+const (
+	personKey = "person"
+	loginKey  = "login"
+)
+
+// LoadPerson is middleware that finds the person of the jwt
+// once and attaches it to the context for handlers
+func LoadPerson(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		person := Person{}
+
+		// get person id from jwt
+		token, ok := c.Get("user").(*jwt.Token)
+		if !ok {
+			return c.JSON(401, server.Error("Missing token", 401))
+		}
+		claims := token.Claims.(jwt.MapClaims)
+		id, _ := claims["id"].(string)
+		sid, _ := claims["sid"].(string)
+		if !bson.IsObjectIdHex(id) || !bson.IsObjectIdHex(sid) {
+			return c.JSON(401, server.Error("Invalid token", 401))
+		}
+		person.ID = bson.ObjectIdHex(id)
+
+		// ensure login session has not been revoked
+		login := Login{ID: bson.ObjectIdHex(sid)}
+		err := login.Find()
+		if err != nil || login.Person != person.ID || !login.Active(time.Now()) {
+			return c.JSON(401, server.Error(ErrLoginRevoked, 401))
+		}
+
+		// find person in db
+		err = person.Find()
+		if err != nil {
+			return c.JSON(401, server.Error(err, 401))
+		}
+		if person.Deactivated() {
+			return c.JSON(401, server.Error(ErrDeactivated, 401))
+		}
+
+		c.Set(personKey, &person)
+		c.Set(loginKey, &login)
+		return next(c)
+	}
+}
+
+// RequirePermission is middleware allowing only persons
+// whose role grants permission
+func RequirePermission(permission string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !currentPerson(c).Can(permission) {
+				return c.JSON(403, server.Error("Your role can not access this resource", 403))
+			}
+			return next(c)
+		}
+	}
+}
+
+// Can returns true if the role of the person grants permission
+func (p *Person) Can(permission string) bool {
+	for _, granted := range rolePermissions[p.Role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidRole returns true if role is a known role
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// currentPerson returns the person attached to the context by LoadPerson
+func currentPerson(c echo.Context) *Person {
+	return c.Get(personKey).(*Person)
+}
+
+// currentLogin returns the login attached to the context by LoadPerson
+func currentLogin(c echo.Context) *Login {
+	return c.Get(loginKey).(*Login)
+}
@@ -0,0 +1,93 @@
+package attendance
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// errors returned when a check in is rejected
+var (
+	ErrNoSession        = errors.New("Class is not in session")
+	ErrNotEnrolled      = errors.New("Person is not enrolled in this class")
+	ErrAlreadyCheckedIn = errors.New("Already checked in to this class session")
+	ErrNetworkDenied    = errors.New("Check in is not allowed from this network")
+	ErrInvalidCode      = errors.New("Invalid or expired check in code")
+	ErrClassArchived    = errors.New("Class is archived")
+)
+
+// checkInError is the http status and error code of a rejected check in
+type checkInError struct {
+	status int
+	code   string
+}
+
+// checkInErrors maps check in errors to their responses
+var checkInErrors = map[error]checkInError{
+	ErrNoSession:        {403, "no_session"},
+	ErrNotEnrolled:      {403, "not_enrolled"},
+	ErrAlreadyCheckedIn: {409, "already_checked_in"},
+	ErrNetworkDenied:    {403, "network_denied"},
+	ErrInvalidCode:      {403, "invalid_code"},
+	ErrClassArchived:    {403, "class_archived"},
+	ErrInvalidQR:        {403, "invalid_qr"},
+}
+
+// HasStudent returns true if id is enrolled in the class
+func (c *Class) HasStudent(id bson.ObjectId) bool {
+	for _, student := range c.Students {
+		if student == id {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckIn records attendance of person for the session of the class
+// taking place at t from a client at ip
+func (c *Class) CheckIn(person *Person, ip net.IP, t time.Time) (*Attendance, error) {
+
+	// ensure person is a student of the class
+	if !c.HasStudent(person.ID) {
+		return nil, ErrNotEnrolled
+	}
+	if c.Archived() {
+		return nil, ErrClassArchived
+	}
+
+	// find session for current time slot
+	session, err := c.CurrentSession(t)
+	if err != nil {
+		return nil, err
+	}
+
+	// ensure client is on the network of the class location
+	if c.LocationID != "" {
+		location := Location{ID: c.LocationID}
+		err = location.Find()
+		if err != nil {
+			return nil, err
+		}
+		if !location.Allows(ip) {
+			return nil, ErrNetworkDenied
+		}
+	}
+
+	// create attendance record
+	record := Attendance{
+		Class:     c.ID,
+		Student:   person.ID,
+		Session:   session.ID,
+		CheckedIn: t,
+		Status:    c.Policy.Status(session, t),
+	}
+	err = record.Create()
+	if err != nil {
+		return nil, err
+	}
+	publishCheckIn(record)
+
+	return &record, nil
+}
@@ -0,0 +1,125 @@
+package attendance
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// ErrVersionMismatch is returned when a class changed since
+// the version an update was based on
+var ErrVersionMismatch = errors.New("Class was changed since it was read")
+
+// EventClassUpdated is the type of events of changes to the fields,
+// schedule, policy or archive state of a class
+const EventClassUpdated = "class.updated"
+
+// classPatch holds the fields of a class to change, leaving
+// fields that are nil as they are
+type classPatch struct {
+	Title      *string        `json:"title"`
+	Instructor *bson.ObjectId `json:"instructor"`
+	StartTime  *time.Time     `json:"start_time"`
+	EndTime    *time.Time     `json:"end_time"`
+	StartDate  *time.Time     `json:"start_date"`
+	EndDate    *time.Time     `json:"end_date"`
+	Location   *string        `json:"location"`
+	LocationID *bson.ObjectId `json:"location_id"`
+	Timezone   *string        `json:"timezone"`
+	Term       *bson.ObjectId `json:"term"`
+	Version    *int           `json:"version"`
+}
+
+// apply sets the fields of the patch on class
+func (p *classPatch) apply(class *Class) {
+	if p.Title != nil {
+		class.Title = *p.Title
+	}
+	if p.Instructor != nil {
+		class.Instructor = *p.Instructor
+	}
+	if p.StartTime != nil {
+		class.StartTime = *p.StartTime
+	}
+	if p.EndTime != nil {
+		class.EndTime = *p.EndTime
+	}
+	if p.StartDate != nil {
+		class.StartDate = *p.StartDate
+	}
+	if p.EndDate != nil {
+		class.EndDate = *p.EndDate
+	}
+	if p.Location != nil {
+		class.Location = *p.Location
+	}
+	if p.LocationID != nil {
+		class.LocationID = *p.LocationID
+	}
+	if p.Timezone != nil {
+		class.Timezone = *p.Timezone
+	}
+	if p.Term != nil {
+		class.Term = *p.Term
+	}
+}
+
+// setFields copies the fields saved by Class.Update from class. Students,
+// schedule, policy and code have their own updates so concurrent changes
+// to them are never overwritten.
+func (c *Class) setFields(class *Class) {
+	c.Title = class.Title
+	c.Instructor = class.Instructor
+	c.StartTime = class.StartTime
+	c.EndTime = class.EndTime
+	c.StartDate = class.StartDate
+	c.EndDate = class.EndDate
+	c.Location = class.Location
+	c.LocationID = class.LocationID
+	c.Timezone = class.Timezone
+	c.Term = class.Term
+	c.ArchivedAt = class.ArchivedAt
+	c.Version = class.Version
+}
+
+// Archived returns true if the class was archived
+func (c *Class) Archived() bool {
+	return c.ArchivedAt != nil
+}
+
+// ETag returns the entity tag of the version of the class
+func (c *Class) ETag() string {
+	return strconv.Quote(strconv.Itoa(c.Version))
+}
+
+// parseIfMatch returns the class version of an If-Match header
+func parseIfMatch(value string) (int, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "W/")
+	unquoted, err := strconv.Unquote(value)
+	if err != nil {
+		return 0, errors.New("If-Match must be the quoted version of the class")
+	}
+	version, err := strconv.Atoi(unquoted)
+	if err != nil {
+		return 0, errors.New("If-Match must be the quoted version of the class")
+	}
+	return version, nil
+}
+
+// matches returns true if class is selected by the filter
+func (f ClassFilter) matches(class *Class) bool {
+	if f.IDs != nil && !containsID(f.IDs, class.ID) {
+		return false
+	}
+	if f.Search != "" && !strings.Contains(strings.ToLower(class.Title), f.Search) &&
+		!strings.Contains(strings.ToLower(class.Location), f.Search) {
+		return false
+	}
+	return (f.Instructor == "" || class.Instructor == f.Instructor) &&
+		(f.Term == "" || class.Term == f.Term) &&
+		(f.Location == "" || class.LocationID == f.Location) &&
+		class.Archived() == f.Archived
+}
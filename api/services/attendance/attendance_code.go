@@ -0,0 +1,93 @@
+package attendance
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// check in code rotation limits in seconds
+const (
+	defaultCodePeriod = 30
+	minCodePeriod     = 10
+	maxCodePeriod     = 600
+)
+
+// ValidateCodePeriod applies the default code period and ensures
+// it is within the allowed range
+func (c *Class) ValidateCodePeriod() error {
+	if c.CodePeriod == 0 {
+		c.CodePeriod = defaultCodePeriod
+	}
+	if c.CodePeriod < minCodePeriod || c.CodePeriod > maxCodePeriod {
+		return fmt.Errorf("Code period must be between %d and %d seconds", minCodePeriod, maxCodePeriod)
+	}
+	return nil
+}
+
+// GenerateCodeSecret sets a new random secret for check in codes
+func (c *Class) GenerateCodeSecret() error {
+	secret := make([]byte, 20)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return err
+	}
+	c.CodeSecret = base32.StdEncoding.EncodeToString(secret)
+	return nil
+}
+
+// Code returns the check in code of the class for the window containing t
+// along with the time the code expires
+func (c *Class) Code(t time.Time) (string, time.Time, error) {
+	period := c.codePeriod()
+	window := t.Unix() / period
+	code, err := c.codeFor(window)
+	return code, time.Unix((window+1)*period, 0), err
+}
+
+// VerifyCode returns true if code is valid for the window
+// containing t or the window before it
+func (c *Class) VerifyCode(code string, t time.Time) bool {
+	window := t.Unix() / c.codePeriod()
+	for _, w := range []int64{window, window - 1} {
+		expected, err := c.codeFor(w)
+		if err != nil {
+			return false
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(expected)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// codePeriod returns the code rotation period in seconds
+func (c *Class) codePeriod() int64 {
+	if c.CodePeriod <= 0 {
+		return defaultCodePeriod
+	}
+	return int64(c.CodePeriod)
+}
+
+// codeFor computes the six digit code of a window as in RFC 4226
+func (c *Class) codeFor(window int64) (string, error) {
+	secret, err := base32.StdEncoding.DecodeString(c.CodeSecret)
+	if err != nil || len(secret) == 0 {
+		return "", fmt.Errorf("Class has no code secret")
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(window))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000), nil
+}
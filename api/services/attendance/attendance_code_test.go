@@ -0,0 +1,53 @@
+package attendance
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+func TestCodesMatchRFC4226(t *testing.T) {
+	class := Class{CodeSecret: base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))}
+	for window, want := range []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"} {
+		code, err := class.codeFor(int64(window))
+		if err != nil || code != want {
+			t.Errorf("codeFor(%d) = %s, %v, want %s", window, code, err, want)
+		}
+	}
+}
+
+func TestCodesRotateEachPeriod(t *testing.T) {
+	class := Class{CodePeriod: 30}
+	if err := class.GenerateCodeSecret(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1800000000, 0)
+
+	code, expires, err := class.Code(start.Add(10 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expires.Equal(start.Add(30 * time.Second)) {
+		t.Errorf("code expires at %s, want %s", expires, start.Add(30*time.Second))
+	}
+
+	// codes are valid in their window and the window after it only
+	for _, c := range []struct {
+		after time.Duration
+		valid bool
+	}{
+		{-time.Second, false},
+		{0, true},
+		{59 * time.Second, true},
+		{60 * time.Second, false},
+	} {
+		if got := class.VerifyCode(code, start.Add(c.after)); got != c.valid {
+			t.Errorf("VerifyCode() %s after the window starts = %v, want %v", c.after, got, c.valid)
+		}
+	}
+
+	// classes without a secret have no codes
+	if _, _, err := (&Class{}).Code(start); err == nil {
+		t.Error("Code() of a class without a secret succeeded")
+	}
+}
@@ -0,0 +1,126 @@
+package attendance
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// nextSessionHorizon bounds how far ahead the next session is looked for
+const nextSessionHorizon = 366 * 24 * time.Hour
+
+// ClassSession is a session of a class, with live attendance counts
+// when it is looked up by the instructor of the class
+type ClassSession struct {
+	Class   Class          `json:"class"`
+	Session Session        `json:"session"`
+	Counts  *SessionCounts `json:"counts,omitempty"`
+}
+
+// SessionCounts are the number of students of a session by status.
+// Pending students have not checked in yet.
+type SessionCounts struct {
+	Enrolled int `json:"enrolled"`
+	Present  int `json:"present"`
+	Late     int `json:"late"`
+	Absent   int `json:"absent"`
+	Excused  int `json:"excused"`
+	Pending  int `json:"pending"`
+}
+
+// CurrentClass is the class session a person is in at a time and the
+// next session to start after it, with the seconds until it starts
+type CurrentClass struct {
+	Current  *ClassSession `json:"current"`
+	Next     *ClassSession `json:"next"`
+	StartsIn int64         `json:"starts_in,omitempty"`
+}
+
+// FindCurrentClass finds the class session person attends or teaches at t
+// and the next one to start. Sessions are expanded in the timezone of
+// their class.
+func FindCurrentClass(person *Person, t time.Time) (*CurrentClass, error) {
+	current := &CurrentClass{}
+
+	// find classes person is enrolled in or teaches
+	classes, err := FindClasses(person.Classes)
+	if err != nil {
+		return nil, err
+	}
+	taught, err := FindInstructorClasses(person.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, class := range taught {
+		if !containsID(person.Classes, class.ID) {
+			classes = append(classes, class)
+		}
+	}
+
+	// find earliest current and next sessions
+	for _, class := range classes {
+		if class.Archived() {
+			continue
+		}
+		sessions, err := class.Sessions(t, t.Add(nextSessionHorizon))
+		if err != nil {
+			return nil, err
+		}
+		for _, session := range sessions {
+			found := &ClassSession{Class: class, Session: session}
+			if !session.Start.After(t) {
+				if current.Current == nil || session.Start.Before(current.Current.Session.Start) {
+					current.Current = found
+				}
+				continue
+			}
+			if current.Next == nil || session.Start.Before(current.Next.Session.Start) {
+				current.Next = found
+			}
+			break
+		}
+	}
+	if current.Next != nil {
+		current.StartsIn = int64(current.Next.Session.Start.Sub(t) / time.Second)
+	}
+
+	// count attendance of the session person is teaching
+	if current.Current != nil && current.Current.Class.Instructor == person.ID {
+		current.Current.Counts, err = CountSession(&current.Current.Class, current.Current.Session.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return current, nil
+}
+
+// CountSession counts the enrolled students of a class session by status
+func CountSession(class *Class, session string) (*SessionCounts, error) {
+	records, err := FindClassAttendance(class.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := &SessionCounts{Enrolled: len(class.Students)}
+	checkedIn := map[bson.ObjectId]bool{}
+	for _, record := range records {
+		if record.Session != session || !class.HasStudent(record.Student) {
+			continue
+		}
+		checkedIn[record.Student] = true
+		switch statusOf(record) {
+		case StatusPresent:
+			counts.Present++
+		case StatusLate:
+			counts.Late++
+		case StatusAbsent:
+			counts.Absent++
+		case StatusExcused:
+			counts.Excused++
+		}
+	}
+	counts.Pending = counts.Enrolled - len(checkedIn)
+
+	return counts, nil
+}
@@ -0,0 +1,97 @@
+package attendance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/edwintcloud/classmate/api/services/server"
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestFindCurrentClass(t *testing.T) {
+	s = &server.Server{Events: server.NewEventBus()}
+	dispatcher = nil
+
+	// an evening and a morning class meeting daily January 5 to 9
+	evening := scheduleClass(t, "America/New_York")
+	morning := *evening
+	morning.Title = "Morning class"
+	morning.StartTime = evening.StartTime.Add(-9 * time.Hour)
+	morning.EndTime = evening.EndTime.Add(-10 * time.Hour)
+	for _, class := range []*Class{evening, &morning} {
+		if err := class.Create(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	student := Person{ID: bson.NewObjectId(), Email: "student@example.com", Role: RoleStudent, Classes: []bson.ObjectId{}}
+	if err := db.Persons.Insert(&student); err != nil {
+		t.Fatal(err)
+	}
+	for _, class := range []*Class{evening, &morning} {
+		if _, err := class.Enroll(&student); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := student.Find(); err != nil {
+		t.Fatal(err)
+	}
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 1, day, hour, minute, 0, 0, newYork)
+	}
+	cases := []struct {
+		name          string
+		t             time.Time
+		current, next *Class
+		start, starts time.Time
+	}{
+		{"before first session", at(6, 8, 0), nil, &morning, time.Time{}, at(6, 9, 0)},
+		{"at start of session", at(6, 9, 0), &morning, evening, at(6, 9, 0), at(6, 18, 0)},
+		{"between sessions", at(6, 12, 0), nil, evening, time.Time{}, at(6, 18, 0)},
+		{"last minute of session", at(6, 19, 59), evening, &morning, at(6, 18, 0), at(7, 9, 0)},
+		{"last session", at(9, 19, 0), evening, nil, at(9, 18, 0), time.Time{}},
+		{"after classes end", at(10, 9, 30), nil, nil, time.Time{}, time.Time{}},
+	}
+	for _, c := range cases {
+		found, err := FindCurrentClass(&student, c.t)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		switch {
+		case c.current == nil && found.Current != nil:
+			t.Errorf("%s: current = %s at %s, want none", c.name, found.Current.Class.Title, found.Current.Session.Start)
+		case c.current != nil && (found.Current == nil || found.Current.Class.ID != c.current.ID || !found.Current.Session.Start.Equal(c.start)):
+			t.Errorf("%s: current = %+v, want %s at %s", c.name, found.Current, c.current.Title, c.start)
+		case found.Current != nil && found.Current.Counts != nil:
+			t.Errorf("%s: students see counts of their sessions", c.name)
+		}
+		switch {
+		case c.next == nil && (found.Next != nil || found.StartsIn != 0):
+			t.Errorf("%s: next = %+v in %ds, want none", c.name, found.Next, found.StartsIn)
+		case c.next != nil && (found.Next == nil || found.Next.Class.ID != c.next.ID || !found.Next.Session.Start.Equal(c.starts)):
+			t.Errorf("%s: next = %+v, want %s at %s", c.name, found.Next, c.next.Title, c.starts)
+		case c.next != nil && found.StartsIn != int64(c.starts.Sub(c.t)/time.Second):
+			t.Errorf("%s: next starts in %ds, want %s", c.name, found.StartsIn, c.starts.Sub(c.t))
+		}
+	}
+
+	// instructors see the sessions they teach with attendance counts
+	teacher := Person{ID: evening.Instructor}
+	if err := teacher.Find(); err != nil {
+		t.Fatal(err)
+	}
+	found, err := FindCurrentClass(&teacher, at(6, 18, 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Current == nil || found.Current.Class.ID != evening.ID {
+		t.Fatalf("teacher current = %+v", found.Current)
+	}
+	if counts := found.Current.Counts; counts == nil || counts.Enrolled != 1 || counts.Pending != 1 {
+		t.Errorf("teacher counts = %+v, want 1 pending of 1", counts)
+	}
+}
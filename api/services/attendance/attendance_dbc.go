@@ -3,7 +3,7 @@ package attendance
 import (
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/edwintcloud/classmate/api/services/server"
 	"github.com/globalsign/mgo/bson"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -12,9 +12,18 @@ import (
 func (p *Person) Create() (err error) {
 
 	// assign role of student
-	p.Role = "student"
+	p.Role = RoleStudent
 
-	// TODO: validation
+	// assign id and start with no classes
+	p.ID = bson.NewObjectId()
+	p.Classes = []bson.ObjectId{}
+
+	// validate person
+	p.Email = normalizeEmail(p.Email)
+	err = p.Validate()
+	if err != nil {
+		return err
+	}
 
 	// hash password
 	password, err := bcrypt.GenerateFromPassword([]byte(p.Password), 6)
@@ -23,9 +32,101 @@ func (p *Person) Create() (err error) {
 	}
 	p.Password = string(password)
 
-	// create new person in db
-	return db.persons.Insert(&p)
+	// create new person in db, failing if the
+	// email was registered since validation
+	err = p.Insert()
+	if err == ErrDuplicate {
+		return server.ValidationErrors{{Field: "email", Code: CodeTaken, Message: "Email is already registered"}}
+	}
+	return err
+
+}
+
+// Insert a person into the db as is
+func (p *Person) Insert() error {
+	return db.Persons.Insert(p)
+}
+
+// UpdateNames saves the first and last name of a person
+func (p *Person) UpdateNames() error {
+	return db.Persons.UpdateNames(p.ID, p.FirstName, p.LastName)
+}
+
+// UpdateRole saves the role of a person
+func (p *Person) UpdateRole() error {
+	return db.Persons.UpdateRole(p.ID, p.Role)
+}
+
+// UpdateProfile saves the names and email of a person
+func (p *Person) UpdateProfile() error {
+	return db.Persons.UpdateProfile(p.ID, p.FirstName, p.LastName, p.Email)
+}
+
+// UpdatePassword saves the password hash of a person
+func (p *Person) UpdatePassword() error {
+	return db.Persons.UpdatePassword(p.ID, p.Password)
+}
+
+// UpdateDeactivated saves when a person was deactivated
+func (p *Person) UpdateDeactivated() error {
+	return db.Persons.SetDeactivated(p.ID, p.DeactivatedAt)
+}
+
+// FindPersonsBy finds the persons matching filter in the
+// order and page of opts
+func FindPersonsBy(filter PersonFilter, opts listOptions) ([]Person, error) {
+	return db.Persons.FindByFilter(filter, opts)
+}
+
+// CountPersonsBy counts the persons matching filter
+func CountPersonsBy(filter PersonFilter) (int, error) {
+	return db.Persons.CountByFilter(filter)
+}
+
+// FindByInviteToken finds a person by their invitation token,
+// failing if it expired by t
+func (p *Person) FindByInviteToken(token string, t time.Time) error {
+	err := db.Persons.FindByInviteToken(server.HashToken(token), p)
+	if err == nil && p.InviteExpired(t) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// UpdateInviteToken saves the invitation token hash of a person
+func (p *Person) UpdateInviteToken() error {
+	return db.Persons.UpdateInviteToken(p.ID, p.InviteToken, p.InvitedAt)
+}
+
+// AcceptInvite sets the password of an invited person and
+// clears their invitation
+func (p *Person) AcceptInvite(password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), 6)
+	if err != nil {
+		return err
+	}
+	p.Password = string(hash)
+	return db.Persons.AcceptInvite(p.ID, p.Password)
+}
+
+// UpdateResetToken saves the password reset token hash of a person
+func (p *Person) UpdateResetToken() error {
+	return db.Persons.UpdateResetToken(p.ID, p.ResetToken, p.ResetExpires)
+}
 
+// ResetPassword sets the password of the person with an unexpired
+// reset token and clears the token so it can only be used once
+func (p *Person) ResetPassword(token, password string, t time.Time) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), 6)
+	if err != nil {
+		return err
+	}
+	return db.Persons.ResetPassword(server.HashToken(token), string(hash), t, p)
+}
+
+// EmailTaken reports whether a person other than except has email
+func EmailTaken(email string, except bson.ObjectId) (bool, error) {
+	return db.Persons.EmailTaken(normalizeEmail(email), except)
 }
 
 // Find finds a person by id or email depending on if id is set
@@ -33,11 +134,54 @@ func (p *Person) Find() error {
 
 	if bson.IsObjectIdHex(p.ID.Hex()) {
 		// find by id
-		return db.persons.FindId(p.ID).One(&p)
+		return db.Persons.Find(p.ID, p)
 	}
 
 	// else find by email
-	return db.persons.Find(bson.M{"email": p.Email}).One(&p)
+	return db.Persons.FindByEmail(normalizeEmail(p.Email), p)
+}
+
+// FindPersonByRef finds a person by id or, if ref is not an id, by email
+func FindPersonByRef(ref string) (Person, error) {
+	person := Person{}
+	if bson.IsObjectIdHex(ref) {
+		person.ID = bson.ObjectIdHex(ref)
+	} else {
+		person.Email = ref
+	}
+	err := person.Find()
+	return person, err
+}
+
+// AddClass adds a class to the classes of a person
+func (p *Person) AddClass(id bson.ObjectId) error {
+	return db.Persons.AddClass(p.ID, id)
+}
+
+// RemoveClass removes a class from the classes of a person
+func (p *Person) RemoveClass(id bson.ObjectId) error {
+	return db.Persons.RemoveClass(p.ID, id)
+}
+
+// FindPersonEnrollments finds the classes of every person
+func FindPersonEnrollments() ([]Person, error) {
+	return db.Persons.FindEnrollments()
+}
+
+// FindPersons finds all persons with the given ids
+// ordered by last and first name
+func FindPersons(ids []bson.ObjectId) ([]Person, error) {
+	return db.Persons.FindAll(ids)
+}
+
+// FindByFeedToken finds a person by their calendar feed token
+func (p *Person) FindByFeedToken(token string) error {
+	return db.Persons.FindByFeedToken(server.HashToken(token), p)
+}
+
+// UpdateFeedToken saves the calendar feed token hash of a person
+func (p *Person) UpdateFeedToken() error {
+	return db.Persons.UpdateFeedToken(p.ID, p.FeedToken)
 }
 
 // Authenticate authenticates a person an generates an authorization jwt
@@ -49,33 +193,322 @@ func (p *Person) Authenticate(password string) error {
 		return err
 	}
 
+	// ensure invitation has been accepted
+	if p.Password == "" {
+		return ErrNotActivated
+	}
+
 	// ensure password matches
 	err = bcrypt.CompareHashAndPassword([]byte(p.Password), []byte(password))
 	if err != nil {
 		return err
 	}
 
-	// generate jwt token
-	token := jwt.New(jwt.SigningMethodHS256)
+	// ensure account has not been deactivated
+	if p.Deactivated() {
+		return ErrDeactivated
+	}
 
-	// set jwt claims
-	claims := token.Claims.(jwt.MapClaims)
-	claims["id"] = p.ID.Hex()
-	claims["exp"] = time.Now().Add(time.Hour * 72).Unix()
+	// start login session and issue its tokens
+	return p.StartLogin(time.Now())
 
-	// set person token to generated jwt
-	p.Token, err = token.SignedString(s.JwtSecret)
+}
 
-	return err
+// Create a class with a new id at version 0, not archived
+// whatever the id, version and archival time of c
+func (c *Class) Create() error {
+	c.ID = bson.NewObjectId()
+	c.ArchivedAt = nil
+	c.Version = 0
 
+	// generate secret for check in codes
+	err := c.GenerateCodeSecret()
+	if err != nil {
+		return err
+	}
+
+	return db.Classes.Insert(c)
 }
 
-// Create a class
-func (c *Class) Create() error {
-	return db.classes.Insert(&c)
+// UpdateSchedule saves the timezone and schedule of a class
+func (c *Class) UpdateSchedule() error {
+	return db.Classes.UpdateSchedule(c.ID, c.Timezone, c.Schedule)
+}
+
+// UpdatePolicy saves the attendance policy of a class
+func (c *Class) UpdatePolicy() error {
+	return db.Classes.UpdatePolicy(c.ID, c.Policy)
+}
+
+// UpdateCode saves the code secret and period of a class
+func (c *Class) UpdateCode() error {
+	return db.Classes.UpdateCode(c.ID, c.CodeSecret, c.CodePeriod)
 }
 
 // Find a class by _id
 func (c *Class) Find() error {
-	return db.classes.FindId(c.ID).One(&c)
+	return db.Classes.Find(c.ID, c)
+}
+
+// AddStudent adds a student to the students of a class
+func (c *Class) AddStudent(id bson.ObjectId) error {
+	return db.Classes.AddStudent(c.ID, id)
+}
+
+// RemoveStudent removes a student from the students of a class
+func (c *Class) RemoveStudent(id bson.ObjectId) error {
+	return db.Classes.RemoveStudent(c.ID, id)
+}
+
+// FindEnrollments finds the students of every class
+func FindEnrollments() ([]Class, error) {
+	return db.Classes.FindEnrollments()
+}
+
+// FindClasses finds all classes with the given ids
+func FindClasses(ids []bson.ObjectId) ([]Class, error) {
+	return db.Classes.FindAll(ids)
+}
+
+// Create an attendance record, failing if the student
+// has already checked in to the session
+func (a *Attendance) Create() error {
+	if a.ID == "" {
+		a.ID = bson.NewObjectId()
+	}
+	err := db.Attendance.Insert(a)
+	if err == ErrDuplicate {
+		return ErrAlreadyCheckedIn
+	}
+	return err
+}
+
+// UpdateStatus saves the status of an attendance record
+func (a *Attendance) UpdateStatus() error {
+	return db.Attendance.UpdateStatus(a.ID, a.Status)
+}
+
+// Excuse marks a session as excused for a student, creating
+// the attendance record if they never checked in
+func (a *Attendance) Excuse() error {
+	return db.Attendance.SetStatus(a.Class, a.Student, a.Session, a.Status)
+}
+
+// FindClassAttendance finds all attendance records of a class
+func FindClassAttendance(class bson.ObjectId) ([]Attendance, error) {
+	return db.Attendance.FindByClass(class)
+}
+
+// FindStudentAttendance finds all attendance records of a student
+func FindStudentAttendance(student bson.ObjectId) ([]Attendance, error) {
+	return db.Attendance.FindByStudent(student)
+}
+
+// Create an excuse
+func (e *Excuse) Create() error {
+	return db.Excuses.Insert(e)
+}
+
+// Find an excuse by _id
+func (e *Excuse) Find() error {
+	return db.Excuses.Find(e.ID, e)
+}
+
+// UpdateStatus applies event to an excuse that is still in status from
+func (e *Excuse) UpdateStatus(from string, event ExcuseEvent) error {
+	err := db.Excuses.UpdateStatus(e.ID, from, event)
+	if err == ErrNotFound {
+		return ErrExcuseTransition
+	}
+	return err
+}
+
+// FindExcuses finds excuses matching filter without attachment
+// data, newest first
+func FindExcuses(filter ExcuseFilter) ([]Excuse, error) {
+	return db.Excuses.FindAll(filter)
+}
+
+// Update saves the editable fields and archive state of a class
+// if it is still at version, incrementing its version
+func (c *Class) Update(version int) error {
+	err := db.Classes.Update(c, version)
+	if err == ErrNotFound && db.Classes.Find(c.ID, &Class{}) == nil {
+		return ErrVersionMismatch
+	}
+	return err
+}
+
+// FindClassesBy finds the classes matching filter in the
+// order and page of opts
+func FindClassesBy(filter ClassFilter, opts listOptions) ([]Class, error) {
+	return db.Classes.FindByFilter(filter, opts)
+}
+
+// CountClassesBy counts the classes matching filter
+func CountClassesBy(filter ClassFilter) (int, error) {
+	return db.Classes.CountByFilter(filter)
+}
+
+// FindMissingClasses returns the ids of ids no class has
+func FindMissingClasses(ids []bson.ObjectId) ([]bson.ObjectId, error) {
+	return db.Classes.FindMissing(ids)
+}
+
+// FindInstructorClasses finds all classes taught by instructor
+func FindInstructorClasses(instructor bson.ObjectId) ([]Class, error) {
+	return db.Classes.FindByInstructor(instructor)
+}
+
+// Create a location
+func (l *Location) Create() error {
+	l.ID = bson.NewObjectId()
+	return db.Locations.Insert(l)
+}
+
+// Find a location by _id
+func (l *Location) Find() error {
+	return db.Locations.Find(l.ID, l)
+}
+
+// Update a location by _id
+func (l *Location) Update() error {
+	return db.Locations.Update(l)
+}
+
+// FindLocations finds all locations
+func FindLocations() ([]Location, error) {
+	return db.Locations.FindAll()
+}
+
+// Create a login
+func (l *Login) Create() error {
+	l.ID = bson.NewObjectId()
+	return db.Logins.Insert(l)
+}
+
+// Find a login by _id
+func (l *Login) Find() error {
+	return db.Logins.Find(l.ID, l)
+}
+
+// FindByToken finds an active login by its refresh token
+func (l *Login) FindByToken(token string, t time.Time) error {
+	return db.Logins.FindByToken(server.HashToken(token), t, l)
+}
+
+// Renew replaces the refresh token hash of a login, failing with
+// ErrNotFound if the token was already replaced or revoked
+func (l *Login) Renew(previous string) error {
+	return db.Logins.Renew(l, previous)
+}
+
+// Revoke a login by _id
+func (l *Login) Revoke() error {
+	return db.Logins.Revoke(l.ID, *l.RevokedAt)
+}
+
+// RevokeLogins revokes all active logins of person
+func RevokeLogins(person bson.ObjectId, t time.Time) (int, error) {
+	return db.Logins.RevokeAll(person, t)
+}
+
+// Create a term
+func (t *Term) Create() error {
+	t.ID = bson.NewObjectId()
+	return db.Terms.Insert(t)
+}
+
+// Find a term by _id
+func (t *Term) Find() error {
+	return db.Terms.Find(t.ID, t)
+}
+
+// Update replaces a term
+func (t *Term) Update() error {
+	return db.Terms.Update(t)
+}
+
+// Delete removes a term
+func (t *Term) Delete() error {
+	return db.Terms.Delete(t.ID)
+}
+
+// CountClasses counts the classes belonging to a term
+func (t *Term) CountClasses() (int, error) {
+	return db.Classes.CountByTerm(t.ID)
+}
+
+// FindTerms finds all terms, latest to start first
+func FindTerms() ([]Term, error) {
+	return db.Terms.FindAll()
+}
+
+// Create a webhook with a new id
+func (w *Webhook) Create(t time.Time) error {
+	w.ID = bson.NewObjectId()
+	w.CreatedAt = t
+	return db.Webhooks.Insert(w)
+}
+
+// Find a webhook by _id
+func (w *Webhook) Find() error {
+	return db.Webhooks.Find(w.ID, w)
+}
+
+// Update replaces a webhook
+func (w *Webhook) Update() error {
+	return db.Webhooks.Update(w)
+}
+
+// Delete removes a webhook along with its deliveries
+func (w *Webhook) Delete() error {
+	err := db.Webhooks.Delete(w.ID)
+	if err != nil {
+		return err
+	}
+	return db.Deliveries.DeleteByWebhook(w.ID)
+}
+
+// FindWebhooks finds all webhooks in the order they were created
+func FindWebhooks() ([]Webhook, error) {
+	return db.Webhooks.FindAll()
+}
+
+// Create a pending delivery with a new id
+func (d *Delivery) Create(t time.Time) error {
+	d.ID = bson.NewObjectId()
+	d.Status = DeliveryPending
+	d.CreatedAt = t
+	d.UpdatedAt = t
+	return db.Deliveries.Insert(d)
+}
+
+// Find a delivery by _id
+func (d *Delivery) Find() error {
+	return db.Deliveries.Find(d.ID, d)
+}
+
+// Update replaces a delivery
+func (d *Delivery) Update() error {
+	return db.Deliveries.Update(d)
+}
+
+// Claim leases the next attempt of a delivery at t until until
+func (d *Delivery) Claim(t, until time.Time) error {
+	err := db.Deliveries.Claim(d.ID, d.Attempts, t, until)
+	if err == nil {
+		d.LeasedUntil = &until
+	}
+	return err
+}
+
+// FindDeliveries finds the deliveries of a webhook, newest first
+func FindDeliveries(webhook bson.ObjectId) ([]Delivery, error) {
+	return db.Deliveries.FindByWebhook(webhook)
+}
+
+// FindPendingDeliveries finds the deliveries still to be attempted
+func FindPendingDeliveries() ([]Delivery, error) {
+	return db.Deliveries.FindPending()
 }
@@ -0,0 +1,235 @@
+package attendance
+
+import (
+	"errors"
+	"log"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// errors of persons that can not be enrolled
+var (
+	ErrNotStudent         = errors.New("Only students can be enrolled")
+	ErrStudentDeactivated = errors.New("Deactivated students can not be enrolled")
+)
+
+// enrollment result statuses
+const (
+	EnrollmentEnrolled   = "enrolled"
+	EnrollmentUnenrolled = "unenrolled"
+	EnrollmentUnchanged  = "unchanged"
+	EnrollmentFailed     = "failed"
+)
+
+// types of enrollment events
+const (
+	EventEnrolled   = "enrollment.enrolled"
+	EventUnenrolled = "enrollment.unenrolled"
+)
+
+// EnrollmentResult is the outcome of enrolling or unenrolling one student
+type EnrollmentResult struct {
+	Student string        `json:"student"`
+	ID      bson.ObjectId `json:"_id,omitempty"`
+	Status  string        `json:"status"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// EnrollmentChange is a student enrolled in or unenrolled from a class
+type EnrollmentChange struct {
+	Class   bson.ObjectId `json:"class"`
+	Student bson.ObjectId `json:"student"`
+}
+
+// EnrollmentFix is a difference between Class.Students and Person.Classes
+// found by RepairEnrollment
+type EnrollmentFix struct {
+	Class  bson.ObjectId `json:"class"`
+	Person bson.ObjectId `json:"person"`
+	Action string        `json:"action"`
+}
+
+// Enroll adds person to the class, updating Person.Classes and then
+// Class.Students. If the class can not be updated the person is rolled
+// back so the two stay in sync. Only active students can be enrolled.
+func (c *Class) Enroll(person *Person) (string, error) {
+	if person.Role != RoleStudent {
+		return EnrollmentFailed, ErrNotStudent
+	}
+	if person.Deactivated() {
+		return EnrollmentFailed, ErrStudentDeactivated
+	}
+	if c.HasStudent(person.ID) && person.HasClass(c.ID) {
+		return EnrollmentUnchanged, nil
+	}
+
+	err := person.AddClass(c.ID)
+	if err != nil {
+		return EnrollmentFailed, err
+	}
+
+	err = c.AddStudent(person.ID)
+	if err != nil {
+		// roll back person, leaving drift for repair if that fails too
+		if rerr := person.RemoveClass(c.ID); rerr != nil {
+			log.Println("Unable to roll back enrollment:", rerr.Error())
+		}
+		return EnrollmentFailed, err
+	}
+
+	// keep class in sync for the rest of a batch
+	if !c.HasStudent(person.ID) {
+		c.Students = append(c.Students, person.ID)
+	}
+	publishEvent(EventEnrolled, c.ID, EnrollmentChange{Class: c.ID, Student: person.ID})
+
+	return EnrollmentEnrolled, nil
+}
+
+// Unenroll removes person from the class, updating Class.Students and
+// then Person.Classes. If the person can not be updated the class is
+// rolled back so the two stay in sync.
+func (c *Class) Unenroll(person *Person) (string, error) {
+	if !c.HasStudent(person.ID) && !person.HasClass(c.ID) {
+		return EnrollmentUnchanged, nil
+	}
+
+	err := c.RemoveStudent(person.ID)
+	if err != nil {
+		return EnrollmentFailed, err
+	}
+
+	err = person.RemoveClass(c.ID)
+	if err != nil {
+		// roll back class, leaving drift for repair if that fails too
+		if rerr := c.AddStudent(person.ID); rerr != nil {
+			log.Println("Unable to roll back unenrollment:", rerr.Error())
+		}
+		return EnrollmentFailed, err
+	}
+
+	// keep class in sync for the rest of a batch
+	students := []bson.ObjectId{}
+	for _, id := range c.Students {
+		if id != person.ID {
+			students = append(students, id)
+		}
+	}
+	c.Students = students
+	publishEvent(EventUnenrolled, c.ID, EnrollmentChange{Class: c.ID, Student: person.ID})
+
+	return EnrollmentUnenrolled, nil
+}
+
+// EnrollAll enrolls or unenrolls each student, given by id or email,
+// in the class and reports the outcome for each
+func (c *Class) EnrollAll(students []string, enroll bool) []EnrollmentResult {
+	results := []EnrollmentResult{}
+
+	for _, ref := range students {
+		result := EnrollmentResult{Student: ref}
+
+		person, err := FindPersonByRef(ref)
+		if err == nil {
+			result.ID = person.ID
+			if enroll {
+				result.Status, err = c.Enroll(&person)
+			} else {
+				result.Status, err = c.Unenroll(&person)
+			}
+		}
+		if err != nil {
+			result.Status = EnrollmentFailed
+			result.Error = err.Error()
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// HasClass returns true if the person is enrolled in class id
+func (p *Person) HasClass(id bson.ObjectId) bool {
+	for _, class := range p.Classes {
+		if class == id {
+			return true
+		}
+	}
+	return false
+}
+
+// RepairEnrollment finds and, unless dryRun is set, fixes drift between
+// Class.Students and Person.Classes. An enrollment found on either side
+// is added to the other, and references to deleted persons or classes
+// are removed.
+func RepairEnrollment(dryRun bool) ([]EnrollmentFix, error) {
+	fixes := []EnrollmentFix{}
+
+	classes, err := FindEnrollments()
+	if err != nil {
+		return nil, err
+	}
+	persons, err := FindPersonEnrollments()
+	if err != nil {
+		return nil, err
+	}
+
+	// index both sides
+	classByID := map[bson.ObjectId]*Class{}
+	for i := range classes {
+		classByID[classes[i].ID] = &classes[i]
+	}
+	personByID := map[bson.ObjectId]*Person{}
+	for i := range persons {
+		personByID[persons[i].ID] = &persons[i]
+	}
+
+	for _, class := range classes {
+		for _, id := range class.Students {
+			person, ok := personByID[id]
+			switch {
+			case !ok:
+				fixes = append(fixes, EnrollmentFix{class.ID, id, "remove_missing_student"})
+			case !person.HasClass(class.ID):
+				fixes = append(fixes, EnrollmentFix{class.ID, id, "add_class_to_person"})
+			}
+		}
+	}
+	for _, person := range persons {
+		for _, id := range person.Classes {
+			class, ok := classByID[id]
+			switch {
+			case !ok:
+				fixes = append(fixes, EnrollmentFix{id, person.ID, "remove_missing_class"})
+			case !class.HasStudent(person.ID):
+				fixes = append(fixes, EnrollmentFix{id, person.ID, "add_student_to_class"})
+			}
+		}
+	}
+
+	if dryRun {
+		return fixes, nil
+	}
+
+	// apply fixes
+	for _, fix := range fixes {
+		class := Class{ID: fix.Class}
+		person := Person{ID: fix.Person}
+		switch fix.Action {
+		case "remove_missing_student":
+			err = class.RemoveStudent(fix.Person)
+		case "add_class_to_person":
+			err = person.AddClass(fix.Class)
+		case "remove_missing_class":
+			err = person.RemoveClass(fix.Class)
+		case "add_student_to_class":
+			err = class.AddStudent(fix.Person)
+		}
+		if err != nil {
+			return fixes, err
+		}
+	}
+
+	return fixes, nil
+}
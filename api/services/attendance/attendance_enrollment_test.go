@@ -0,0 +1,64 @@
+package attendance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/edwintcloud/classmate/api/services/server"
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestEnrollOnlyActiveStudents(t *testing.T) {
+	db = NewMemoryStore()
+	s = &server.Server{Events: server.NewEventBus()}
+	dispatcher = nil
+
+	class := Class{ID: bson.NewObjectId(), Title: "Class", Instructor: bson.NewObjectId(), Students: []bson.ObjectId{}}
+	if err := db.Classes.Insert(&class); err != nil {
+		t.Fatal(err)
+	}
+	deactivated := time.Now()
+	persons := []Person{
+		{ID: bson.NewObjectId(), Email: "ada@example.com", Role: RoleStudent},
+		{ID: bson.NewObjectId(), Email: "grace@example.com", Role: RoleTeacher},
+		{ID: bson.NewObjectId(), Email: "alan@example.com", Role: RoleAdmin},
+		{ID: bson.NewObjectId(), Email: "edsger@example.com", Role: RoleStudent, DeactivatedAt: &deactivated},
+	}
+	for i := range persons {
+		persons[i].Classes = []bson.ObjectId{}
+		if err := db.Persons.Insert(&persons[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// each person that is not an active student fails on its own
+	results := class.EnrollAll([]string{"ada@example.com", persons[1].ID.Hex(), "alan@example.com", persons[3].ID.Hex()}, true)
+	want := []struct {
+		status string
+		err    error
+	}{
+		{EnrollmentEnrolled, nil},
+		{EnrollmentFailed, ErrNotStudent},
+		{EnrollmentFailed, ErrNotStudent},
+		{EnrollmentFailed, ErrStudentDeactivated},
+	}
+	for i, result := range results {
+		wantError := ""
+		if want[i].err != nil {
+			wantError = want[i].err.Error()
+		}
+		if result.ID != persons[i].ID || result.Status != want[i].status || result.Error != wantError {
+			t.Errorf("result %d = %+v, want %s %q", i, result, want[i].status, wantError)
+		}
+	}
+
+	// only the student is enrolled on either side
+	if err := class.Find(); err != nil || len(class.Students) != 1 || class.Students[0] != persons[0].ID {
+		t.Fatalf("class students = %v, %v", class.Students, err)
+	}
+	for i := range persons {
+		if err := persons[i].Find(); err != nil || persons[i].HasClass(class.ID) != (i == 0) {
+			t.Errorf("person %d classes = %v, %v", i, persons[i].Classes, err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package attendance
+
+import (
+	"errors"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// excuse statuses
+const (
+	ExcusePending   = "pending"
+	ExcuseApproved  = "approved"
+	ExcuseRejected  = "rejected"
+	ExcuseWithdrawn = "withdrawn"
+)
+
+// maxAttachmentSize is the largest excuse attachment accepted in bytes
+const maxAttachmentSize = 5 << 20
+
+// excuseAttempts is how often excusing the attendance of an
+// approved excuse is tried
+const excuseAttempts = 3
+
+// errors returned when an excuse can not be created or changed
+var (
+	ErrExcuseExists     = errors.New("An excuse has already been submitted for this session")
+	ErrExcuseTransition = errors.New("Excuse can not be changed from its current status")
+	ErrUnknownSession   = errors.New("Class has no such session")
+)
+
+// excuseTransitions lists the statuses each status can change to
+var excuseTransitions = map[string][]string{
+	ExcusePending: {ExcuseApproved, ExcuseRejected, ExcuseWithdrawn},
+}
+
+// Submit validates and creates a pending excuse for a session of class
+func (e *Excuse) Submit(class *Class, t time.Time) error {
+
+	// ensure person is a student of the class
+	if !class.HasStudent(e.Student) {
+		return ErrNotEnrolled
+	}
+
+	// ensure session is part of the class schedule
+	sessions, err := class.Sessions(class.StartDate, class.EndDate.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, session := range sessions {
+		found = found || session.ID == e.Session
+	}
+	if !found {
+		return ErrUnknownSession
+	}
+
+	e.ID = bson.NewObjectId()
+	e.Class = class.ID
+	e.Status = ExcusePending
+	e.Open = e.openKey()
+	e.CreatedAt = t
+	e.UpdatedAt = t
+	e.History = []ExcuseEvent{{Status: ExcusePending, By: e.Student, At: t}}
+
+	// the open key is unique so only one of concurrent submits is created
+	err = e.Create()
+	if err == ErrDuplicate {
+		return ErrExcuseExists
+	}
+	return err
+}
+
+// Transition changes the status of the excuse on behalf of by, keeping
+// the change in its history. Approving an excuse marks the attendance
+// of the session as excused. Approving an approved excuse excuses the
+// session again, so an approval whose attendance was not saved can be
+// retried.
+func (e *Excuse) Transition(status string, by bson.ObjectId, note string, t time.Time) error {
+	if e.Status == ExcuseApproved && status == ExcuseApproved {
+		return e.excuseAttendance()
+	}
+
+	// ensure transition is allowed
+	allowed := false
+	for _, next := range excuseTransitions[e.Status] {
+		allowed = allowed || next == status
+	}
+	if !allowed {
+		return ErrExcuseTransition
+	}
+
+	// apply transition if excuse is still in its current status
+	event := ExcuseEvent{Status: status, By: by, At: t, Note: note}
+	err := e.UpdateStatus(e.Status, event)
+	if err != nil {
+		return err
+	}
+	e.Status = status
+	e.UpdatedAt = t
+	e.History = append(e.History, event)
+
+	if status == ExcuseApproved {
+		return e.excuseAttendance()
+	}
+	return nil
+}
+
+// excuseAttendance marks the attendance of the session of the excuse
+// as excused, retrying failed writes as excusing is idempotent
+func (e *Excuse) excuseAttendance() error {
+	record := Attendance{
+		Class:   e.Class,
+		Student: e.Student,
+		Session: e.Session,
+		Status:  StatusExcused,
+	}
+	var err error
+	for attempt := 0; attempt < excuseAttempts; attempt++ {
+		err = record.Excuse()
+		if err == nil {
+			publishEvent(EventStatusChanged, record.Class, record)
+			return nil
+		}
+	}
+	return err
+}
+
+// openKey returns the key of the session of the excuse while it is
+// pending or approved and an empty key otherwise
+func (e *Excuse) openKey() string {
+	if e.Status != ExcusePending && e.Status != ExcuseApproved {
+		return ""
+	}
+	return e.Class.Hex() + "/" + e.Student.Hex() + "/" + e.Session
+}
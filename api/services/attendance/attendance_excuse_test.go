@@ -0,0 +1,111 @@
+package attendance
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edwintcloud/classmate/api/services/server"
+	"github.com/globalsign/mgo/bson"
+)
+
+// excuseClass returns a class meeting every day of 2026 with one student
+func excuseClass() *Class {
+	day := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	return &Class{
+		ID:        bson.NewObjectId(),
+		StartDate: day,
+		EndDate:   day.AddDate(0, 11, 30),
+		StartTime: day.Add(9 * time.Hour),
+		EndTime:   day.Add(10 * time.Hour),
+		Students:  []bson.ObjectId{bson.NewObjectId()},
+	}
+}
+
+func TestConcurrentSubmitsCreateOneExcuse(t *testing.T) {
+	s = &server.Server{Events: server.NewEventBus()}
+	dispatcher = nil
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			db = store
+			class := excuseClass()
+			now := time.Now()
+
+			// submit the same session at once
+			errs := make(chan error, 8)
+			wg := sync.WaitGroup{}
+			for i := 0; i < cap(errs); i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					excuse := Excuse{Student: class.Students[0], Session: "2026-03-02", Reason: "Ill"}
+					errs <- excuse.Submit(class, now)
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			created := 0
+			for err := range errs {
+				switch err {
+				case nil:
+					created++
+				case ErrExcuseExists:
+				default:
+					t.Fatal(err)
+				}
+			}
+			if created != 1 {
+				t.Fatalf("created %d excuses, want 1", created)
+			}
+
+			// a rejected excuse no longer blocks a new one
+			found, err := FindExcuses(ExcuseFilter{Classes: []bson.ObjectId{class.ID}})
+			if err != nil || len(found) != 1 {
+				t.Fatalf("excuses = %v, %v", found, err)
+			}
+			if err := found[0].Transition(ExcuseRejected, bson.NewObjectId(), "", now); err != nil {
+				t.Fatal(err)
+			}
+			again := Excuse{Student: class.Students[0], Session: "2026-03-02", Reason: "Ill"}
+			if err := again.Submit(class, now); err != nil {
+				t.Fatalf("Submit() after rejection = %v", err)
+			}
+		})
+	}
+}
+
+func TestApprovalExcusesAttendance(t *testing.T) {
+	s = &server.Server{Events: server.NewEventBus()}
+	dispatcher = nil
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			db = store
+			class := excuseClass()
+			now := time.Now()
+
+			excuse := Excuse{Student: class.Students[0], Session: "2026-03-02", Reason: "Ill"}
+			if err := excuse.Submit(class, now); err != nil {
+				t.Fatal(err)
+			}
+			if err := excuse.Transition(ExcuseApproved, bson.NewObjectId(), "", now); err != nil {
+				t.Fatal(err)
+			}
+			records, err := FindClassAttendance(class.ID)
+			if err != nil || len(records) != 1 || records[0].Status != StatusExcused {
+				t.Fatalf("records = %v, %v", records, err)
+			}
+
+			// approving again excuses the session again
+			if err := db.Attendance.SetStatus(class.ID, excuse.Student, excuse.Session, StatusAbsent); err != nil {
+				t.Fatal(err)
+			}
+			if err := excuse.Transition(ExcuseApproved, bson.NewObjectId(), "", now); err != nil {
+				t.Fatalf("Transition() again = %v", err)
+			}
+			records, err = FindClassAttendance(class.ID)
+			if err != nil || len(records) != 1 || records[0].Status != StatusExcused {
+				t.Fatalf("records after retry = %v, %v", records, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package attendance
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ical date time layouts
+const (
+	icalLocalLayout = "20060102T150405"
+	icalUTCLayout   = "20060102T150405Z"
+)
+
+// icalWriter writes RFC 5545 content lines
+type icalWriter struct {
+	buf bytes.Buffer
+}
+
+// Calendar renders the sessions of classes as an RFC 5545 calendar.
+// Classes with a named timezone use local times with a VTIMEZONE
+// definition, others use utc times.
+func Calendar(name string, classes []Class, now time.Time) ([]byte, error) {
+	w := icalWriter{}
+	w.line("BEGIN:VCALENDAR")
+	w.line("VERSION:2.0")
+	w.line("PRODID:-//classmate//attendance//EN")
+	w.line("CALSCALE:GREGORIAN")
+	w.line("METHOD:PUBLISH")
+	w.prop("X-WR-CALNAME", name)
+
+	events := icalWriter{}
+	zones := map[string][2]time.Time{}
+	for _, class := range classes {
+		sessions, err := class.Sessions(class.StartDate, class.EndDate.AddDate(0, 0, 1))
+		if err != nil {
+			return nil, err
+		}
+		if len(sessions) == 0 {
+			continue
+		}
+
+		// track range each timezone is used over
+		if class.Timezone != "" {
+			r, ok := zones[class.Timezone]
+			if !ok || sessions[0].Start.Before(r[0]) {
+				r[0] = sessions[0].Start
+			}
+			if !ok || sessions[len(sessions)-1].End.After(r[1]) {
+				r[1] = sessions[len(sessions)-1].End
+			}
+			zones[class.Timezone] = r
+		}
+
+		for _, session := range sessions {
+			events.line("BEGIN:VEVENT")
+			events.prop("UID", class.ID.Hex()+"-"+session.ID+"@classmate")
+			events.line("DTSTAMP:" + now.UTC().Format(icalUTCLayout))
+			events.time("DTSTART", class.Timezone, session.Start)
+			events.time("DTEND", class.Timezone, session.End)
+			events.prop("SUMMARY", class.Title)
+			if session.Location != "" {
+				events.prop("LOCATION", session.Location)
+			}
+			events.line("END:VEVENT")
+		}
+	}
+
+	// define timezones before the events referencing them
+	names := []string{}
+	for name := range zones {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, err
+		}
+		w.timezone(loc, zones[name][0], zones[name][1])
+	}
+
+	w.buf.Write(events.buf.Bytes())
+	w.line("END:VCALENDAR")
+
+	return w.buf.Bytes(), nil
+}
+
+// timezone writes a VTIMEZONE with the offset in effect at from
+// and every transition of loc up until to
+func (w *icalWriter) timezone(loc *time.Location, from, to time.Time) {
+	w.line("BEGIN:VTIMEZONE")
+	w.prop("TZID", loc.String())
+
+	start := from.In(loc)
+	w.observance(start, start)
+
+	// step through range a day at a time and search
+	// for the exact second of each offset change
+	for t := start; t.Before(to); {
+		next := t.Add(24 * time.Hour)
+		_, before := t.Zone()
+		_, after := next.In(loc).Zone()
+		if before != after {
+			lo, hi := t, next
+			for hi.Sub(lo) > time.Second {
+				mid := lo.Add(hi.Sub(lo) / 2)
+				if _, offset := mid.In(loc).Zone(); offset == before {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			w.observance(lo, hi.In(loc))
+		}
+		t = next.In(loc)
+	}
+
+	w.line("END:VTIMEZONE")
+}
+
+// observance writes a STANDARD or DAYLIGHT component for the
+// change from the offset in effect at prev to that at t
+func (w *icalWriter) observance(prev, t time.Time) {
+	kind := "STANDARD"
+	if t.IsDST() {
+		kind = "DAYLIGHT"
+	}
+	name, offset := t.Zone()
+	_, prevOffset := prev.Zone()
+
+	w.line("BEGIN:" + kind)
+	w.line("DTSTART:" + t.In(time.FixedZone("", prevOffset)).Format(icalLocalLayout))
+	w.line("TZOFFSETFROM:" + icalOffset(prevOffset))
+	w.line("TZOFFSETTO:" + icalOffset(offset))
+	w.prop("TZNAME", name)
+	w.line("END:" + kind)
+}
+
+// time writes a date time property in tzid, or utc if tzid is empty
+func (w *icalWriter) time(name, tzid string, t time.Time) {
+	if tzid == "" {
+		w.line(name + ":" + t.UTC().Format(icalUTCLayout))
+		return
+	}
+	w.line(name + ";TZID=" + tzid + ":" + t.Format(icalLocalLayout))
+}
+
+// prop writes a text property, escaping its value
+func (w *icalWriter) prop(name, value string) {
+	value = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(value)
+	w.line(name + ":" + value)
+}
+
+// line writes a content line folded at 75 octets
+func (w *icalWriter) line(value string) {
+	limit := 75
+	for len(value) > limit {
+		// avoid splitting multi byte characters
+		cut := limit
+		for cut > 0 && value[cut]&0xc0 == 0x80 {
+			cut--
+		}
+		w.buf.WriteString(value[:cut] + "\r\n ")
+		value = value[cut:]
+
+		// continuation lines start with a space
+		limit = 74
+	}
+	w.buf.WriteString(value + "\r\n")
+}
+
+// icalOffset formats a utc offset in seconds as +hhmm
+func icalOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, offset/3600, offset%3600/60)
+}
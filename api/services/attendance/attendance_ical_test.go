@@ -0,0 +1,64 @@
+package attendance
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestCalendarUsesClassTimezones(t *testing.T) {
+	class := Class{
+		ID:        bson.NewObjectId(),
+		Title:     "Algebra; sections 1, 2",
+		StartTime: time.Date(2026, 1, 1, 18, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(2026, 1, 1, 20, 0, 0, 0, time.UTC),
+		StartDate: time.Date(2026, 3, 6, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC),
+		Location:  "Room 101",
+		Timezone:  "America/New_York",
+	}
+	utc := class
+	utc.ID = bson.NewObjectId()
+	utc.Timezone = ""
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	data, err := Calendar("Classes of "+strings.Repeat("Ada Lovelace ", 8), []Class{class, utc}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ics := string(data)
+
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"TZID:America/New_York\r\n",
+		// daylight saving time starts on march 8 2026
+		"BEGIN:DAYLIGHT\r\nDTSTART:20260308T020000\r\nTZOFFSETFROM:-0500\r\nTZOFFSETTO:-0400\r\n",
+		// the wall clock of 18:00 utc on the first day holds across it
+		"DTSTART;TZID=America/New_York:20260307T130000\r\n",
+		"DTSTART;TZID=America/New_York:20260308T130000\r\n",
+		"DTSTART:20260306T180000Z\r\n",
+		"UID:" + class.ID.Hex() + "-2026-03-06@classmate\r\n",
+		`SUMMARY:Algebra\; sections 1\, 2` + "\r\n",
+		"DTSTAMP:20260301T120000Z\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(ics, want) {
+			t.Errorf("calendar has no %q", want)
+		}
+	}
+	if n := strings.Count(ics, "BEGIN:VEVENT"); n != 8 {
+		t.Errorf("calendar has %d events, want 8", n)
+	}
+
+	// lines are folded at 75 octets
+	for _, line := range strings.Split(ics, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line of %d octets: %q", len(line), line)
+		}
+	}
+	if !strings.Contains(ics, "\r\n ") {
+		t.Error("long calendar name was not folded")
+	}
+}
@@ -0,0 +1,242 @@
+package attendance
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/edwintcloud/classmate/api/services/server"
+	"github.com/globalsign/mgo/bson"
+)
+
+// import row actions. Rows are partial when the person was saved
+// but enrolling them in some of their classes failed.
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportPartial = "partial"
+	ImportFailed  = "failed"
+)
+
+// inviteTokenLifetime is how long an invitation can be accepted.
+// Importing an invited person again invites them anew.
+const inviteTokenLifetime = time.Hour * 24 * 7
+
+// ErrNotActivated is returned when an invited person logs in
+// before accepting their invitation
+var ErrNotActivated = errors.New("Account has not been activated, please accept your invitation")
+
+// ImportReport is the outcome of importing a roster
+type ImportReport struct {
+	DryRun  bool           `json:"dry_run"`
+	Rows    []ImportRow    `json:"rows"`
+	Summary map[string]int `json:"summary"`
+}
+
+// ImportRow is the outcome of importing one row of a roster
+type ImportRow struct {
+	Row         int             `json:"row"`
+	Email       string          `json:"email"`
+	Action      string          `json:"action"`
+	Person      bson.ObjectId   `json:"person,omitempty"`
+	Classes     []bson.ObjectId `json:"classes,omitempty"`
+	Enrolled    []bson.ObjectId `json:"enrolled,omitempty"`
+	InviteToken string          `json:"invite_token,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// ImportRoster reads a csv of students with an email, first_name,
+// last_name and optional classes column of class ids separated by
+// semicolons or spaces. Missing students are created in an invited state,
+// students who have not accepted their invitation are invited again and
+// every student is enrolled in their classes. With dryRun set rows are
+// only validated.
+func ImportRoster(r io.Reader, dryRun bool) (ImportReport, error) {
+	report := ImportReport{DryRun: dryRun, Rows: []ImportRow{}, Summary: map[string]int{}}
+
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	// map header columns
+	header, err := reader.Read()
+	if err != nil {
+		return report, fmt.Errorf("Unable to read csv header: %s", err.Error())
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"email", "first_name", "last_name"} {
+		if _, ok := columns[name]; !ok {
+			return report, fmt.Errorf("Missing %s column", name)
+		}
+	}
+
+	seen := map[string]bool{}
+	classes := map[bson.ObjectId]*Class{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		row := ImportRow{Row: line}
+		if err != nil {
+			row.Action = ImportFailed
+			row.Error = err.Error()
+		} else {
+			row = importRow(line, columnValues(record, columns), seen, classes, dryRun)
+		}
+		report.Rows = append(report.Rows, row)
+		report.Summary[row.Action]++
+	}
+
+	return report, nil
+}
+
+// importRow validates and, unless dryRun is set, applies one roster row
+func importRow(line int, values map[string]string, seen map[string]bool, classes map[bson.ObjectId]*Class, dryRun bool) ImportRow {
+	row := ImportRow{Row: line, Email: normalizeEmail(values["email"]), Classes: []bson.ObjectId{}, Enrolled: []bson.ObjectId{}}
+	fail := func(msg string) ImportRow {
+		row.Action = ImportFailed
+		row.Error = msg
+		return row
+	}
+
+	// validate row
+	if !validEmail(row.Email) {
+		return fail("Invalid email")
+	}
+	if values["first_name"] == "" || values["last_name"] == "" {
+		return fail("First and last name are required")
+	}
+	if seen[row.Email] {
+		return fail("Duplicate email in roster")
+	}
+	seen[row.Email] = true
+
+	// ensure classes exist
+	for _, ref := range strings.FieldsFunc(values["classes"], func(r rune) bool {
+		return r == ';' || r == ' '
+	}) {
+		if !bson.IsObjectIdHex(ref) {
+			return fail("Invalid class id " + ref)
+		}
+		id := bson.ObjectIdHex(ref)
+		if classes[id] == nil {
+			class := Class{ID: id}
+			if err := class.Find(); err != nil {
+				return fail("Class " + ref + " not found")
+			}
+			classes[id] = &class
+		}
+		row.Classes = append(row.Classes, id)
+	}
+
+	// find existing person by email
+	person := Person{Email: row.Email}
+	err := person.Find()
+	switch {
+	case err == ErrNotFound:
+		row.Action = ImportCreated
+	case err != nil:
+		return fail(err.Error())
+	default:
+		row.Action = ImportUpdated
+		row.Person = person.ID
+	}
+	if dryRun {
+		return row
+	}
+
+	// create invited person or update names, inviting
+	// again those who have not accepted yet
+	person.FirstName = values["first_name"]
+	person.LastName = values["last_name"]
+	if row.Action == ImportCreated {
+		row.InviteToken, err = person.Invite(time.Now())
+	} else {
+		err = person.UpdateNames()
+		if err == nil && person.Invited() {
+			row.InviteToken, err = person.Reinvite(time.Now())
+		}
+	}
+	if err != nil {
+		return fail(err.Error())
+	}
+	row.Person = person.ID
+
+	// enroll person in each class, reporting the classes that failed
+	failed := []string{}
+	for _, id := range row.Classes {
+		_, err = classes[id].Enroll(&person)
+		if err != nil {
+			failed = append(failed, "Unable to enroll in "+id.Hex()+": "+err.Error())
+			continue
+		}
+		row.Enrolled = append(row.Enrolled, id)
+	}
+	if len(failed) > 0 {
+		row.Action = ImportPartial
+		row.Error = strings.Join(failed, "; ")
+	}
+
+	return row
+}
+
+// Invite creates the person as a student without a password and
+// returns the token they accept their invitation with
+func (p *Person) Invite(t time.Time) (string, error) {
+	token, err := server.RandomToken(24)
+	if err != nil {
+		return "", err
+	}
+
+	p.ID = bson.NewObjectId()
+	p.Role = RoleStudent
+	p.Password = ""
+	p.Classes = []bson.ObjectId{}
+	p.InviteToken = server.HashToken(token)
+	p.InvitedAt = &t
+
+	return token, p.Insert()
+}
+
+// Reinvite replaces the invitation token of an invited person,
+// returning the new token
+func (p *Person) Reinvite(t time.Time) (string, error) {
+	token, err := server.RandomToken(24)
+	if err != nil {
+		return "", err
+	}
+
+	p.InviteToken = server.HashToken(token)
+	p.InvitedAt = &t
+
+	return token, p.UpdateInviteToken()
+}
+
+// Invited returns true if the person was invited and has
+// not accepted their invitation
+func (p *Person) Invited() bool {
+	return p.Password == "" && p.InviteToken != ""
+}
+
+// InviteExpired returns true if the invitation of the person
+// can no longer be accepted at t
+func (p *Person) InviteExpired(t time.Time) bool {
+	return p.InvitedAt == nil || !t.Before(p.InvitedAt.Add(inviteTokenLifetime))
+}
+
+// columnValues maps column names to the trimmed values of a record
+func columnValues(record []string, columns map[string]int) map[string]string {
+	values := map[string]string{}
+	for name, i := range columns {
+		if i < len(record) {
+			values[name] = strings.TrimSpace(record[i])
+		}
+	}
+	return values
+}
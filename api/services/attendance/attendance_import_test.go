@@ -0,0 +1,117 @@
+package attendance
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edwintcloud/classmate/api/services/server"
+	"github.com/globalsign/mgo/bson"
+)
+
+// failingClasses fails to add students to one class
+type failingClasses struct {
+	ClassRepository
+	fail bson.ObjectId
+}
+
+func (r failingClasses) AddStudent(id, student bson.ObjectId) error {
+	if id == r.fail {
+		return errors.New("class is full")
+	}
+	return r.ClassRepository.AddStudent(id, student)
+}
+
+// importClasses sets up a memory store with two classes, enrolling
+// in the second of which fails
+func importClasses(t *testing.T) (bson.ObjectId, bson.ObjectId) {
+	db = NewMemoryStore()
+	s = &server.Server{Events: server.NewEventBus()}
+	dispatcher = nil
+
+	ok, full := bson.NewObjectId(), bson.NewObjectId()
+	for _, id := range []bson.ObjectId{ok, full} {
+		if err := db.Classes.Insert(&Class{ID: id, Title: "Class", Instructor: bson.NewObjectId(), Students: []bson.ObjectId{}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Classes = failingClasses{db.Classes, full}
+	return ok, full
+}
+
+func TestImportReportsPartialRows(t *testing.T) {
+	ok, full := importClasses(t)
+
+	roster := "email,first_name,last_name,classes\n" +
+		"ada@example.com,Ada,Lovelace," + ok.Hex() + ";" + full.Hex() + "\n" +
+		"grace@example.com,Grace,Hopper," + ok.Hex() + "\n"
+	report, err := ImportRoster(strings.NewReader(roster), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Summary[ImportPartial] != 1 || report.Summary[ImportCreated] != 1 {
+		t.Fatalf("summary = %v", report.Summary)
+	}
+
+	// the person of a partial row was created and invited
+	row := report.Rows[0]
+	if row.Action != ImportPartial || row.Person == "" || row.InviteToken == "" {
+		t.Fatalf("row = %+v", row)
+	}
+	if len(row.Enrolled) != 1 || row.Enrolled[0] != ok || !strings.Contains(row.Error, full.Hex()) {
+		t.Fatalf("row enrolled in %v with error %q", row.Enrolled, row.Error)
+	}
+	person := Person{ID: row.Person}
+	if err := person.Find(); err != nil || !person.HasClass(ok) || person.HasClass(full) {
+		t.Fatalf("person classes = %v, %v", person.Classes, err)
+	}
+}
+
+func TestInvitationsExpire(t *testing.T) {
+	importClasses(t)
+
+	roster := "email,first_name,last_name\nada@example.com,Ada,Lovelace\n"
+	report, err := ImportRoster(strings.NewReader(roster), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := report.Rows[0].InviteToken
+
+	now := time.Now()
+	person := Person{}
+	if err := person.FindByInviteToken(token, now); err != nil {
+		t.Fatalf("FindByInviteToken() = %v", err)
+	}
+	if err := person.FindByInviteToken(token, now.Add(inviteTokenLifetime)); err != ErrNotFound {
+		t.Fatalf("FindByInviteToken() after lifetime = %v, want ErrNotFound", err)
+	}
+
+	// importing again invites anew
+	report, err = ImportRoster(strings.NewReader(roster), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := report.Rows[0]
+	if row.Action != ImportUpdated || row.InviteToken == "" || row.InviteToken == token {
+		t.Fatalf("row = %+v", row)
+	}
+	if err := person.FindByInviteToken(token, now); err != ErrNotFound {
+		t.Fatalf("old token = %v, want ErrNotFound", err)
+	}
+	if err := person.FindByInviteToken(row.InviteToken, now); err != nil {
+		t.Fatalf("new token = %v", err)
+	}
+
+	// accepted invitations are not renewed
+	if err := person.AcceptInvite("analytical1"); err != nil {
+		t.Fatal(err)
+	}
+	report, err = ImportRoster(strings.NewReader(roster), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Rows[0].InviteToken != "" {
+		t.Fatal("import invited an active person")
+	}
+}
@@ -0,0 +1,336 @@
+package attendance
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/edwintcloud/classmate/api/services/server"
+	"github.com/globalsign/mgo/bson"
+	"github.com/labstack/echo"
+)
+
+// bounds of the number of items on a page
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// allTerms is the term_id of listings of every term, which
+// personal class listings otherwise default to the active term
+const allTerms = "all"
+
+// classSorts are the keys class listings can be sorted by
+var classSorts = map[string]func(c *Class) string{
+	"title":      func(c *Class) string { return strings.ToLower(c.Title) },
+	"start_date": func(c *Class) string { return sortTime(c.StartDate) },
+	"end_date":   func(c *Class) string { return sortTime(c.EndDate) },
+}
+
+// personSorts are the keys person listings can be sorted by
+var personSorts = map[string]func(p *Person) string{
+	"last_name":  func(p *Person) string { return strings.ToLower(p.LastName) + "\x00" + strings.ToLower(p.FirstName) },
+	"first_name": func(p *Person) string { return strings.ToLower(p.FirstName) + "\x00" + strings.ToLower(p.LastName) },
+	"email":      func(p *Person) string { return strings.ToLower(p.Email) },
+}
+
+// Page is a page of a listing. Missing lists referenced documents
+// that no longer exist and Term the term a listing is scoped to.
+type Page struct {
+	Data       interface{}     `json:"data"`
+	Pagination Pagination      `json:"pagination"`
+	Missing    []bson.ObjectId `json:"missing,omitempty"`
+	Term       *Term           `json:"term,omitempty"`
+}
+
+// Pagination describes the position of a page in a listing. NextCursor
+// is empty on the last page.
+type Pagination struct {
+	Limit      int    `json:"limit"`
+	Total      int    `json:"total"`
+	Sort       string `json:"sort"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// sortFields are the fields of the sort keys made of several fields,
+// joined by NUL, which other sort keys are the field they are named after
+var sortFields = map[string][]string{
+	"last_name":  {"last_name", "first_name"},
+	"first_name": {"first_name", "last_name"},
+}
+
+// dateFields are the sort fields holding times, formatted by sortTime
+// in sort keys and compared in lower case by stores if they are not
+var dateFields = map[string]bool{
+	"start_date": true,
+	"end_date":   true,
+}
+
+// listOptions are the paging and sorting parameters of a listing.
+// Stores order items by the fields of sort then id and read the limit
+// of items after the cursor, whose field values are after, reading every
+// item if the limit is 0.
+type listOptions struct {
+	limit  int
+	sort   string
+	desc   bool
+	cursor *pageCursor
+	after  []interface{}
+}
+
+// pageCursor is the sort key and id of the last item of a page. Items
+// are ordered by sort key then id so pages stay stable when items with
+// equal keys are added or removed.
+type pageCursor struct {
+	Sort string        `json:"s"`
+	Key  string        `json:"k"`
+	ID   bson.ObjectId `json:"i"`
+}
+
+// classListFilter selects the classes of a listing. Whether classes
+// are active depends on their timezones, so it is checked after
+// stores select the classes matching the rest of the filter.
+type classListFilter struct {
+	ClassFilter
+	active *bool
+}
+
+// parseListOptions reads the limit, sort and cursor query parameters,
+// sorting by fallback if no sort is given. A sort key prefixed with -
+// sorts descending.
+func parseListOptions(c echo.Context, sorts []string, fallback string) (listOptions, error) {
+	opts := listOptions{limit: defaultPageLimit, sort: c.QueryParam("sort")}
+	errs := server.ValidationErrors{}
+
+	// parse limit
+	if value := c.QueryParam("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			errs.Add("limit", CodeInvalid, "Limit must be between 1 and "+strconv.Itoa(maxPageLimit))
+		}
+		opts.limit = limit
+	}
+
+	// parse sort
+	if opts.sort == "" {
+		opts.sort = fallback
+	}
+	name := strings.TrimPrefix(opts.sort, "-")
+	opts.desc = name != opts.sort
+	known := false
+	for _, s := range sorts {
+		known = known || s == name
+	}
+	if !known {
+		errs.Add("sort", CodeInvalid, "Sort must be one of "+strings.Join(sorts, ", "))
+	}
+
+	// decode cursor, which must come from a page with the same sort
+	if value := c.QueryParam("cursor"); value != "" {
+		cursor := &pageCursor{}
+		data, err := base64.RawURLEncoding.DecodeString(value)
+		if err == nil {
+			err = json.Unmarshal(data, cursor)
+		}
+		if err == nil && cursor.Sort == opts.sort {
+			opts.after, err = opts.values(cursor.Key)
+		}
+		if err != nil || !cursor.ID.Valid() || cursor.Sort != opts.sort {
+			errs.Add("cursor", CodeInvalid, "Cursor is invalid for this listing")
+		}
+		opts.cursor = cursor
+	}
+
+	return opts, errs.Err()
+}
+
+// fields returns the fields items are ordered by before their ids
+func (o listOptions) fields() []string {
+	name := strings.TrimPrefix(o.sort, "-")
+	if fields, ok := sortFields[name]; ok {
+		return fields
+	}
+	if name == "" {
+		return nil
+	}
+	return []string{name}
+}
+
+// values splits a sort key into the values of the sort fields
+func (o listOptions) values(key string) ([]interface{}, error) {
+	fields := o.fields()
+	parts := strings.Split(key, "\x00")
+	if len(parts) != len(fields) {
+		return nil, errors.New("sort key does not match its fields")
+	}
+	values := make([]interface{}, len(parts))
+	for i, part := range parts {
+		values[i] = part
+		if dateFields[fields[i]] {
+			t, err := time.Parse(sortTimeLayout, part)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = t
+		}
+	}
+	return values, nil
+}
+
+// peek returns the options reading one item more than the page
+// holds, which tells whether another page follows it
+func (o listOptions) peek() listOptions {
+	o.limit++
+	return o
+}
+
+// paginate returns the number of the n items read with peek that are on
+// the page and its pagination, key returning the sort key of item i
+func (o listOptions) paginate(total, n int, key func(i int) (string, bson.ObjectId)) (int, Pagination) {
+	pagination := Pagination{Limit: o.limit, Total: total, Sort: o.sort}
+	if n <= o.limit {
+		return n, pagination
+	}
+
+	// point next cursor at last item of page
+	last, id := key(o.limit - 1)
+	data, _ := json.Marshal(pageCursor{Sort: o.sort, Key: last, ID: id})
+	pagination.NextCursor = base64.RawURLEncoding.EncodeToString(data)
+
+	return o.limit, pagination
+}
+
+// page orders items by their sort keys and ids and returns the indexes
+// of the items on the page after the cursor, or of every item after it
+// if the limit is 0
+func (o listOptions) page(keys []string, ids []bson.ObjectId) ([]int, Pagination) {
+	pagination := Pagination{Limit: o.limit, Total: len(keys), Sort: o.sort}
+
+	// after returns whether item i comes after key and id,
+	// which the item with id itself does not
+	after := func(i int, key string, id bson.ObjectId) bool {
+		if keys[i] != key {
+			return (keys[i] > key) != o.desc
+		}
+		return ids[i] != id && (ids[i] > id) != o.desc
+	}
+
+	// order items
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return after(order[b], keys[order[a]], ids[order[a]])
+	})
+
+	// skip items up to and including the cursor
+	start := 0
+	if o.cursor != nil {
+		start = sort.Search(len(order), func(i int) bool {
+			return after(order[i], o.cursor.Key, o.cursor.ID)
+		})
+	}
+	end := start + o.limit
+	if o.limit == 0 || end >= len(order) {
+		return order[start:], pagination
+	}
+
+	// point next cursor at last item of page
+	last := order[end-1]
+	data, _ := json.Marshal(pageCursor{Sort: o.sort, Key: keys[last], ID: ids[last]})
+	pagination.NextCursor = base64.RawURLEncoding.EncodeToString(data)
+
+	return order[start:end], pagination
+}
+
+// parseClassListFilter reads the q, active, archived, instructor,
+// term_id and location_id query parameters of a class listing
+func parseClassListFilter(c echo.Context) (classListFilter, error) {
+	filter := classListFilter{ClassFilter: ClassFilter{Search: strings.ToLower(strings.TrimSpace(c.QueryParam("q")))}}
+	errs := server.ValidationErrors{}
+
+	if value := c.QueryParam("active"); value != "" {
+		active, err := strconv.ParseBool(value)
+		if err != nil {
+			errs.Add("active", CodeInvalid, "Active must be true or false")
+		}
+		filter.active = &active
+	}
+	if value := c.QueryParam("archived"); value != "" {
+		archived, err := strconv.ParseBool(value)
+		if err != nil {
+			errs.Add("archived", CodeInvalid, "Archived must be true or false")
+		}
+		filter.Archived = archived
+	}
+	for _, param := range []struct {
+		name, message string
+		id            *bson.ObjectId
+	}{
+		{"instructor", "Invalid instructor id", &filter.Instructor},
+		{"term_id", "Invalid term id", &filter.Term},
+		{"location_id", "Invalid location id", &filter.Location},
+	} {
+		value := c.QueryParam(param.name)
+		if value == "" || param.name == "term_id" && value == allTerms {
+			continue
+		}
+		if !bson.IsObjectIdHex(value) {
+			errs.Add(param.name, CodeInvalid, param.message)
+			continue
+		}
+		*param.id = bson.ObjectIdHex(value)
+	}
+
+	return filter, errs.Err()
+}
+
+// parsePersonListFilter reads the q, role and deactivated
+// query parameters of a person listing
+func parsePersonListFilter(c echo.Context) (PersonFilter, error) {
+	filter := PersonFilter{Search: strings.ToLower(strings.TrimSpace(c.QueryParam("q")))}
+	errs := server.ValidationErrors{}
+
+	if value := c.QueryParam("role"); value != "" {
+		if !ValidRole(value) {
+			errs.Add("role", CodeInvalid, "Role must be student, teacher or admin")
+		}
+		filter.Role = value
+	}
+	if value := c.QueryParam("deactivated"); value != "" {
+		deactivated, err := strconv.ParseBool(value)
+		if err != nil {
+			errs.Add("deactivated", CodeInvalid, "Deactivated must be true or false")
+		}
+		filter.Deactivated = deactivated
+	}
+
+	return filter, errs.Err()
+}
+
+// Active returns whether t falls on or between the first and
+// last day of the class in the timezone of the class
+func (c *Class) Active(t time.Time) bool {
+	loc, err := c.timezone()
+	if err != nil {
+		loc = time.UTC
+	}
+	day := dateOf(t.In(loc), loc)
+	if day.Before(dateOf(c.StartDate, loc)) {
+		return false
+	}
+	return c.EndDate.IsZero() || !day.After(dateOf(c.EndDate, loc))
+}
+
+// sortTimeLayout formats times so they sort in order as strings
+const sortTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// sortTime formats t so times sort in order as strings
+func sortTime(t time.Time) string {
+	return t.UTC().Format(sortTimeLayout)
+}
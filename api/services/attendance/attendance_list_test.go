@@ -0,0 +1,76 @@
+package attendance
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/labstack/echo"
+)
+
+// listContext returns an echo context of a request with query
+func listContext(query string) echo.Context {
+	req := httptest.NewRequest("GET", "/?"+query, nil)
+	return echo.New().NewContext(req, httptest.NewRecorder())
+}
+
+func TestCursorsPageThroughListing(t *testing.T) {
+	keys := []string{"b", "a", "c", "a", "b"}
+	ids := make([]bson.ObjectId, len(keys))
+	for i := range ids {
+		ids[i] = bson.NewObjectId()
+	}
+
+	for _, c := range []struct {
+		sort string
+		want []int
+	}{
+		// ties are ordered by id, which grows with each new id
+		{"title", []int{1, 3, 0, 4, 2}},
+		{"-title", []int{2, 4, 0, 3, 1}},
+	} {
+		got := []int{}
+		query := "limit=2&sort=" + c.sort
+		for pages := 0; pages < len(keys); pages++ {
+			opts, err := parseListOptions(listContext(query), []string{"title"}, "title")
+			if err != nil {
+				t.Fatal(err)
+			}
+			page, pagination := opts.page(keys, ids)
+			got = append(got, page...)
+			if pagination.Total != len(keys) {
+				t.Errorf("total = %d, want %d", pagination.Total, len(keys))
+			}
+			if pagination.NextCursor == "" {
+				break
+			}
+			query = "limit=2&sort=" + c.sort + "&cursor=" + pagination.NextCursor
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("sort %s pages through %v, want %v", c.sort, got, c.want)
+		}
+	}
+}
+
+func TestCursorsBelongToTheirSort(t *testing.T) {
+	keys := []string{"a", "b", "c"}
+	ids := []bson.ObjectId{bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId()}
+	opts, err := parseListOptions(listContext("limit=1"), []string{"title", "start_date"}, "title")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pagination := opts.page(keys, ids)
+
+	for _, query := range []string{
+		"sort=start_date&cursor=" + pagination.NextCursor,
+		"cursor=not-a-cursor",
+		"limit=0",
+		"limit=101",
+		"sort=email",
+	} {
+		if _, err := parseListOptions(listContext(query), []string{"title", "start_date"}, "title"); err == nil {
+			t.Errorf("parseListOptions(%q) succeeded", query)
+		}
+	}
+}
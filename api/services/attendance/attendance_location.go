@@ -0,0 +1,44 @@
+package attendance
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/edwintcloud/classmate/api/services/server"
+)
+
+// Normalize validates the networks of a location and
+// rewrites them in canonical cidr form
+func (l *Location) Normalize() error {
+	if l.Name == "" {
+		return fmt.Errorf("Location name is required")
+	}
+
+	for i, value := range l.Networks {
+		network, err := server.ParseNetwork(value)
+		if err != nil {
+			return fmt.Errorf("Invalid network %q", value)
+		}
+		l.Networks[i] = network.String()
+	}
+
+	return nil
+}
+
+// Allows returns true if ip is within one of the networks of the
+// location. Locations without networks allow any client.
+func (l *Location) Allows(ip net.IP) bool {
+	if len(l.Networks) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, value := range l.Networks {
+		_, network, err := net.ParseCIDR(value)
+		if err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package attendance
+
+import (
+	"net"
+	"testing"
+)
+
+func TestLocationNetworks(t *testing.T) {
+	location := Location{Name: "Room 101", Networks: []string{"10.1.2.3/16", "192.168.1.20", "2001:db8::/32"}}
+	if err := location.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.1.0.0/16", "192.168.1.20/32", "2001:db8::/32"}
+	for i := range want {
+		if location.Networks[i] != want[i] {
+			t.Fatalf("networks = %v, want %v", location.Networks, want)
+		}
+	}
+
+	for ip, allowed := range map[string]bool{
+		"10.1.255.255": true,
+		"10.2.0.1":     false,
+		"192.168.1.20": true,
+		"192.168.1.21": false,
+		"2001:db8::7":  true,
+		"2001:db9::7":  false,
+	} {
+		if got := location.Allows(net.ParseIP(ip)); got != allowed {
+			t.Errorf("Allows(%s) = %t, want %t", ip, got, allowed)
+		}
+	}
+	if location.Allows(nil) {
+		t.Error("Allows(nil) = true, want unknown clients denied")
+	}
+
+	// locations without networks allow any client
+	open := Location{Name: "Hall"}
+	if !open.Allows(net.ParseIP("203.0.113.5")) || !open.Allows(nil) {
+		t.Error("location without networks denied a client")
+	}
+
+	// invalid networks and missing names are rejected
+	for _, invalid := range []Location{
+		{Name: "Room 102", Networks: []string{"10.0.0.0/33"}},
+		{Name: "Room 103", Networks: []string{"nonsense"}},
+		{Networks: []string{"10.0.0.0/8"}},
+	} {
+		if err := invalid.Normalize(); err == nil {
+			t.Errorf("Normalize(%v) succeeded", invalid)
+		}
+	}
+}
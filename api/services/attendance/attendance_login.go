@@ -0,0 +1,113 @@
+package attendance
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/edwintcloud/classmate/api/services/server"
+)
+
+// lifetimes of access tokens and of idle login sessions
+const (
+	accessTokenLifetime = time.Minute * 15
+	loginLifetime       = time.Hour * 24 * 30
+)
+
+// login errors
+var (
+	ErrInvalidRefreshToken = errors.New("Invalid or expired refresh token")
+	ErrLoginRevoked        = errors.New("Login session has expired or been revoked")
+)
+
+// Active reports whether a login can still be used at t
+func (l *Login) Active(t time.Time) bool {
+	return l.RevokedAt == nil && t.Before(l.ExpiresAt)
+}
+
+// StartLogin starts a new login session for an authenticated
+// person and issues its access and refresh tokens
+func (p *Person) StartLogin(t time.Time) error {
+	login := Login{
+		Person:    p.ID,
+		CreatedAt: t,
+		UsedAt:    t,
+		ExpiresAt: t.Add(loginLifetime),
+	}
+
+	// generate refresh token
+	refresh, err := server.RandomToken(32)
+	if err != nil {
+		return err
+	}
+	login.TokenHash = server.HashToken(refresh)
+
+	// save login
+	err = login.Create()
+	if err != nil {
+		return err
+	}
+
+	p.Refresh = refresh
+	return p.issueToken(&login, t)
+}
+
+// RefreshLogin renews the login session of a refresh token, replacing
+// the refresh token so each one can only be used once
+func RefreshLogin(token string, t time.Time) (*Person, error) {
+	login := Login{}
+
+	// find active login of token
+	err := login.FindByToken(token, t)
+	if err == ErrNotFound {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// ensure person still exists and is active
+	person := Person{ID: login.Person}
+	err = person.Find()
+	if err == ErrNotFound {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if person.Deactivated() {
+		return nil, ErrDeactivated
+	}
+
+	// replace refresh token
+	refresh, err := server.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	previous := login.TokenHash
+	login.TokenHash = server.HashToken(refresh)
+	login.UsedAt = t
+	login.ExpiresAt = t.Add(loginLifetime)
+	err = login.Renew(previous)
+	if err == ErrNotFound {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	person.Refresh = refresh
+	return &person, person.issueToken(&login, t)
+}
+
+// issueToken sets the access token of a person for login
+func (p *Person) issueToken(login *Login, t time.Time) error {
+	token, err := s.Keys.Sign(jwt.MapClaims{
+		"typ": server.TokenAccess,
+		"id":  p.ID.Hex(),
+		"sid": login.ID.Hex(),
+		"exp": t.Add(accessTokenLifetime).Unix(),
+	})
+	p.Token = token
+	return err
+}
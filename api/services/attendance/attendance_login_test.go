@@ -0,0 +1,100 @@
+package attendance
+
+import (
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+// loginTokens logs a signed up person in, returning the access
+// and refresh tokens of the new login session
+func loginTokens(t *testing.T, e *echo.Echo, email string) (string, string) {
+	status, out := request(t, e, "POST", "/api/v1/persons/login", "", map[string]string{"email": email, "password": testPassword})
+	if status != 200 {
+		t.Fatalf("login %s: %d %v", email, status, out)
+	}
+	return out["token"].(string), out["refresh_token"].(string)
+}
+
+// refresh exchanges a refresh token, returning the response status
+// and the new access and refresh tokens
+func refresh(t *testing.T, e *echo.Echo, token string) (int, string, string) {
+	status, out := request(t, e, "POST", "/api/v1/persons/refresh", "", map[string]string{"refresh_token": token})
+	access, _ := out["token"].(string)
+	next, _ := out["refresh_token"].(string)
+	return status, access, next
+}
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	e := testServer(t)
+	signup(t, e, "ada@example.com", RoleStudent)
+	access, first := loginTokens(t, e, "ada@example.com")
+
+	// each refresh replaces the refresh token
+	status, renewed, second := refresh(t, e, first)
+	if status != 200 || renewed == "" || second == "" || second == first {
+		t.Fatalf("refresh = %d, rotated %t", status, second != first)
+	}
+	if status, _ := request(t, e, "GET", "/api/v1/persons/me", renewed, nil); status != 200 {
+		t.Fatalf("me with renewed token = %d", status)
+	}
+
+	// used refresh tokens can not be used again
+	if status, _, _ := refresh(t, e, first); status != 401 {
+		t.Fatalf("reused refresh = %d, want 401", status)
+	}
+	if status, _, _ := refresh(t, e, "nonsense"); status != 401 {
+		t.Fatalf("unknown refresh = %d, want 401", status)
+	}
+
+	// the access token of a login lives as long as the login
+	if status, _ := request(t, e, "GET", "/api/v1/persons/me", access, nil); status != 200 {
+		t.Fatalf("me with first token = %d", status)
+	}
+	status, _, third := refresh(t, e, second)
+	if status != 200 {
+		t.Fatalf("second refresh = %d", status)
+	}
+
+	// logging out revokes every token of the login
+	if status, out := request(t, e, "POST", "/api/v1/persons/logout", renewed, nil); status != 200 {
+		t.Fatalf("logout = %d %v", status, out)
+	}
+	for _, token := range []string{access, renewed} {
+		status, out := request(t, e, "GET", "/api/v1/persons/me", token, nil)
+		if status != 401 || out["error"] != ErrLoginRevoked.Error() {
+			t.Fatalf("me after logout = %d %v", status, out)
+		}
+	}
+	if status, _, _ := refresh(t, e, third); status != 401 {
+		t.Fatalf("refresh after logout = %d, want 401", status)
+	}
+}
+
+func TestLogoutAllRevokesEveryLogin(t *testing.T) {
+	e := testServer(t)
+	signup(t, e, "ada@example.com", RoleStudent)
+	_, grace := signup(t, e, "grace@example.com", RoleStudent)
+	phone, phoneRefresh := loginTokens(t, e, "ada@example.com")
+	laptop, _ := loginTokens(t, e, "ada@example.com")
+	other, _ := loginTokens(t, e, grace.Email)
+
+	// signing up and each log in started a login
+	status, out := request(t, e, "POST", "/api/v1/persons/logout/all", laptop, nil)
+	if status != 200 || out["revoked"] != float64(4) {
+		t.Fatalf("logout all = %d %v, want 4 revoked", status, out)
+	}
+
+	// logins of the person are revoked, those of others are not
+	for _, token := range []string{phone, laptop} {
+		if status, _ := request(t, e, "GET", "/api/v1/persons/me", token, nil); status != 401 {
+			t.Fatalf("me after logout all = %d, want 401", status)
+		}
+	}
+	if status, _, _ := refresh(t, e, phoneRefresh); status != 401 {
+		t.Fatalf("refresh after logout all = %d, want 401", status)
+	}
+	if status, _ := request(t, e, "GET", "/api/v1/persons/me", other, nil); status != 200 {
+		t.Fatalf("me of other person = %d, want 200", status)
+	}
+}
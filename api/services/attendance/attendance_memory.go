@@ -0,0 +1,1066 @@
+package attendance
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// memory holds the documents of an in-memory store. Documents are
+// copied through bson on the way in and out so callers never share
+// them and fields are kept exactly as mongo would keep them.
+type memory struct {
+	mutex      sync.RWMutex
+	persons    map[bson.ObjectId]*Person
+	classes    map[bson.ObjectId]*Class
+	attendance map[bson.ObjectId]*Attendance
+	excuses    map[bson.ObjectId]*Excuse
+	locations  map[bson.ObjectId]*Location
+	logins     map[bson.ObjectId]*Login
+	terms      map[bson.ObjectId]*Term
+	webhooks   map[bson.ObjectId]*Webhook
+	deliveries map[bson.ObjectId]*Delivery
+}
+
+// in-memory repositories sharing one memory
+type (
+	memoryPersons    struct{ m *memory }
+	memoryClasses    struct{ m *memory }
+	memoryAttendance struct{ m *memory }
+	memoryExcuses    struct{ m *memory }
+	memoryLocations  struct{ m *memory }
+	memoryLogins     struct{ m *memory }
+	memoryTerms      struct{ m *memory }
+	memoryWebhooks   struct{ m *memory }
+	memoryDeliveries struct{ m *memory }
+)
+
+// NewMemoryStore returns an empty store that keeps everything in
+// memory, for running the api and its tests without a database
+func NewMemoryStore() Store {
+	m := &memory{
+		persons:    map[bson.ObjectId]*Person{},
+		classes:    map[bson.ObjectId]*Class{},
+		attendance: map[bson.ObjectId]*Attendance{},
+		excuses:    map[bson.ObjectId]*Excuse{},
+		locations:  map[bson.ObjectId]*Location{},
+		logins:     map[bson.ObjectId]*Login{},
+		terms:      map[bson.ObjectId]*Term{},
+		webhooks:   map[bson.ObjectId]*Webhook{},
+		deliveries: map[bson.ObjectId]*Delivery{},
+	}
+	return Store{
+		Persons:    memoryPersons{m},
+		Classes:    memoryClasses{m},
+		Attendance: memoryAttendance{m},
+		Excuses:    memoryExcuses{m},
+		Locations:  memoryLocations{m},
+		Logins:     memoryLogins{m},
+		Terms:      memoryTerms{m},
+		Webhooks:   memoryWebhooks{m},
+		Deliveries: memoryDeliveries{m},
+	}
+}
+
+// clone copies in to out through bson
+func clone(in, out interface{}) error {
+	data, err := bson.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, out)
+}
+
+// sortedIDs returns the keys of documents in insertion order,
+// which object ids sort in
+func sortedIDs(ids []bson.ObjectId) []bson.ObjectId {
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// containsID reports whether ids contains id
+func containsID(ids []bson.ObjectId, id bson.ObjectId) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// removeID returns ids without id
+func removeID(ids []bson.ObjectId, id bson.ObjectId) []bson.ObjectId {
+	kept := []bson.ObjectId{}
+	for _, v := range ids {
+		if v != id {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// findPersons returns copies of the persons matching match in insertion order
+func (m *memory) findPersons(match func(p *Person) bool) ([]Person, error) {
+	ids := []bson.ObjectId{}
+	for id, p := range m.persons {
+		if match(p) {
+			ids = append(ids, id)
+		}
+	}
+	persons := []Person{}
+	for _, id := range sortedIDs(ids) {
+		person := Person{}
+		err := clone(m.persons[id], &person)
+		if err != nil {
+			return nil, err
+		}
+		persons = append(persons, person)
+	}
+	return persons, nil
+}
+
+// findPerson copies the first person matching match into p
+func (m *memory) findPerson(match func(p *Person) bool, p *Person) error {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	persons, err := m.findPersons(match)
+	if err != nil {
+		return err
+	}
+	if len(persons) == 0 {
+		return ErrNotFound
+	}
+	*p = persons[0]
+	return nil
+}
+
+// updatePerson applies update to the person with id
+func (m *memory) updatePerson(id bson.ObjectId, update func(p *Person)) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	p, ok := m.persons[id]
+	if !ok {
+		return ErrNotFound
+	}
+	update(p)
+	return nil
+}
+
+func (r memoryPersons) Insert(p *Person) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	if _, ok := r.m.persons[p.ID]; ok {
+		return ErrDuplicate
+	}
+	for _, existing := range r.m.persons {
+		if existing.Email == p.Email {
+			return ErrDuplicate
+		}
+	}
+	stored := &Person{}
+	err := clone(p, stored)
+	if err != nil {
+		return err
+	}
+	r.m.persons[p.ID] = stored
+	return nil
+}
+
+func (r memoryPersons) Find(id bson.ObjectId, p *Person) error {
+	return r.m.findPerson(func(v *Person) bool { return v.ID == id }, p)
+}
+
+func (r memoryPersons) FindByEmail(email string, p *Person) error {
+	return r.m.findPerson(func(v *Person) bool { return v.Email == email }, p)
+}
+
+func (r memoryPersons) FindByInviteToken(hash string, p *Person) error {
+	return r.m.findPerson(func(v *Person) bool { return v.InviteToken == hash }, p)
+}
+
+func (r memoryPersons) FindByFeedToken(hash string, p *Person) error {
+	return r.m.findPerson(func(v *Person) bool { return v.FeedToken == hash }, p)
+}
+
+func (r memoryPersons) FindAll(ids []bson.ObjectId) ([]Person, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+	persons, err := r.m.findPersons(func(p *Person) bool { return containsID(ids, p.ID) })
+	sort.SliceStable(persons, func(i, j int) bool {
+		if persons[i].LastName != persons[j].LastName {
+			return persons[i].LastName < persons[j].LastName
+		}
+		return persons[i].FirstName < persons[j].FirstName
+	})
+	return persons, err
+}
+
+func (r memoryPersons) FindEnrollments() ([]Person, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+	return r.m.findPersons(func(p *Person) bool { return true })
+}
+
+func (r memoryPersons) EmailTaken(email string, except bson.ObjectId) (bool, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+	for _, p := range r.m.persons {
+		if p.Email == email && p.ID != except {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r memoryPersons) UpdateNames(id bson.ObjectId, first, last string) error {
+	return r.m.updatePerson(id, func(p *Person) {
+		p.FirstName = first
+		p.LastName = last
+	})
+}
+
+func (r memoryPersons) FindByFilter(filter PersonFilter, opts listOptions) ([]Person, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+	persons, err := r.m.findPersons(filter.matches)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(persons))
+	ids := make([]bson.ObjectId, len(persons))
+	key, sorted := personSorts[strings.TrimPrefix(opts.sort, "-")]
+	for i := range persons {
+		if sorted {
+			keys[i] = key(&persons[i])
+		}
+		ids[i] = persons[i].ID
+	}
+	indexes, _ := opts.page(keys, ids)
+	page := make([]Person, len(indexes))
+	for i, index := range indexes {
+		page[i] = persons[index]
+	}
+	return page, nil
+}
+
+func (r memoryPersons) CountByFilter(filter PersonFilter) (int, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+	n := 0
+	for _, p := range r.m.persons {
+		if filter.matches(p) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r memoryPersons) UpdateProfile(id bson.ObjectId, first, last, email string) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	p, ok := r.m.persons[id]
+	if !ok {
+		return ErrNotFound
+	}
+	for _, other := range r.m.persons {
+		if other.ID != id && other.Email == email {
+			return ErrDuplicate
+		}
+	}
+	p.FirstName = first
+	p.LastName = last
+	p.Email = email
+	return nil
+}
+
+func (r memoryPersons) UpdatePassword(id bson.ObjectId, password string) error {
+	return r.m.updatePerson(id, func(p *Person) {
+		p.Password = password
+	})
+}
+
+func (r memoryPersons) SetDeactivated(id bson.ObjectId, t *time.Time) error {
+	return r.m.updatePerson(id, func(p *Person) {
+		p.DeactivatedAt = nil
+		if t != nil {
+			deactivated := *t
+			p.DeactivatedAt = &deactivated
+		}
+	})
+}
+
+func (r memoryPersons) UpdateRole(id bson.ObjectId, role string) error {
+	return r.m.updatePerson(id, func(p *Person) {
+		p.Role = role
+	})
+}
+
+func (r memoryPersons) UpdateFeedToken(id bson.ObjectId, hash string) error {
+	return r.m.updatePerson(id, func(p *Person) {
+		p.FeedToken = hash
+	})
+}
+
+func (r memoryPersons) UpdateResetToken(id bson.ObjectId, hash string, expires *time.Time) error {
+	return r.m.updatePerson(id, func(p *Person) {
+		p.ResetToken = hash
+		p.ResetExpires = expires
+	})
+}
+
+func (r memoryPersons) UpdateInviteToken(id bson.ObjectId, hash string, invited *time.Time) error {
+	return r.m.updatePerson(id, func(p *Person) {
+		p.InviteToken = hash
+		p.InvitedAt = invited
+	})
+}
+
+func (r memoryPersons) AcceptInvite(id bson.ObjectId, password string) error {
+	return r.m.updatePerson(id, func(p *Person) {
+		p.Password = password
+		p.InviteToken = ""
+		p.InvitedAt = nil
+	})
+}
+
+func (r memoryPersons) ResetPassword(hash, password string, t time.Time, p *Person) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	for _, stored := range r.m.persons {
+		if stored.ResetToken != hash || stored.ResetExpires == nil || !stored.ResetExpires.After(t) {
+			continue
+		}
+		stored.Password = password
+		stored.ResetToken = ""
+		stored.ResetExpires = nil
+		stored.InviteToken = ""
+		stored.InvitedAt = nil
+		return clone(stored, p)
+	}
+	return ErrNotFound
+}
+
+func (r memoryPersons) AddClass(id, class bson.ObjectId) error {
+	return r.m.updatePerson(id, func(p *Person) {
+		if !containsID(p.Classes, class) {
+			p.Classes = append(p.Classes, class)
+		}
+	})
+}
+
+func (r memoryPersons) RemoveClass(id, class bson.ObjectId) error {
+	return r.m.updatePerson(id, func(p *Person) {
+		p.Classes = removeID(p.Classes, class)
+	})
+}
+
+// findClasses returns copies of the classes matching match in insertion order
+func (m *memory) findClasses(match func(c *Class) bool) ([]Class, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	ids := []bson.ObjectId{}
+	for id, c := range m.classes {
+		if match(c) {
+			ids = append(ids, id)
+		}
+	}
+	classes := []Class{}
+	for _, id := range sortedIDs(ids) {
+		class := Class{}
+		err := clone(m.classes[id], &class)
+		if err != nil {
+			return nil, err
+		}
+		classes = append(classes, class)
+	}
+	return classes, nil
+}
+
+// updateClass applies update to the class with id and
+// increments its version
+func (m *memory) updateClass(id bson.ObjectId, update func(c *Class)) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	c, ok := m.classes[id]
+	if !ok {
+		return ErrNotFound
+	}
+	update(c)
+	c.Version++
+	return nil
+}
+
+func (r memoryClasses) Insert(c *Class) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	if _, ok := r.m.classes[c.ID]; ok {
+		return ErrDuplicate
+	}
+	stored := &Class{}
+	err := clone(c, stored)
+	if err != nil {
+		return err
+	}
+	r.m.classes[c.ID] = stored
+	return nil
+}
+
+func (r memoryClasses) Find(id bson.ObjectId, c *Class) error {
+	classes, err := r.m.findClasses(func(v *Class) bool { return v.ID == id })
+	if err != nil {
+		return err
+	}
+	if len(classes) == 0 {
+		return ErrNotFound
+	}
+	*c = classes[0]
+	return nil
+}
+
+func (r memoryClasses) FindAll(ids []bson.ObjectId) ([]Class, error) {
+	return r.m.findClasses(func(c *Class) bool { return containsID(ids, c.ID) })
+}
+
+func (r memoryClasses) FindByInstructor(instructor bson.ObjectId) ([]Class, error) {
+	return r.m.findClasses(func(c *Class) bool { return c.Instructor == instructor })
+}
+
+func (r memoryClasses) FindEnrollments() ([]Class, error) {
+	return r.m.findClasses(func(c *Class) bool { return true })
+}
+
+func (r memoryClasses) CountByTerm(term bson.ObjectId) (int, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+	n := 0
+	for _, c := range r.m.classes {
+		if c.Term == term {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r memoryClasses) FindByFilter(filter ClassFilter, opts listOptions) ([]Class, error) {
+	classes, err := r.m.findClasses(filter.matches)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(classes))
+	ids := make([]bson.ObjectId, len(classes))
+	key, sorted := classSorts[strings.TrimPrefix(opts.sort, "-")]
+	for i := range classes {
+		if sorted {
+			keys[i] = key(&classes[i])
+		}
+		ids[i] = classes[i].ID
+	}
+	indexes, _ := opts.page(keys, ids)
+	page := make([]Class, len(indexes))
+	for i, index := range indexes {
+		page[i] = classes[index]
+	}
+	return page, nil
+}
+
+func (r memoryClasses) CountByFilter(filter ClassFilter) (int, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+	n := 0
+	for _, c := range r.m.classes {
+		if filter.matches(c) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r memoryClasses) FindMissing(ids []bson.ObjectId) ([]bson.ObjectId, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+	missing := []bson.ObjectId{}
+	for _, id := range ids {
+		if _, ok := r.m.classes[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
+
+func (r memoryClasses) Update(c *Class, version int) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	stored, ok := r.m.classes[c.ID]
+	if !ok || stored.Version != version {
+		return ErrNotFound
+	}
+	updated := &Class{}
+	err := clone(c, updated)
+	if err != nil {
+		return err
+	}
+	updated.Version = version + 1
+	stored.setFields(updated)
+	c.Version = updated.Version
+	return nil
+}
+
+func (r memoryClasses) UpdateSchedule(id bson.ObjectId, timezone string, schedule *Schedule) error {
+	copied := &Schedule{}
+	if schedule == nil {
+		copied = nil
+	} else if err := clone(schedule, copied); err != nil {
+		return err
+	}
+	return r.m.updateClass(id, func(c *Class) {
+		c.Timezone = timezone
+		c.Schedule = copied
+	})
+}
+
+func (r memoryClasses) UpdatePolicy(id bson.ObjectId, policy Policy) error {
+	return r.m.updateClass(id, func(c *Class) {
+		c.Policy = policy
+	})
+}
+
+func (r memoryClasses) UpdateCode(id bson.ObjectId, secret string, period int) error {
+	return r.m.updateClass(id, func(c *Class) {
+		c.CodeSecret = secret
+		c.CodePeriod = period
+	})
+}
+
+func (r memoryClasses) AddStudent(id, student bson.ObjectId) error {
+	return r.m.updateClass(id, func(c *Class) {
+		if !containsID(c.Students, student) {
+			c.Students = append(c.Students, student)
+		}
+	})
+}
+
+func (r memoryClasses) RemoveStudent(id, student bson.ObjectId) error {
+	return r.m.updateClass(id, func(c *Class) {
+		c.Students = removeID(c.Students, student)
+	})
+}
+
+// findAttendance returns copies of the records matching match in insertion order
+func (m *memory) findAttendance(match func(a *Attendance) bool) ([]Attendance, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	ids := []bson.ObjectId{}
+	for id, a := range m.attendance {
+		if match(a) {
+			ids = append(ids, id)
+		}
+	}
+	records := []Attendance{}
+	for _, id := range sortedIDs(ids) {
+		records = append(records, *m.attendance[id])
+	}
+	return records, nil
+}
+
+// sessionRecord returns the record of a student in a class session
+func (m *memory) sessionRecord(class, student bson.ObjectId, session string) *Attendance {
+	for _, a := range m.attendance {
+		if a.Class == class && a.Student == student && a.Session == session {
+			return a
+		}
+	}
+	return nil
+}
+
+func (r memoryAttendance) Insert(a *Attendance) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	if _, ok := r.m.attendance[a.ID]; ok || r.m.sessionRecord(a.Class, a.Student, a.Session) != nil {
+		return ErrDuplicate
+	}
+	stored := &Attendance{}
+	err := clone(a, stored)
+	if err != nil {
+		return err
+	}
+	r.m.attendance[a.ID] = stored
+	return nil
+}
+
+func (r memoryAttendance) UpdateStatus(id bson.ObjectId, status string) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	a, ok := r.m.attendance[id]
+	if !ok {
+		return ErrNotFound
+	}
+	a.Status = status
+	return nil
+}
+
+func (r memoryAttendance) SetStatus(class, student bson.ObjectId, session, status string) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	a := r.m.sessionRecord(class, student, session)
+	if a == nil {
+		a = &Attendance{ID: bson.NewObjectId(), Class: class, Student: student, Session: session}
+		r.m.attendance[a.ID] = a
+	}
+	a.Status = status
+	return nil
+}
+
+func (r memoryAttendance) FindByClass(class bson.ObjectId) ([]Attendance, error) {
+	return r.m.findAttendance(func(a *Attendance) bool { return a.Class == class })
+}
+
+func (r memoryAttendance) FindByStudent(student bson.ObjectId) ([]Attendance, error) {
+	return r.m.findAttendance(func(a *Attendance) bool { return a.Student == student })
+}
+
+func (r memoryExcuses) Insert(e *Excuse) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	if _, ok := r.m.excuses[e.ID]; ok {
+		return ErrDuplicate
+	}
+	for _, stored := range r.m.excuses {
+		if e.Open != "" && stored.Open == e.Open {
+			return ErrDuplicate
+		}
+	}
+	stored := &Excuse{}
+	err := clone(e, stored)
+	if err != nil {
+		return err
+	}
+	r.m.excuses[e.ID] = stored
+	return nil
+}
+
+func (r memoryExcuses) Find(id bson.ObjectId, e *Excuse) error {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+	stored, ok := r.m.excuses[id]
+	if !ok {
+		return ErrNotFound
+	}
+	return clone(stored, e)
+}
+
+func (r memoryExcuses) UpdateStatus(id bson.ObjectId, from string, event ExcuseEvent) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	e, ok := r.m.excuses[id]
+	if !ok || e.Status != from {
+		return ErrNotFound
+	}
+	e.Status = event.Status
+	e.Open = e.openKey()
+	e.UpdatedAt = event.At
+	e.History = append(e.History, event)
+	return nil
+}
+
+func (r memoryExcuses) FindAll(filter ExcuseFilter) ([]Excuse, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+	excuses := []Excuse{}
+	for _, stored := range r.m.excuses {
+		if filter.Classes != nil && !containsID(filter.Classes, stored.Class) ||
+			filter.Student != "" && stored.Student != filter.Student ||
+			filter.Status != "" && stored.Status != filter.Status {
+			continue
+		}
+		excuse := Excuse{}
+		err := clone(stored, &excuse)
+		if err != nil {
+			return nil, err
+		}
+		if excuse.Attachment != nil {
+			excuse.Attachment.Data = nil
+		}
+		excuses = append(excuses, excuse)
+	}
+	sort.Slice(excuses, func(i, j int) bool {
+		return excuses[i].CreatedAt.After(excuses[j].CreatedAt)
+	})
+	return excuses, nil
+}
+
+func (r memoryLocations) Insert(l *Location) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	if _, ok := r.m.locations[l.ID]; ok {
+		return ErrDuplicate
+	}
+	stored := &Location{}
+	err := clone(l, stored)
+	if err != nil {
+		return err
+	}
+	r.m.locations[l.ID] = stored
+	return nil
+}
+
+func (r memoryLocations) Find(id bson.ObjectId, l *Location) error {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+	stored, ok := r.m.locations[id]
+	if !ok {
+		return ErrNotFound
+	}
+	return clone(stored, l)
+}
+
+func (r memoryLocations) Update(l *Location) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	if _, ok := r.m.locations[l.ID]; !ok {
+		return ErrNotFound
+	}
+	stored := &Location{}
+	err := clone(l, stored)
+	if err != nil {
+		return err
+	}
+	r.m.locations[l.ID] = stored
+	return nil
+}
+
+func (r memoryLocations) FindAll() ([]Location, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+	locations := []Location{}
+	for _, stored := range r.m.locations {
+		location := Location{}
+		err := clone(stored, &location)
+		if err != nil {
+			return nil, err
+		}
+		locations = append(locations, location)
+	}
+	sort.Slice(locations, func(i, j int) bool {
+		if locations[i].Building != locations[j].Building {
+			return locations[i].Building < locations[j].Building
+		}
+		return locations[i].Name < locations[j].Name
+	})
+	return locations, nil
+}
+
+func (r memoryTerms) Insert(t *Term) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	if _, ok := r.m.terms[t.ID]; ok {
+		return ErrDuplicate
+	}
+	stored := &Term{}
+	err := clone(t, stored)
+	if err != nil {
+		return err
+	}
+	r.m.terms[t.ID] = stored
+	return nil
+}
+
+func (r memoryTerms) Find(id bson.ObjectId, t *Term) error {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+	stored, ok := r.m.terms[id]
+	if !ok {
+		return ErrNotFound
+	}
+	return clone(stored, t)
+}
+
+func (r memoryTerms) Update(t *Term) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	if _, ok := r.m.terms[t.ID]; !ok {
+		return ErrNotFound
+	}
+	stored := &Term{}
+	err := clone(t, stored)
+	if err != nil {
+		return err
+	}
+	r.m.terms[t.ID] = stored
+	return nil
+}
+
+func (r memoryTerms) Delete(id bson.ObjectId) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	if _, ok := r.m.terms[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.m.terms, id)
+	return nil
+}
+
+func (r memoryTerms) FindAll() ([]Term, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+	terms := []Term{}
+	for _, stored := range r.m.terms {
+		term := Term{}
+		err := clone(stored, &term)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		return terms[i].StartDate.After(terms[j].StartDate)
+	})
+	return terms, nil
+}
+
+func (r memoryWebhooks) Insert(w *Webhook) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	if _, ok := r.m.webhooks[w.ID]; ok {
+		return ErrDuplicate
+	}
+	stored := &Webhook{}
+	err := clone(w, stored)
+	if err != nil {
+		return err
+	}
+	r.m.webhooks[w.ID] = stored
+	return nil
+}
+
+func (r memoryWebhooks) Find(id bson.ObjectId, w *Webhook) error {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+	stored, ok := r.m.webhooks[id]
+	if !ok {
+		return ErrNotFound
+	}
+	return clone(stored, w)
+}
+
+func (r memoryWebhooks) Update(w *Webhook) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	if _, ok := r.m.webhooks[w.ID]; !ok {
+		return ErrNotFound
+	}
+	stored := &Webhook{}
+	err := clone(w, stored)
+	if err != nil {
+		return err
+	}
+	r.m.webhooks[w.ID] = stored
+	return nil
+}
+
+func (r memoryWebhooks) Delete(id bson.ObjectId) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	if _, ok := r.m.webhooks[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.m.webhooks, id)
+	return nil
+}
+
+func (r memoryWebhooks) FindAll() ([]Webhook, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+	ids := []bson.ObjectId{}
+	for id := range r.m.webhooks {
+		ids = append(ids, id)
+	}
+	webhooks := []Webhook{}
+	for _, id := range sortedIDs(ids) {
+		webhook := Webhook{}
+		err := clone(r.m.webhooks[id], &webhook)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+func (r memoryDeliveries) Insert(d *Delivery) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	if _, ok := r.m.deliveries[d.ID]; ok {
+		return ErrDuplicate
+	}
+	stored := &Delivery{}
+	err := clone(d, stored)
+	if err != nil {
+		return err
+	}
+	r.m.deliveries[d.ID] = stored
+	return nil
+}
+
+func (r memoryDeliveries) Find(id bson.ObjectId, d *Delivery) error {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+	stored, ok := r.m.deliveries[id]
+	if !ok {
+		return ErrNotFound
+	}
+	return clone(stored, d)
+}
+
+func (r memoryDeliveries) Update(d *Delivery) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	if _, ok := r.m.deliveries[d.ID]; !ok {
+		return ErrNotFound
+	}
+	stored := &Delivery{}
+	err := clone(d, stored)
+	if err != nil {
+		return err
+	}
+	r.m.deliveries[d.ID] = stored
+	return nil
+}
+
+func (r memoryDeliveries) Claim(id bson.ObjectId, attempts int, t, until time.Time) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	stored, ok := r.m.deliveries[id]
+	if !ok || !stored.claimable(attempts, t) {
+		return ErrNotFound
+	}
+	stored.LeasedUntil = &until
+	return nil
+}
+
+func (r memoryDeliveries) FindByWebhook(webhook bson.ObjectId) ([]Delivery, error) {
+	deliveries, err := r.m.findDeliveries(func(d *Delivery) bool { return d.Webhook == webhook })
+	sort.Slice(deliveries, func(i, j int) bool {
+		if !deliveries[i].CreatedAt.Equal(deliveries[j].CreatedAt) {
+			return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt)
+		}
+		return deliveries[i].ID > deliveries[j].ID
+	})
+	return deliveries, err
+}
+
+func (r memoryDeliveries) FindPending() ([]Delivery, error) {
+	return r.m.findDeliveries(func(d *Delivery) bool { return d.Status == DeliveryPending })
+}
+
+func (r memoryDeliveries) DeleteByWebhook(webhook bson.ObjectId) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	for id, stored := range r.m.deliveries {
+		if stored.Webhook == webhook {
+			delete(r.m.deliveries, id)
+		}
+	}
+	return nil
+}
+
+// findDeliveries copies the deliveries matching match in
+// the order they were created
+func (m *memory) findDeliveries(match func(d *Delivery) bool) ([]Delivery, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	ids := []bson.ObjectId{}
+	for id, stored := range m.deliveries {
+		if match(stored) {
+			ids = append(ids, id)
+		}
+	}
+	deliveries := []Delivery{}
+	for _, id := range sortedIDs(ids) {
+		delivery := Delivery{}
+		err := clone(m.deliveries[id], &delivery)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+func (r memoryLogins) Insert(l *Login) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	if _, ok := r.m.logins[l.ID]; ok {
+		return ErrDuplicate
+	}
+	stored := &Login{}
+	err := clone(l, stored)
+	if err != nil {
+		return err
+	}
+	r.m.logins[l.ID] = stored
+	return nil
+}
+
+func (r memoryLogins) Find(id bson.ObjectId, l *Login) error {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+	stored, ok := r.m.logins[id]
+	if !ok {
+		return ErrNotFound
+	}
+	return clone(stored, l)
+}
+
+func (r memoryLogins) FindByToken(hash string, t time.Time, l *Login) error {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+	for _, stored := range r.m.logins {
+		if stored.TokenHash == hash && stored.RevokedAt == nil && stored.ExpiresAt.After(t) {
+			return clone(stored, l)
+		}
+	}
+	return ErrNotFound
+}
+
+func (r memoryLogins) Renew(l *Login, previous string) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	stored, ok := r.m.logins[l.ID]
+	if !ok || stored.TokenHash != previous || stored.RevokedAt != nil {
+		return ErrNotFound
+	}
+	stored.TokenHash = l.TokenHash
+	stored.UsedAt = l.UsedAt
+	stored.ExpiresAt = l.ExpiresAt
+	return nil
+}
+
+func (r memoryLogins) Revoke(id bson.ObjectId, t time.Time) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	stored, ok := r.m.logins[id]
+	if !ok || stored.RevokedAt != nil {
+		return ErrNotFound
+	}
+	stored.RevokedAt = &t
+	return nil
+}
+
+func (r memoryLogins) RevokeAll(person bson.ObjectId, t time.Time) (int, error) {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+	n := 0
+	for _, stored := range r.m.logins {
+		if stored.Person == person && stored.RevokedAt == nil {
+			revoked := t
+			stored.RevokedAt = &revoked
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,720 @@
+package attendance
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// mgo repositories keeping each model in its own collection
+type (
+	mgoPersons    struct{ c *mgo.Collection }
+	mgoClasses    struct{ c *mgo.Collection }
+	mgoAttendance struct{ c *mgo.Collection }
+	mgoExcuses    struct{ c *mgo.Collection }
+	mgoLocations  struct{ c *mgo.Collection }
+	mgoLogins     struct{ c *mgo.Collection }
+	mgoTerms      struct{ c *mgo.Collection }
+	mgoWebhooks   struct{ c *mgo.Collection }
+	mgoDeliveries struct{ c *mgo.Collection }
+)
+
+// NewMgoStore returns a store backed by the collections of a
+// mongo database, ensuring their indexes exist
+func NewMgoStore(database *mgo.Database) (Store, error) {
+	store := Store{
+		Persons:    mgoPersons{database.C("persons")},
+		Classes:    mgoClasses{database.C("classes")},
+		Attendance: mgoAttendance{database.C("attendance")},
+		Excuses:    mgoExcuses{database.C("excuses")},
+		Locations:  mgoLocations{database.C("locations")},
+		Logins:     mgoLogins{database.C("logins")},
+		Terms:      mgoTerms{database.C("terms")},
+		Webhooks:   mgoWebhooks{database.C("webhooks")},
+		Deliveries: mgoDeliveries{database.C("deliveries")},
+	}
+
+	// ensure a student can only check in once per class session
+	err := database.C("attendance").EnsureIndex(mgo.Index{
+		Key:    []string{"class", "session", "student"},
+		Unique: true,
+	})
+	if err != nil {
+		return store, err
+	}
+	err = database.C("persons").EnsureIndex(mgo.Index{
+		Key:    []string{"email"},
+		Unique: true,
+	})
+	if err != nil {
+		return store, err
+	}
+	err = database.C("excuses").EnsureIndexKey("class", "student", "session")
+	if err != nil {
+		return store, err
+	}
+	err = openMgoExcuses(database.C("excuses"))
+	if err != nil {
+		return store, err
+	}
+	err = database.C("excuses").EnsureIndex(mgo.Index{
+		Key:    []string{"open"},
+		Unique: true,
+		Sparse: true,
+	})
+	if err != nil {
+		return store, err
+	}
+	err = database.C("logins").EnsureIndex(mgo.Index{
+		Key:    []string{"token_hash"},
+		Unique: true,
+	})
+	if err != nil {
+		return store, err
+	}
+	err = database.C("logins").EnsureIndexKey("person")
+	if err != nil {
+		return store, err
+	}
+	err = database.C("classes").EnsureIndexKey("term")
+	if err != nil {
+		return store, err
+	}
+	err = database.C("deliveries").EnsureIndexKey("webhook", "-created_at")
+	if err != nil {
+		return store, err
+	}
+	err = database.C("deliveries").EnsureIndexKey("status")
+	if err != nil {
+		return store, err
+	}
+
+	return store, nil
+}
+
+// NormalizeMgoEmails lowercases the emails of persons saved before
+// emails were normalised, returning the emails it left as they are
+// because they were saved by several persons in different cases
+func NormalizeMgoEmails(database *mgo.Database) ([]EmailConflict, error) {
+	c := database.C("persons")
+	persons := []Person{}
+	err := c.Find(nil).Select(bson.M{"email": 1}).Sort("_id").All(&persons)
+	if err != nil {
+		return nil, err
+	}
+	changes, conflicts := emailChanges(persons)
+	for _, p := range persons {
+		email, ok := changes[p.ID]
+		if !ok {
+			continue
+		}
+		err = c.UpdateId(p.ID, bson.M{"$set": bson.M{"email": email}})
+		if err != nil {
+			return conflicts, fmt.Errorf("Unable to lowercase email %s: %s", p.Email, err.Error())
+		}
+	}
+	return conflicts, nil
+}
+
+// openMgoExcuses sets the open key of the pending and approved
+// excuses saved before excuses had open keys
+func openMgoExcuses(c *mgo.Collection) error {
+	excuses := []Excuse{}
+	err := c.Find(bson.M{
+		"status": bson.M{"$in": []string{ExcusePending, ExcuseApproved}},
+		"open":   bson.M{"$exists": false},
+	}).Select(bson.M{"class": 1, "student": 1, "session": 1, "status": 1}).All(&excuses)
+	if err != nil {
+		return err
+	}
+	for _, e := range excuses {
+		err = c.UpdateId(e.ID, bson.M{"$set": bson.M{"open": e.openKey()}})
+		if err != nil {
+			return fmt.Errorf("Unable to open excuse %s: %s", e.ID.Hex(), err.Error())
+		}
+	}
+	return nil
+}
+
+// mgoError translates mgo errors to repository errors
+func mgoError(err error) error {
+	if err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
+	if mgo.IsDup(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+// incVersion increments the version of a class with its update
+var incVersion = bson.M{"version": 1}
+
+// mgoCaseless compares strings in any case, as listings are sorted
+var mgoCaseless = &mgo.Collation{Locale: "en", Strength: 2}
+
+// mgoAnd returns a query matching every condition
+func mgoAnd(conditions []bson.M) bson.M {
+	switch len(conditions) {
+	case 0:
+		return bson.M{}
+	case 1:
+		return conditions[0]
+	}
+	return bson.M{"$and": conditions}
+}
+
+// mgoSearch returns a condition matching documents with any of
+// fields containing search in any case
+func mgoSearch(search string, fields ...string) bson.M {
+	or := make([]bson.M, len(fields))
+	for i, field := range fields {
+		or[i] = bson.M{field: bson.RegEx{Pattern: regexp.QuoteMeta(search), Options: "i"}}
+	}
+	return bson.M{"$or": or}
+}
+
+// mgoPage returns a query of the documents matching conditions in
+// the order and page of opts, comparing strings in any case
+func mgoPage(c *mgo.Collection, conditions []bson.M, opts listOptions) *mgo.Query {
+	fields := opts.fields()
+	dir, op := "", "$gt"
+	if opts.desc {
+		dir, op = "-", "$lt"
+	}
+
+	// select documents after the cursor, which come after it in
+	// the first field differing from it
+	if opts.cursor != nil {
+		after := []bson.M{}
+		for i := 0; i <= len(fields); i++ {
+			condition := bson.M{}
+			for j := 0; j < i; j++ {
+				condition[fields[j]] = opts.after[j]
+			}
+			if i < len(fields) {
+				condition[fields[i]] = bson.M{op: opts.after[i]}
+			} else {
+				condition["_id"] = bson.M{op: opts.cursor.ID}
+			}
+			after = append(after, condition)
+		}
+		conditions = append(conditions, bson.M{"$or": after})
+	}
+
+	order := []string{}
+	for _, field := range fields {
+		order = append(order, dir+field)
+	}
+	query := c.Find(mgoAnd(conditions)).Sort(append(order, dir+"_id")...).Collation(mgoCaseless)
+	if opts.limit > 0 {
+		query = query.Limit(opts.limit)
+	}
+	return query
+}
+
+// addToSet adds value to an array field of the document with id,
+// first replacing the field if it was saved as null, and increments
+// the fields of inc if not nil
+func addToSet(collection *mgo.Collection, id bson.ObjectId, field string, value interface{}, inc bson.M) error {
+	err := collection.Update(bson.M{"_id": id, field: nil}, bson.M{"$set": bson.M{field: []interface{}{}}})
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	update := bson.M{"$addToSet": bson.M{field: value}}
+	if inc != nil {
+		update["$inc"] = inc
+	}
+	return mgoError(collection.UpdateId(id, update))
+}
+
+func (r mgoPersons) Insert(p *Person) error {
+	return mgoError(r.c.Insert(p))
+}
+
+func (r mgoPersons) Find(id bson.ObjectId, p *Person) error {
+	return mgoError(r.c.FindId(id).One(p))
+}
+
+func (r mgoPersons) FindByEmail(email string, p *Person) error {
+	return mgoError(r.c.Find(bson.M{"email": email}).One(p))
+}
+
+func (r mgoPersons) FindByInviteToken(hash string, p *Person) error {
+	return mgoError(r.c.Find(bson.M{"invite_token": hash}).One(p))
+}
+
+func (r mgoPersons) FindByFeedToken(hash string, p *Person) error {
+	return mgoError(r.c.Find(bson.M{"feed_token": hash}).One(p))
+}
+
+func (r mgoPersons) FindAll(ids []bson.ObjectId) ([]Person, error) {
+	persons := []Person{}
+	err := r.c.Find(bson.M{"_id": bson.M{"$in": ids}}).Sort("last_name", "first_name").All(&persons)
+	return persons, err
+}
+
+func (r mgoPersons) FindEnrollments() ([]Person, error) {
+	persons := []Person{}
+	err := r.c.Find(nil).Select(bson.M{"classes": 1}).All(&persons)
+	return persons, err
+}
+
+func (r mgoPersons) EmailTaken(email string, except bson.ObjectId) (bool, error) {
+	n, err := r.c.Find(bson.M{"email": email, "_id": bson.M{"$ne": except}}).Count()
+	return n > 0, err
+}
+
+func (r mgoPersons) UpdateNames(id bson.ObjectId, first, last string) error {
+	return mgoError(r.c.UpdateId(id, bson.M{"$set": bson.M{"first_name": first, "last_name": last}}))
+}
+
+// mgoPersonFilter returns the conditions selecting the persons of filter
+func mgoPersonFilter(filter PersonFilter) []bson.M {
+	query := bson.M{"deactivated_at": bson.M{"$exists": filter.Deactivated}}
+	if filter.Role != "" {
+		query["role"] = filter.Role
+	}
+	conditions := []bson.M{query}
+	if filter.Search != "" {
+		// match the full name as well as either part of it
+		conditions = append(conditions, bson.M{"$or": []bson.M{
+			mgoSearch(filter.Search, "email"),
+			{"$expr": bson.M{"$regexMatch": bson.M{
+				"input":   bson.M{"$concat": []interface{}{"$first_name", " ", "$last_name"}},
+				"regex":   regexp.QuoteMeta(filter.Search),
+				"options": "i",
+			}}},
+		}})
+	}
+	return conditions
+}
+
+func (r mgoPersons) FindByFilter(filter PersonFilter, opts listOptions) ([]Person, error) {
+	persons := []Person{}
+	err := mgoPage(r.c, mgoPersonFilter(filter), opts).All(&persons)
+	return persons, err
+}
+
+func (r mgoPersons) CountByFilter(filter PersonFilter) (int, error) {
+	return r.c.Find(mgoAnd(mgoPersonFilter(filter))).Count()
+}
+
+func (r mgoPersons) UpdateProfile(id bson.ObjectId, first, last, email string) error {
+	return mgoError(r.c.UpdateId(id, bson.M{"$set": bson.M{"first_name": first, "last_name": last, "email": email}}))
+}
+
+func (r mgoPersons) UpdatePassword(id bson.ObjectId, password string) error {
+	return mgoError(r.c.UpdateId(id, bson.M{"$set": bson.M{"password": password}}))
+}
+
+func (r mgoPersons) SetDeactivated(id bson.ObjectId, t *time.Time) error {
+	if t == nil {
+		return mgoError(r.c.UpdateId(id, bson.M{"$unset": bson.M{"deactivated_at": ""}}))
+	}
+	return mgoError(r.c.UpdateId(id, bson.M{"$set": bson.M{"deactivated_at": t}}))
+}
+
+func (r mgoPersons) UpdateRole(id bson.ObjectId, role string) error {
+	return mgoError(r.c.UpdateId(id, bson.M{"$set": bson.M{"role": role}}))
+}
+
+func (r mgoPersons) UpdateFeedToken(id bson.ObjectId, hash string) error {
+	return mgoError(r.c.UpdateId(id, bson.M{"$set": bson.M{"feed_token": hash}}))
+}
+
+func (r mgoPersons) UpdateResetToken(id bson.ObjectId, hash string, expires *time.Time) error {
+	return mgoError(r.c.UpdateId(id, bson.M{"$set": bson.M{"reset_token": hash, "reset_expires": expires}}))
+}
+
+func (r mgoPersons) UpdateInviteToken(id bson.ObjectId, hash string, invited *time.Time) error {
+	return mgoError(r.c.UpdateId(id, bson.M{"$set": bson.M{"invite_token": hash, "invited_at": invited}}))
+}
+
+func (r mgoPersons) AcceptInvite(id bson.ObjectId, password string) error {
+	return mgoError(r.c.UpdateId(id, bson.M{
+		"$set":   bson.M{"password": password},
+		"$unset": bson.M{"invite_token": "", "invited_at": ""},
+	}))
+}
+
+func (r mgoPersons) ResetPassword(hash, password string, t time.Time, p *Person) error {
+	_, err := r.c.Find(bson.M{
+		"reset_token":   hash,
+		"reset_expires": bson.M{"$gt": t},
+	}).Apply(mgo.Change{
+		Update: bson.M{
+			"$set":   bson.M{"password": password},
+			"$unset": bson.M{"reset_token": "", "reset_expires": "", "invite_token": "", "invited_at": ""},
+		},
+		ReturnNew: true,
+	}, p)
+	return mgoError(err)
+}
+
+func (r mgoPersons) AddClass(id, class bson.ObjectId) error {
+	return addToSet(r.c, id, "classes", class, nil)
+}
+
+func (r mgoPersons) RemoveClass(id, class bson.ObjectId) error {
+	return mgoError(r.c.UpdateId(id, bson.M{"$pull": bson.M{"classes": class}}))
+}
+
+func (r mgoClasses) Insert(c *Class) error {
+	return mgoError(r.c.Insert(c))
+}
+
+func (r mgoClasses) Find(id bson.ObjectId, c *Class) error {
+	return mgoError(r.c.FindId(id).One(c))
+}
+
+func (r mgoClasses) FindAll(ids []bson.ObjectId) ([]Class, error) {
+	classes := []Class{}
+	err := r.c.Find(bson.M{"_id": bson.M{"$in": ids}}).All(&classes)
+	return classes, err
+}
+
+func (r mgoClasses) FindByInstructor(instructor bson.ObjectId) ([]Class, error) {
+	classes := []Class{}
+	err := r.c.Find(bson.M{"instructor": instructor}).All(&classes)
+	return classes, err
+}
+
+func (r mgoClasses) FindEnrollments() ([]Class, error) {
+	classes := []Class{}
+	err := r.c.Find(nil).Select(bson.M{"students": 1}).All(&classes)
+	return classes, err
+}
+
+func (r mgoClasses) CountByTerm(term bson.ObjectId) (int, error) {
+	return r.c.Find(bson.M{"term": term}).Count()
+}
+
+// mgoClassFilter returns the conditions selecting the classes of filter
+func mgoClassFilter(filter ClassFilter) []bson.M {
+	query := bson.M{"archived_at": bson.M{"$exists": filter.Archived}}
+	if filter.IDs != nil {
+		query["_id"] = bson.M{"$in": filter.IDs}
+	}
+	if filter.Instructor != "" {
+		query["instructor"] = filter.Instructor
+	}
+	if filter.Term != "" {
+		query["term"] = filter.Term
+	}
+	if filter.Location != "" {
+		query["location_id"] = filter.Location
+	}
+	conditions := []bson.M{query}
+	if filter.Search != "" {
+		conditions = append(conditions, mgoSearch(filter.Search, "title", "location"))
+	}
+	return conditions
+}
+
+func (r mgoClasses) FindByFilter(filter ClassFilter, opts listOptions) ([]Class, error) {
+	classes := []Class{}
+	err := mgoPage(r.c, mgoClassFilter(filter), opts).All(&classes)
+	return classes, err
+}
+
+func (r mgoClasses) CountByFilter(filter ClassFilter) (int, error) {
+	return r.c.Find(mgoAnd(mgoClassFilter(filter))).Count()
+}
+
+func (r mgoClasses) FindMissing(ids []bson.ObjectId) ([]bson.ObjectId, error) {
+	found := []Class{}
+	err := r.c.Find(bson.M{"_id": bson.M{"$in": ids}}).Select(bson.M{"_id": 1}).All(&found)
+	if err != nil {
+		return nil, err
+	}
+	missing := []bson.ObjectId{}
+	for _, id := range ids {
+		exists := false
+		for _, c := range found {
+			exists = exists || c.ID == id
+		}
+		if !exists {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
+
+func (r mgoClasses) Update(c *Class, version int) error {
+	selector := bson.M{"_id": c.ID, "version": version}
+	if version == 0 {
+		// classes created before versions have none
+		selector["version"] = bson.M{"$in": []interface{}{0, nil}}
+	}
+	set := bson.M{
+		"title":      c.Title,
+		"instructor": c.Instructor,
+		"start_time": c.StartTime,
+		"end_time":   c.EndTime,
+		"start_date": c.StartDate,
+		"end_date":   c.EndDate,
+		"location":   c.Location,
+		"timezone":   c.Timezone,
+		"version":    version + 1,
+	}
+	unset := bson.M{}
+	if c.LocationID != "" {
+		set["location_id"] = c.LocationID
+	} else {
+		unset["location_id"] = ""
+	}
+	if c.Term != "" {
+		set["term"] = c.Term
+	} else {
+		unset["term"] = ""
+	}
+	if c.ArchivedAt != nil {
+		set["archived_at"] = c.ArchivedAt
+	} else {
+		unset["archived_at"] = ""
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	err := mgoError(r.c.Update(selector, update))
+	if err == nil {
+		c.Version = version + 1
+	}
+	return err
+}
+
+func (r mgoClasses) UpdateSchedule(id bson.ObjectId, timezone string, schedule *Schedule) error {
+	return mgoError(r.c.UpdateId(id, bson.M{"$set": bson.M{"timezone": timezone, "schedule": schedule}, "$inc": incVersion}))
+}
+
+func (r mgoClasses) UpdatePolicy(id bson.ObjectId, policy Policy) error {
+	return mgoError(r.c.UpdateId(id, bson.M{"$set": bson.M{"policy": policy}, "$inc": incVersion}))
+}
+
+func (r mgoClasses) UpdateCode(id bson.ObjectId, secret string, period int) error {
+	return mgoError(r.c.UpdateId(id, bson.M{"$set": bson.M{"code_secret": secret, "code_period": period}, "$inc": incVersion}))
+}
+
+func (r mgoClasses) AddStudent(id, student bson.ObjectId) error {
+	return addToSet(r.c, id, "students", student, incVersion)
+}
+
+func (r mgoClasses) RemoveStudent(id, student bson.ObjectId) error {
+	return mgoError(r.c.UpdateId(id, bson.M{"$pull": bson.M{"students": student}, "$inc": incVersion}))
+}
+
+func (r mgoAttendance) Insert(a *Attendance) error {
+	return mgoError(r.c.Insert(a))
+}
+
+func (r mgoAttendance) UpdateStatus(id bson.ObjectId, status string) error {
+	return mgoError(r.c.UpdateId(id, bson.M{"$set": bson.M{"status": status}}))
+}
+
+func (r mgoAttendance) SetStatus(class, student bson.ObjectId, session, status string) error {
+	_, err := r.c.Upsert(
+		bson.M{"class": class, "session": session, "student": student},
+		bson.M{"$set": bson.M{"status": status}},
+	)
+	return mgoError(err)
+}
+
+func (r mgoAttendance) FindByClass(class bson.ObjectId) ([]Attendance, error) {
+	records := []Attendance{}
+	err := r.c.Find(bson.M{"class": class}).All(&records)
+	return records, err
+}
+
+func (r mgoAttendance) FindByStudent(student bson.ObjectId) ([]Attendance, error) {
+	records := []Attendance{}
+	err := r.c.Find(bson.M{"student": student}).All(&records)
+	return records, err
+}
+
+func (r mgoExcuses) Insert(e *Excuse) error {
+	return mgoError(r.c.Insert(e))
+}
+
+func (r mgoExcuses) Find(id bson.ObjectId, e *Excuse) error {
+	return mgoError(r.c.FindId(id).One(e))
+}
+
+func (r mgoExcuses) UpdateStatus(id bson.ObjectId, from string, event ExcuseEvent) error {
+	e := Excuse{}
+	err := r.c.FindId(id).Select(bson.M{"class": 1, "student": 1, "session": 1}).One(&e)
+	if err != nil {
+		return mgoError(err)
+	}
+	e.Status = event.Status
+	change := bson.M{
+		"$set":  bson.M{"status": event.Status, "updated_at": event.At},
+		"$push": bson.M{"history": event},
+	}
+	if open := e.openKey(); open != "" {
+		change["$set"].(bson.M)["open"] = open
+	} else {
+		change["$unset"] = bson.M{"open": ""}
+	}
+	return mgoError(r.c.Update(bson.M{"_id": id, "status": from}, change))
+}
+
+func (r mgoExcuses) FindAll(filter ExcuseFilter) ([]Excuse, error) {
+	excuses := []Excuse{}
+	query := bson.M{}
+	if filter.Classes != nil {
+		query["class"] = bson.M{"$in": filter.Classes}
+	}
+	if filter.Student != "" {
+		query["student"] = filter.Student
+	}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	err := r.c.Find(query).Select(bson.M{"attachment.data": 0}).Sort("-created_at").All(&excuses)
+	return excuses, err
+}
+
+func (r mgoLocations) Insert(l *Location) error {
+	return mgoError(r.c.Insert(l))
+}
+
+func (r mgoLocations) Find(id bson.ObjectId, l *Location) error {
+	return mgoError(r.c.FindId(id).One(l))
+}
+
+func (r mgoLocations) Update(l *Location) error {
+	return mgoError(r.c.UpdateId(l.ID, l))
+}
+
+func (r mgoLocations) FindAll() ([]Location, error) {
+	locations := []Location{}
+	err := r.c.Find(nil).Sort("building", "name").All(&locations)
+	return locations, err
+}
+
+func (r mgoTerms) Insert(t *Term) error {
+	return mgoError(r.c.Insert(t))
+}
+
+func (r mgoTerms) Find(id bson.ObjectId, t *Term) error {
+	return mgoError(r.c.FindId(id).One(t))
+}
+
+func (r mgoTerms) Update(t *Term) error {
+	return mgoError(r.c.UpdateId(t.ID, t))
+}
+
+func (r mgoTerms) Delete(id bson.ObjectId) error {
+	return mgoError(r.c.RemoveId(id))
+}
+
+func (r mgoTerms) FindAll() ([]Term, error) {
+	terms := []Term{}
+	err := r.c.Find(nil).Sort("-start_date").All(&terms)
+	return terms, err
+}
+
+func (r mgoWebhooks) Insert(w *Webhook) error {
+	return mgoError(r.c.Insert(w))
+}
+
+func (r mgoWebhooks) Find(id bson.ObjectId, w *Webhook) error {
+	return mgoError(r.c.FindId(id).One(w))
+}
+
+func (r mgoWebhooks) Update(w *Webhook) error {
+	return mgoError(r.c.UpdateId(w.ID, w))
+}
+
+func (r mgoWebhooks) Delete(id bson.ObjectId) error {
+	return mgoError(r.c.RemoveId(id))
+}
+
+func (r mgoWebhooks) FindAll() ([]Webhook, error) {
+	webhooks := []Webhook{}
+	err := r.c.Find(nil).Sort("_id").All(&webhooks)
+	return webhooks, err
+}
+
+func (r mgoDeliveries) Insert(d *Delivery) error {
+	return mgoError(r.c.Insert(d))
+}
+
+func (r mgoDeliveries) Find(id bson.ObjectId, d *Delivery) error {
+	return mgoError(r.c.FindId(id).One(d))
+}
+
+func (r mgoDeliveries) Update(d *Delivery) error {
+	return mgoError(r.c.UpdateId(d.ID, d))
+}
+
+func (r mgoDeliveries) Claim(id bson.ObjectId, attempts int, t, until time.Time) error {
+	selector := bson.M{
+		"_id":      id,
+		"status":   DeliveryPending,
+		"attempts": attempts,
+		"$or": []bson.M{
+			{"leased_until": bson.M{"$exists": false}},
+			{"leased_until": bson.M{"$lte": t}},
+		},
+	}
+	return mgoError(r.c.Update(selector, bson.M{"$set": bson.M{"leased_until": until}}))
+}
+
+func (r mgoDeliveries) FindByWebhook(webhook bson.ObjectId) ([]Delivery, error) {
+	deliveries := []Delivery{}
+	err := r.c.Find(bson.M{"webhook": webhook}).Sort("-created_at", "-_id").All(&deliveries)
+	return deliveries, err
+}
+
+func (r mgoDeliveries) FindPending() ([]Delivery, error) {
+	deliveries := []Delivery{}
+	err := r.c.Find(bson.M{"status": DeliveryPending}).Sort("_id").All(&deliveries)
+	return deliveries, err
+}
+
+func (r mgoDeliveries) DeleteByWebhook(webhook bson.ObjectId) error {
+	_, err := r.c.RemoveAll(bson.M{"webhook": webhook})
+	return err
+}
+
+func (r mgoLogins) Insert(l *Login) error {
+	return mgoError(r.c.Insert(l))
+}
+
+func (r mgoLogins) Find(id bson.ObjectId, l *Login) error {
+	return mgoError(r.c.FindId(id).One(l))
+}
+
+func (r mgoLogins) FindByToken(hash string, t time.Time, l *Login) error {
+	return mgoError(r.c.Find(bson.M{
+		"token_hash": hash,
+		"revoked_at": nil,
+		"expires_at": bson.M{"$gt": t},
+	}).One(l))
+}
+
+func (r mgoLogins) Renew(l *Login, previous string) error {
+	return mgoError(r.c.Update(bson.M{"_id": l.ID, "token_hash": previous, "revoked_at": nil}, bson.M{"$set": bson.M{
+		"token_hash": l.TokenHash,
+		"used_at":    l.UsedAt,
+		"expires_at": l.ExpiresAt,
+	}}))
+}
+
+func (r mgoLogins) Revoke(id bson.ObjectId, t time.Time) error {
+	return mgoError(r.c.Update(bson.M{"_id": id, "revoked_at": nil}, bson.M{"$set": bson.M{"revoked_at": t}}))
+}
+
+func (r mgoLogins) RevokeAll(person bson.ObjectId, t time.Time) (int, error) {
+	info, err := r.c.UpdateAll(bson.M{"person": person, "revoked_at": nil}, bson.M{"$set": bson.M{"revoked_at": t}})
+	if err != nil {
+		return 0, err
+	}
+	return info.Updated, nil
+}
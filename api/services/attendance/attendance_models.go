@@ -16,17 +16,211 @@ type Class struct {
 	StartDate  time.Time       `json:"start_date" bson:"start_date"`
 	EndDate    time.Time       `json:"end_date" bson:"end_date"`
 	Location   string          `json:"location" bson:"location"`
+	LocationID bson.ObjectId   `json:"location_id,omitempty" bson:"location_id,omitempty"`
 	Students   []bson.ObjectId `json:"students" bson:"students"`
+	CodeSecret string          `json:"-" bson:"code_secret"`
+	CodePeriod int             `json:"code_period" bson:"code_period"`
+	Timezone   string          `json:"timezone,omitempty" bson:"timezone,omitempty"`
+	Schedule   *Schedule       `json:"schedule,omitempty" bson:"schedule,omitempty"`
+	Policy     Policy          `json:"policy" bson:"policy"`
+	Term       bson.ObjectId   `json:"term,omitempty" bson:"term,omitempty"`
+	ArchivedAt *time.Time      `json:"archived_at,omitempty" bson:"archived_at,omitempty"`
+	Version    int             `json:"version" bson:"version"`
+}
+
+// ClassFilter selects classes by instructor, term, location and
+// whether they are archived. IDs limits the classes to those with its
+// ids unless it is nil, and Search to those with a title or location
+// containing it in lower case.
+type ClassFilter struct {
+	IDs        []bson.ObjectId
+	Instructor bson.ObjectId
+	Term       bson.ObjectId
+	Location   bson.ObjectId
+	Archived   bool
+	Search     string
+}
+
+// Policy is how late a student can check in to a session, in minutes
+// after it starts, before being marked late and then absent
+type Policy struct {
+	GracePeriod int `json:"grace_period" bson:"grace_period"`
+	LateCutoff  int `json:"late_cutoff" bson:"late_cutoff"`
+}
+
+// Schedule is how a class repeats between its start and end dates,
+// either on a set of weekdays or by an RFC 5545 rrule
+type Schedule struct {
+	Weekdays    []string     `json:"weekdays,omitempty" bson:"weekdays,omitempty"`
+	RRule       string       `json:"rrule,omitempty" bson:"rrule,omitempty"`
+	Exceptions  []string     `json:"exceptions,omitempty" bson:"exceptions,omitempty"`
+	Reschedules []Reschedule `json:"reschedules,omitempty" bson:"reschedules,omitempty"`
+}
+
+// Reschedule moves a single session of a class
+type Reschedule struct {
+	Session  string    `json:"session" bson:"session"`
+	Start    time.Time `json:"start" bson:"start"`
+	End      time.Time `json:"end" bson:"end"`
+	Location string    `json:"location,omitempty" bson:"location,omitempty"`
+}
+
+// Term is an academic term classes belong to, along with
+// the breaks no sessions of its classes are held in
+type Term struct {
+	ID        bson.ObjectId `json:"_id,omitempty" bson:"_id,omitempty"`
+	Name      string        `json:"name" bson:"name"`
+	StartDate time.Time     `json:"start_date" bson:"start_date"`
+	EndDate   time.Time     `json:"end_date" bson:"end_date"`
+	Breaks    []Break       `json:"breaks" bson:"breaks"`
+}
+
+// Break is a range of days of a term without sessions, from Start through
+// End as dates in the form 2006-01-02. Holidays are breaks of one day.
+type Break struct {
+	Name  string `json:"name" bson:"name"`
+	Start string `json:"start" bson:"start"`
+	End   string `json:"end" bson:"end"`
+}
+
+// Location is a room classes are held in along with the
+// networks clients must check in from
+type Location struct {
+	ID       bson.ObjectId `json:"_id,omitempty" bson:"_id,omitempty"`
+	Name     string        `json:"name" bson:"name"`
+	Building string        `json:"building" bson:"building"`
+	Networks []string      `json:"networks" bson:"networks"`
 }
 
 // Person is our student and instructor model
 type Person struct {
-	ID        bson.ObjectId   `json:"-" bson:"_id,omitempty"`
+	ID        bson.ObjectId   `json:"_id,omitempty" bson:"_id,omitempty"`
 	Email     string          `json:"email" bson:"email"`
 	Password  string          `json:"password,omitempty" bson:"password"`
 	FirstName string          `json:"first_name" bson:"first_name"`
 	LastName  string          `json:"last_name" bson:"last_name"`
 	Role      string          `json:"role" bson:"role"`
 	Token     string          `json:"token,omitempty" bson:"-"`
+	Refresh   string          `json:"refresh_token,omitempty" bson:"-"`
 	Classes   []bson.ObjectId `json:"classes" bson:"classes"`
+	FeedToken string          `json:"-" bson:"feed_token,omitempty"`
+
+	InviteToken string     `json:"-" bson:"invite_token,omitempty"`
+	InvitedAt   *time.Time `json:"invited_at,omitempty" bson:"invited_at,omitempty"`
+
+	ResetToken   string     `json:"-" bson:"reset_token,omitempty"`
+	ResetExpires *time.Time `json:"-" bson:"reset_expires,omitempty"`
+
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty" bson:"deactivated_at,omitempty"`
+}
+
+// PersonFilter selects persons by role and whether they are
+// deactivated. Search limits the persons to those with a full name
+// or email containing it in lower case.
+type PersonFilter struct {
+	Role        string
+	Deactivated bool
+	Search      string
+}
+
+// Session is a single meeting of a class
+type Session struct {
+	ID          string    `json:"id"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Location    string    `json:"location,omitempty"`
+	Rescheduled bool      `json:"rescheduled,omitempty"`
+}
+
+// Attendance is a check in of a student to a class session
+type Attendance struct {
+	ID        bson.ObjectId `json:"_id,omitempty" bson:"_id,omitempty"`
+	Class     bson.ObjectId `json:"class" bson:"class"`
+	Student   bson.ObjectId `json:"student" bson:"student"`
+	Session   string        `json:"session" bson:"session"`
+	CheckedIn time.Time     `json:"checked_in" bson:"checked_in"`
+	Status    string        `json:"status" bson:"status"`
+}
+
+// Excuse is a request by a student to be excused from a class session
+// along with the history of decisions made on it
+type Excuse struct {
+	ID         bson.ObjectId `json:"_id,omitempty" bson:"_id,omitempty"`
+	Class      bson.ObjectId `json:"class" bson:"class"`
+	Student    bson.ObjectId `json:"student" bson:"student"`
+	Session    string        `json:"session" bson:"session"`
+	Reason     string        `json:"reason" bson:"reason"`
+	Attachment *Attachment   `json:"attachment,omitempty" bson:"attachment,omitempty"`
+	Status     string        `json:"status" bson:"status"`
+	History    []ExcuseEvent `json:"history" bson:"history"`
+	CreatedAt  time.Time     `json:"created_at" bson:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at" bson:"updated_at"`
+
+	// Open is the session key of a pending or approved excuse, which
+	// stores keep unique so a session has one open excuse at most
+	Open string `json:"-" bson:"open,omitempty"`
+}
+
+// Attachment is a file supporting an excuse
+type Attachment struct {
+	Name        string `json:"name" bson:"name"`
+	ContentType string `json:"content_type" bson:"content_type"`
+	Size        int    `json:"size" bson:"size"`
+	Data        []byte `json:"-" bson:"data"`
+}
+
+// ExcuseEvent is a change of the status of an excuse
+type ExcuseEvent struct {
+	Status string        `json:"status" bson:"status"`
+	By     bson.ObjectId `json:"by" bson:"by"`
+	At     time.Time     `json:"at" bson:"at"`
+	Note   string        `json:"note,omitempty" bson:"note,omitempty"`
+}
+
+// ExcuseFilter selects excuses by class, student and status
+type ExcuseFilter struct {
+	Classes []bson.ObjectId
+	Student bson.ObjectId
+	Status  string
+}
+
+// Login is a server side login session of a person, kept alive with its
+// refresh token until it expires or is revoked
+type Login struct {
+	ID        bson.ObjectId `json:"_id,omitempty" bson:"_id,omitempty"`
+	Person    bson.ObjectId `json:"person" bson:"person"`
+	TokenHash string        `json:"-" bson:"token_hash"`
+	CreatedAt time.Time     `json:"created_at" bson:"created_at"`
+	UsedAt    time.Time     `json:"used_at" bson:"used_at"`
+	ExpiresAt time.Time     `json:"expires_at" bson:"expires_at"`
+	RevokedAt *time.Time    `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+}
+
+// Webhook is a subscription of a url to events, which are posted to it
+// signed with its secret
+type Webhook struct {
+	ID        bson.ObjectId `json:"_id,omitempty" bson:"_id,omitempty"`
+	URL       string        `json:"url" bson:"url"`
+	Events    []string      `json:"events" bson:"events"`
+	Secret    string        `json:"secret,omitempty" bson:"secret"`
+	CreatedAt time.Time     `json:"created_at" bson:"created_at"`
+}
+
+// Delivery is the delivery of an event to a webhook along with the
+// result of its latest attempt. Redelivery is the delivery it repeats.
+type Delivery struct {
+	ID             bson.ObjectId `json:"_id,omitempty" bson:"_id,omitempty"`
+	Webhook        bson.ObjectId `json:"webhook" bson:"webhook"`
+	Event          string        `json:"event" bson:"event"`
+	Type           string        `json:"type" bson:"type"`
+	Payload        string        `json:"payload" bson:"payload"`
+	Status         string        `json:"status" bson:"status"`
+	Attempts       int           `json:"attempts" bson:"attempts"`
+	ResponseStatus int           `json:"response_status,omitempty" bson:"response_status,omitempty"`
+	Error          string        `json:"error,omitempty" bson:"error,omitempty"`
+	NextAttempt    *time.Time    `json:"next_attempt,omitempty" bson:"next_attempt,omitempty"`
+	LeasedUntil    *time.Time    `json:"-" bson:"leased_until,omitempty"`
+	Redelivery     bson.ObjectId `json:"redelivery,omitempty" bson:"redelivery,omitempty"`
+	CreatedAt      time.Time     `json:"created_at" bson:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at" bson:"updated_at"`
 }
@@ -0,0 +1,79 @@
+package attendance
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrDeactivated is returned when a deactivated person logs in
+var ErrDeactivated = errors.New("Account has been deactivated")
+
+// Deactivated returns true if the person was deactivated
+func (p *Person) Deactivated() bool {
+	return p.DeactivatedAt != nil
+}
+
+// matches returns true if person is selected by the filter
+func (f PersonFilter) matches(p *Person) bool {
+	if f.Search != "" && !strings.Contains(strings.ToLower(p.FirstName+" "+p.LastName), f.Search) &&
+		!strings.Contains(strings.ToLower(p.Email), f.Search) {
+		return false
+	}
+	return (f.Role == "" || p.Role == f.Role) && p.Deactivated() == f.Deactivated
+}
+
+// CheckPassword returns true if password is the password of the person
+func (p *Person) CheckPassword(password string) bool {
+	return p.Password != "" && bcrypt.CompareHashAndPassword([]byte(p.Password), []byte(password)) == nil
+}
+
+// ChangePassword saves a new password for the person and revokes all of
+// their logins, starting a new login to replace the current one
+func (p *Person) ChangePassword(password string, t time.Time) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), 6)
+	if err != nil {
+		return err
+	}
+	p.Password = string(hash)
+	err = p.UpdatePassword()
+	if err != nil {
+		return err
+	}
+	_, err = RevokeLogins(p.ID, t)
+	if err != nil {
+		return err
+	}
+	return p.StartLogin(t)
+}
+
+// Deactivate stops the person from logging in and revokes their logins
+func (p *Person) Deactivate(t time.Time) error {
+	p.DeactivatedAt = &t
+	err := p.UpdateDeactivated()
+	if err != nil {
+		return err
+	}
+	_, err = RevokeLogins(p.ID, t)
+	return err
+}
+
+// Reactivate allows a deactivated person to log in again
+func (p *Person) Reactivate() error {
+	p.DeactivatedAt = nil
+	return p.UpdateDeactivated()
+}
+
+// LastAdmin returns true if the person is the only active admin
+func (p *Person) LastAdmin() (bool, error) {
+	if p.Role != RoleAdmin || p.Deactivated() {
+		return false, nil
+	}
+	admins, err := CountPersonsBy(PersonFilter{Role: RoleAdmin})
+	if err != nil {
+		return false, err
+	}
+	return admins <= 1, nil
+}
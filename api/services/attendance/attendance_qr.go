@@ -0,0 +1,97 @@
+package attendance
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/globalsign/mgo/bson"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// ErrInvalidQR is returned when a scanned check in payload
+// is forged, expired or for another session
+var ErrInvalidQR = errors.New("Invalid or expired check in QR code")
+
+// qrSize is the width and height of generated png qr codes
+const qrSize = 320
+
+// tokenCheckIn is the typ claim of check in tokens, which
+// the jwt middleware rejects as bearer tokens
+const tokenCheckIn = "checkin"
+
+// CheckInToken returns a signed token allowing check in to session of
+// the class with the check in code of t, so the qr code rotates with
+// the code. The token is all the qr code holds, which clients post to
+// check in. It returns when the code rotates.
+func (c *Class) CheckInToken(session Session, t time.Time) (string, time.Time, error) {
+	code, expires, err := c.Code(t)
+	if err != nil {
+		return "", expires, err
+	}
+
+	// codes are accepted for one period after they rotate
+	token, err := s.Keys.Sign(jwt.MapClaims{
+		"typ":     tokenCheckIn,
+		"class":   c.ID.Hex(),
+		"session": session.ID,
+		"code":    code,
+		"iat":     t.Unix(),
+		"exp":     expires.Unix() + c.codePeriod(),
+	})
+	return token, expires, err
+}
+
+// ParseCheckInPayload verifies a scanned check in token and returns
+// the class, session and check in code it holds
+func ParseCheckInPayload(payload string) (bson.ObjectId, string, string, error) {
+
+	// verify signature and expiry
+	token, err := s.Keys.Parse(payload)
+	if err != nil || !token.Valid {
+		return "", "", "", ErrInvalidQR
+	}
+
+	// ensure token is a check in token
+	claims := token.Claims.(jwt.MapClaims)
+	class, _ := claims["class"].(string)
+	session, _ := claims["session"].(string)
+	code, _ := claims["code"].(string)
+	if claims["typ"] != tokenCheckIn || !bson.IsObjectIdHex(class) || session == "" || code == "" {
+		return "", "", "", ErrInvalidQR
+	}
+
+	return bson.ObjectIdHex(class), session, code, nil
+}
+
+// QRCodePNG renders content as a png qr code
+func QRCodePNG(content string) ([]byte, error) {
+	return qrcode.Encode(content, qrcode.Medium, qrSize)
+}
+
+// QRCodeSVG renders content as an svg qr code
+func QRCodeSVG(content string) ([]byte, error) {
+	code, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+	bitmap := code.Bitmap()
+	size := len(bitmap)
+
+	// draw one unit square per dark module
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, size, size)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&buf, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+
+	return buf.Bytes(), nil
+}
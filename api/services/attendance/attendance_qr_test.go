@@ -0,0 +1,100 @@
+package attendance
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/edwintcloud/classmate/api/services/server"
+	"github.com/globalsign/mgo/bson"
+	"github.com/labstack/echo"
+)
+
+// testKeys sets up the server with a keyring in a temporary key file
+func testKeys(t *testing.T) {
+	t.Setenv("JWT_KEY_FILE", filepath.Join(t.TempDir(), "keys.json"))
+	t.Setenv("JWT_SECRET", "")
+	s = &server.Server{Echo: echo.New(), Events: server.NewEventBus()}
+	s.LoadKeys()
+}
+
+// qrClass returns a class with a code secret and a session now
+func qrClass(t *testing.T) (*Class, Session) {
+	now := time.Now()
+	class := &Class{ID: bson.NewObjectId(), CodePeriod: 30}
+	if err := class.GenerateCodeSecret(); err != nil {
+		t.Fatal(err)
+	}
+	return class, Session{ID: now.Format(dateLayout), Start: now.Add(-time.Hour), End: now.Add(time.Hour)}
+}
+
+func TestCheckInTokenRotatesWithCode(t *testing.T) {
+	testKeys(t)
+	class, session := qrClass(t)
+
+	now := time.Now()
+	content, expires, err := class.CheckInToken(session, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, want, _ := class.Code(now); !expires.Equal(want) {
+		t.Errorf("expires = %s, want %s", expires, want)
+	}
+
+	classID, sessionID, code, err := ParseCheckInPayload(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if classID != class.ID || sessionID != session.ID {
+		t.Fatalf("payload is for %s %s", classID.Hex(), sessionID)
+	}
+	if !class.VerifyCode(code, now) {
+		t.Error("code of the qr code is not valid now")
+	}
+	if class.VerifyCode(code, expires.Add(time.Duration(class.CodePeriod)*time.Second)) {
+		t.Error("code of the qr code is valid after it rotated twice")
+	}
+
+	// tokens of the previous code expire along with it
+	old, _, err := class.CheckInToken(session, now.Add(-time.Duration(3*class.CodePeriod)*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := ParseCheckInPayload(old); err != ErrInvalidQR {
+		t.Errorf("ParseCheckInPayload(old) = %v, want ErrInvalidQR", err)
+	}
+}
+
+func TestMiddlewareRejectsCheckInTokens(t *testing.T) {
+	testKeys(t)
+	class, session := qrClass(t)
+
+	checkIn, _, err := class.CheckInToken(session, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	person := &Person{ID: bson.NewObjectId()}
+	if err := person.issueToken(&Login{ID: bson.NewObjectId()}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// access tokens are the only bearer tokens
+	if _, _, _, err := ParseCheckInPayload(person.Token); err != ErrInvalidQR {
+		t.Errorf("ParseCheckInPayload(access token) = %v, want ErrInvalidQR", err)
+	}
+	handler := s.Keys.Middleware()(func(c echo.Context) error {
+		return c.NoContent(204)
+	})
+	for token, want := range map[string]int{checkIn: 401, person.Token: 204} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		rec := httptest.NewRecorder()
+		if err := handler(s.Echo.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != want {
+			t.Errorf("status = %d, want %d", rec.Code, want)
+		}
+	}
+}
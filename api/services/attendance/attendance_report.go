@@ -0,0 +1,255 @@
+package attendance
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// ClassReport is the attendance matrix of students by sessions of a class
+type ClassReport struct {
+	Class    bson.ObjectId   `json:"class"`
+	Title    string          `json:"title"`
+	Sessions []Session       `json:"sessions"`
+	Students []StudentReport `json:"students"`
+	Summary  Summary         `json:"summary"`
+}
+
+// StudentReport is the attendance of a student by session id
+type StudentReport struct {
+	Student    bson.ObjectId     `json:"student"`
+	Email      string            `json:"email"`
+	FirstName  string            `json:"first_name"`
+	LastName   string            `json:"last_name"`
+	Attendance map[string]string `json:"attendance"`
+	Summary    Summary           `json:"summary"`
+}
+
+// PersonReport is the attendance history of a person across classes
+type PersonReport struct {
+	Person  bson.ObjectId  `json:"person"`
+	Classes []ClassHistory `json:"classes"`
+	Summary Summary        `json:"summary"`
+}
+
+// ClassHistory is the attendance of a person for each session of a class
+type ClassHistory struct {
+	Class    bson.ObjectId       `json:"class"`
+	Title    string              `json:"title"`
+	Sessions []SessionAttendance `json:"sessions"`
+	Summary  Summary             `json:"summary"`
+}
+
+// SessionAttendance is the attendance of a person for a session
+type SessionAttendance struct {
+	Session
+	Status    string     `json:"status"`
+	CheckedIn *time.Time `json:"checked_in,omitempty"`
+}
+
+// Summary counts attendance by status
+type Summary struct {
+	Sessions int            `json:"sessions"`
+	Counts   map[string]int `json:"counts"`
+	Rate     float64        `json:"rate"`
+}
+
+// add counts a session with status in the summary. The rate is the
+// share of sessions attended, late or not, ignoring excused sessions.
+func (s *Summary) add(status string) {
+	if s.Counts == nil {
+		s.Counts = map[string]int{}
+	}
+	s.Sessions++
+	s.Counts[status]++
+
+	attended := s.Counts[StatusPresent] + s.Counts[StatusLate]
+	expected := s.Sessions - s.Counts[StatusExcused]
+	s.Rate = 0
+	if expected > 0 {
+		s.Rate = float64(attended) / float64(expected)
+	}
+}
+
+// merge adds the counts of other to the summary
+func (s *Summary) merge(other Summary) {
+	for status, count := range other.Counts {
+		for i := 0; i < count; i++ {
+			s.add(status)
+		}
+	}
+}
+
+// ReportSessions returns the sessions of a class between from and to that
+// have started by now. Zero from and to default to the class date range.
+func (c *Class) ReportSessions(from, to, now time.Time) ([]Session, error) {
+	if from.IsZero() {
+		from = c.StartDate
+	}
+	if to.IsZero() {
+		to = c.EndDate.AddDate(0, 0, 1)
+	}
+	if to.After(now) {
+		to = now
+	}
+
+	sessions, err := c.Sessions(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// drop sessions overlapping the range that have not started
+	started := []Session{}
+	for _, session := range sessions {
+		if !session.Start.Before(from) && !session.Start.After(to) {
+			started = append(started, session)
+		}
+	}
+	return started, nil
+}
+
+// BuildClassReport builds the attendance matrix of students for sessions
+func BuildClassReport(class Class, sessions []Session, students []Person, records []Attendance) ClassReport {
+	report := ClassReport{
+		Class:    class.ID,
+		Title:    class.Title,
+		Sessions: sessions,
+		Students: []StudentReport{},
+	}
+
+	// index records by student and session
+	checkIns := map[bson.ObjectId]map[string]Attendance{}
+	for _, record := range records {
+		if checkIns[record.Student] == nil {
+			checkIns[record.Student] = map[string]Attendance{}
+		}
+		checkIns[record.Student][record.Session] = record
+	}
+
+	for _, student := range students {
+		row := StudentReport{
+			Student:    student.ID,
+			Email:      student.Email,
+			FirstName:  student.FirstName,
+			LastName:   student.LastName,
+			Attendance: map[string]string{},
+		}
+		for _, session := range sessions {
+			status := StatusAbsent
+			if record, ok := checkIns[student.ID][session.ID]; ok {
+				status = statusOf(record)
+			}
+			row.Attendance[session.ID] = status
+			row.Summary.add(status)
+			report.Summary.add(status)
+		}
+		report.Students = append(report.Students, row)
+	}
+
+	return report
+}
+
+// BuildClassHistory builds the attendance of a person for sessions of a class
+func BuildClassHistory(class Class, sessions []Session, records []Attendance) ClassHistory {
+	history := ClassHistory{
+		Class:    class.ID,
+		Title:    class.Title,
+		Sessions: []SessionAttendance{},
+	}
+
+	// index records by session
+	checkIns := map[string]Attendance{}
+	for _, record := range records {
+		if record.Class == class.ID {
+			checkIns[record.Session] = record
+		}
+	}
+
+	for _, session := range sessions {
+		entry := SessionAttendance{Session: session, Status: StatusAbsent}
+		if record, ok := checkIns[session.ID]; ok {
+			entry.Status = statusOf(record)
+			if !record.CheckedIn.IsZero() {
+				entry.CheckedIn = &record.CheckedIn
+			}
+		}
+		history.Sessions = append(history.Sessions, entry)
+		history.Summary.add(entry.Status)
+	}
+
+	return history
+}
+
+// BuildPersonReport builds the attendance history of a person
+// from their history in each class
+func BuildPersonReport(person bson.ObjectId, histories []ClassHistory) PersonReport {
+	report := PersonReport{
+		Person:  person,
+		Classes: histories,
+	}
+	for _, history := range histories {
+		report.Summary.merge(history.Summary)
+	}
+	return report
+}
+
+// WriteCSV writes the report with one row per student and one column per session
+func (r *ClassReport) WriteCSV(out io.Writer) error {
+	w := csv.NewWriter(out)
+
+	header := []string{"student", "email", "first_name", "last_name"}
+	for _, session := range r.Sessions {
+		header = append(header, session.ID)
+	}
+	header = append(header, "sessions", "present", "late", "absent", "excused", "rate")
+	w.Write(header)
+
+	for _, row := range r.Students {
+		record := []string{row.Student.Hex(), row.Email, row.FirstName, row.LastName}
+		for _, session := range r.Sessions {
+			record = append(record, row.Attendance[session.ID])
+		}
+		record = append(record,
+			fmt.Sprint(row.Summary.Sessions),
+			fmt.Sprint(row.Summary.Counts[StatusPresent]),
+			fmt.Sprint(row.Summary.Counts[StatusLate]),
+			fmt.Sprint(row.Summary.Counts[StatusAbsent]),
+			fmt.Sprint(row.Summary.Counts[StatusExcused]),
+			fmt.Sprintf("%.2f", row.Summary.Rate),
+		)
+		w.Write(record)
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+// WriteCSV writes the report with one row per class session
+func (r *PersonReport) WriteCSV(out io.Writer) error {
+	w := csv.NewWriter(out)
+
+	w.Write([]string{"class", "title", "session", "start", "end", "status", "checked_in"})
+	for _, class := range r.Classes {
+		for _, session := range class.Sessions {
+			checkedIn := ""
+			if session.CheckedIn != nil {
+				checkedIn = session.CheckedIn.Format(time.RFC3339)
+			}
+			w.Write([]string{
+				class.Class.Hex(),
+				class.Title,
+				session.ID,
+				session.Start.Format(time.RFC3339),
+				session.End.Format(time.RFC3339),
+				session.Status,
+				checkedIn,
+			})
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
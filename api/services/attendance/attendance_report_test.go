@@ -0,0 +1,95 @@
+package attendance
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestClassReport(t *testing.T) {
+	class := Class{ID: bson.NewObjectId(), Title: "Algorithms"}
+	start := time.Date(2026, 9, 7, 9, 0, 0, 0, time.UTC)
+	sessions := []Session{}
+	for i := 0; i < 4; i++ {
+		day := start.AddDate(0, 0, 7*i)
+		sessions = append(sessions, Session{ID: day.Format("2006-01-02"), Start: day, End: day.Add(time.Hour)})
+	}
+	ada := Person{ID: bson.NewObjectId(), Email: "ada@example.com", FirstName: "Ada", LastName: "Lovelace, Countess"}
+	grace := Person{ID: bson.NewObjectId(), Email: "grace@example.com", FirstName: "Grace", LastName: "Hopper"}
+
+	// records without a status predate statuses and count as present,
+	// and sessions without records as absent
+	records := []Attendance{
+		{Student: ada.ID, Session: sessions[0].ID, Status: StatusPresent},
+		{Student: ada.ID, Session: sessions[1].ID, Status: StatusLate},
+		{Student: ada.ID, Session: sessions[2].ID, Status: StatusExcused},
+		{Student: grace.ID, Session: sessions[0].ID},
+		{Student: grace.ID, Session: "2026-01-01", Status: StatusPresent},
+	}
+	report := BuildClassReport(class, sessions, []Person{ada, grace}, records)
+
+	if report.Class != class.ID || report.Title != class.Title || len(report.Students) != 2 {
+		t.Fatalf("report = %+v", report)
+	}
+	want := []map[string]string{
+		{sessions[0].ID: StatusPresent, sessions[1].ID: StatusLate, sessions[2].ID: StatusExcused, sessions[3].ID: StatusAbsent},
+		{sessions[0].ID: StatusPresent, sessions[1].ID: StatusAbsent, sessions[2].ID: StatusAbsent, sessions[3].ID: StatusAbsent},
+	}
+	for i, row := range report.Students {
+		if !reflect.DeepEqual(row.Attendance, want[i]) {
+			t.Errorf("attendance of %s = %v, want %v", row.Email, row.Attendance, want[i])
+		}
+	}
+
+	// rates count late as attended and leave out excused sessions
+	if summary := report.Students[0].Summary; summary.Sessions != 4 || summary.Rate != 2.0/3 {
+		t.Errorf("ada summary = %+v", summary)
+	}
+	if summary := report.Students[1].Summary; summary.Sessions != 4 || summary.Rate != 0.25 {
+		t.Errorf("grace summary = %+v", summary)
+	}
+	wantCounts := map[string]int{StatusPresent: 2, StatusLate: 1, StatusExcused: 1, StatusAbsent: 4}
+	if summary := report.Summary; summary.Sessions != 8 || !reflect.DeepEqual(summary.Counts, wantCounts) || summary.Rate != 3.0/7 {
+		t.Errorf("class summary = %+v", summary)
+	}
+
+	// csv has a column per session and quotes fields as needed
+	out := bytes.Buffer{}
+	if err := report.WriteCSV(&out); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRows := [][]string{
+		{"student", "email", "first_name", "last_name", "2026-09-07", "2026-09-14", "2026-09-21", "2026-09-28", "sessions", "present", "late", "absent", "excused", "rate"},
+		{ada.ID.Hex(), "ada@example.com", "Ada", "Lovelace, Countess", "present", "late", "excused", "absent", "4", "1", "1", "1", "1", "0.67"},
+		{grace.ID.Hex(), "grace@example.com", "Grace", "Hopper", "present", "absent", "absent", "absent", "4", "1", "0", "3", "0", "0.25"},
+	}
+	if !reflect.DeepEqual(rows, wantRows) {
+		t.Errorf("csv = %v, want %v", rows, wantRows)
+	}
+}
+
+func TestClassReportWithoutSessions(t *testing.T) {
+	student := Person{ID: bson.NewObjectId(), Email: "ada@example.com"}
+	report := BuildClassReport(Class{ID: bson.NewObjectId()}, []Session{}, []Person{student}, nil)
+	if len(report.Students) != 1 || len(report.Students[0].Attendance) != 0 || report.Students[0].Summary.Rate != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+
+	out := bytes.Buffer{}
+	if err := report.WriteCSV(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := "student,email,first_name,last_name,sessions,present,late,absent,excused,rate\n" +
+		student.ID.Hex() + ",ada@example.com,,,0,0,0,0,0,0.00\n"
+	if out.String() != want {
+		t.Errorf("csv = %q, want %q", out.String(), want)
+	}
+}
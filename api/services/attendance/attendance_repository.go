@@ -0,0 +1,148 @@
+package attendance
+
+import (
+	"errors"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// repository errors shared by every storage backend
+var (
+	ErrNotFound  = errors.New("not found")
+	ErrDuplicate = errors.New("duplicate key")
+)
+
+// Store holds the repositories the attendance service keeps its data in
+type Store struct {
+	Persons    PersonRepository
+	Classes    ClassRepository
+	Attendance AttendanceRepository
+	Excuses    ExcuseRepository
+	Locations  LocationRepository
+	Logins     LoginRepository
+	Terms      TermRepository
+	Webhooks   WebhookRepository
+	Deliveries DeliveryRepository
+}
+
+// PersonRepository stores persons. Find methods fill p and
+// return ErrNotFound, leaving p untouched, if none matches.
+// FindByFilter reads the persons matching filter in the order
+// and page of opts.
+type PersonRepository interface {
+	Insert(p *Person) error
+	Find(id bson.ObjectId, p *Person) error
+	FindByEmail(email string, p *Person) error
+	FindByInviteToken(hash string, p *Person) error
+	FindByFeedToken(hash string, p *Person) error
+	FindAll(ids []bson.ObjectId) ([]Person, error)
+	FindEnrollments() ([]Person, error)
+	EmailTaken(email string, except bson.ObjectId) (bool, error)
+	FindByFilter(filter PersonFilter, opts listOptions) ([]Person, error)
+	CountByFilter(filter PersonFilter) (int, error)
+	UpdateNames(id bson.ObjectId, first, last string) error
+	UpdateProfile(id bson.ObjectId, first, last, email string) error
+	UpdatePassword(id bson.ObjectId, password string) error
+	SetDeactivated(id bson.ObjectId, t *time.Time) error
+	UpdateRole(id bson.ObjectId, role string) error
+	UpdateFeedToken(id bson.ObjectId, hash string) error
+	UpdateResetToken(id bson.ObjectId, hash string, expires *time.Time) error
+	UpdateInviteToken(id bson.ObjectId, hash string, invited *time.Time) error
+	AcceptInvite(id bson.ObjectId, password string) error
+	ResetPassword(hash, password string, t time.Time, p *Person) error
+	AddClass(id, class bson.ObjectId) error
+	RemoveClass(id, class bson.ObjectId) error
+}
+
+// ClassRepository stores classes. Update saves the fields set by
+// Class.setFields if the class is at version, returning ErrNotFound
+// otherwise, and increments the version of c. Every other update
+// increments the version of the class too.
+type ClassRepository interface {
+	Insert(c *Class) error
+	Find(id bson.ObjectId, c *Class) error
+	FindAll(ids []bson.ObjectId) ([]Class, error)
+	FindByInstructor(instructor bson.ObjectId) ([]Class, error)
+	FindEnrollments() ([]Class, error)
+	CountByTerm(term bson.ObjectId) (int, error)
+	FindByFilter(filter ClassFilter, opts listOptions) ([]Class, error)
+	CountByFilter(filter ClassFilter) (int, error)
+	FindMissing(ids []bson.ObjectId) ([]bson.ObjectId, error)
+	Update(c *Class, version int) error
+	UpdateSchedule(id bson.ObjectId, timezone string, schedule *Schedule) error
+	UpdatePolicy(id bson.ObjectId, policy Policy) error
+	UpdateCode(id bson.ObjectId, secret string, period int) error
+	AddStudent(id, student bson.ObjectId) error
+	RemoveStudent(id, student bson.ObjectId) error
+}
+
+// AttendanceRepository stores attendance records, returning
+// ErrDuplicate for a second record of a student in a session
+type AttendanceRepository interface {
+	Insert(a *Attendance) error
+	UpdateStatus(id bson.ObjectId, status string) error
+	SetStatus(class, student bson.ObjectId, session, status string) error
+	FindByClass(class bson.ObjectId) ([]Attendance, error)
+	FindByStudent(student bson.ObjectId) ([]Attendance, error)
+}
+
+// ExcuseRepository stores excuses, returning ErrDuplicate for a second
+// excuse with the same open key. UpdateStatus sets the open key of the
+// new status.
+type ExcuseRepository interface {
+	Insert(e *Excuse) error
+	Find(id bson.ObjectId, e *Excuse) error
+	UpdateStatus(id bson.ObjectId, from string, event ExcuseEvent) error
+	FindAll(filter ExcuseFilter) ([]Excuse, error)
+}
+
+// LocationRepository stores locations
+type LocationRepository interface {
+	Insert(l *Location) error
+	Find(id bson.ObjectId, l *Location) error
+	Update(l *Location) error
+	FindAll() ([]Location, error)
+}
+
+// TermRepository stores terms
+type TermRepository interface {
+	Insert(t *Term) error
+	Find(id bson.ObjectId, t *Term) error
+	Update(t *Term) error
+	Delete(id bson.ObjectId) error
+	FindAll() ([]Term, error)
+}
+
+// WebhookRepository stores webhooks
+type WebhookRepository interface {
+	Insert(w *Webhook) error
+	Find(id bson.ObjectId, w *Webhook) error
+	Update(w *Webhook) error
+	Delete(id bson.ObjectId) error
+	FindAll() ([]Webhook, error)
+}
+
+// DeliveryRepository stores the deliveries of webhooks. FindByWebhook
+// lists newest first and FindPending oldest first. Claim leases the
+// next attempt of a pending delivery until until, returning ErrNotFound
+// if it was attempted or is leased at t.
+type DeliveryRepository interface {
+	Insert(d *Delivery) error
+	Find(id bson.ObjectId, d *Delivery) error
+	Update(d *Delivery) error
+	Claim(id bson.ObjectId, attempts int, t, until time.Time) error
+	FindByWebhook(webhook bson.ObjectId) ([]Delivery, error)
+	FindPending() ([]Delivery, error)
+	DeleteByWebhook(webhook bson.ObjectId) error
+}
+
+// LoginRepository stores login sessions
+type LoginRepository interface {
+	Insert(l *Login) error
+	Find(id bson.ObjectId, l *Login) error
+	FindByToken(hash string, t time.Time, l *Login) error
+	Renew(l *Login, previous string) error
+	Revoke(id bson.ObjectId, t time.Time) error
+	RevokeAll(person bson.ObjectId, t time.Time) (int, error)
+}
@@ -0,0 +1,67 @@
+package attendance
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/edwintcloud/classmate/api/services/server"
+)
+
+// resetTokenLifetime is how long a password reset token can be used
+const resetTokenLifetime = time.Hour
+
+// ErrInvalidResetToken is returned when a reset token is unknown,
+// expired or already used
+var ErrInvalidResetToken = errors.New("Invalid or expired reset token")
+
+// RequestReset issues a password reset token for a person and mails
+// it to them along with the url it is submitted to
+func (p *Person) RequestReset(base string, t time.Time) error {
+
+	// generate token, saving only its hash
+	token, err := server.RandomToken(32)
+	if err != nil {
+		return err
+	}
+	expires := t.Add(resetTokenLifetime)
+	p.ResetToken = server.HashToken(token)
+	p.ResetExpires = &expires
+	err = p.UpdateResetToken()
+	if err != nil {
+		return err
+	}
+
+	// mail token to person
+	return s.Mailer.Send(server.Message{
+		To:      p.Email,
+		Subject: "Reset your Classmate password",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Someone asked to reset the password of your Classmate account. "+
+			"If it was you, submit this token with your new password to %s/api/v1/persons/password/reset:\n\n"+
+			"%s\n\n"+
+			"The token can be used once and expires at %s. "+
+			"If you did not ask to reset your password you can ignore this email.\n",
+			p.FirstName, base, token, expires.UTC().Format("2006-01-02 15:04 MST")),
+	})
+}
+
+// ConfirmReset sets a new password with a reset token and revokes every
+// login of the person, returning the person the token belonged to
+func ConfirmReset(token, password string, t time.Time) (*Person, error) {
+	person := Person{}
+
+	// set password if token is valid
+	err := person.ResetPassword(token, password, t)
+	if err == ErrNotFound {
+		return nil, ErrInvalidResetToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// sign out sessions that may have used the old password
+	_, err = RevokeLogins(person.ID, t)
+
+	return &person, err
+}
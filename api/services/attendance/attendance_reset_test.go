@@ -0,0 +1,62 @@
+package attendance
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edwintcloud/classmate/api/services/server"
+	"github.com/globalsign/mgo/bson"
+)
+
+// mailbox keeps the messages sent through it
+type mailbox struct {
+	mutex    sync.Mutex
+	messages []server.Message
+}
+
+func (m *mailbox) Send(msg server.Message) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// resetToken requests a password reset for p at t, returning the
+// token mailed to them
+func resetToken(t *testing.T, mail *mailbox, p *Person, at time.Time) string {
+	if err := p.RequestReset(s.PublicURL, at); err != nil {
+		t.Fatal(err)
+	}
+	msg := mail.messages[len(mail.messages)-1]
+	if msg.To != p.Email || !strings.Contains(msg.Body, s.PublicURL+"/api/v1/persons/password/reset") {
+		t.Fatalf("reset mail = %+v", msg)
+	}
+	// the token is the paragraph after the url
+	return strings.Split(msg.Body, "\n\n")[2]
+}
+
+func TestResetTokenIsUsedOnceBeforeExpiry(t *testing.T) {
+	mail := &mailbox{}
+	s = &server.Server{Mailer: mail, PublicURL: "https://classmate.example"}
+	db = NewMemoryStore()
+	person := Person{ID: bson.NewObjectId(), Email: "ada@example.com", Role: RoleStudent, Classes: []bson.ObjectId{}}
+	if err := person.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	token := resetToken(t, mail, &person, now)
+	if _, err := ConfirmReset(token, testPassword, now.Add(time.Minute)); err != nil {
+		t.Fatalf("ConfirmReset() = %v", err)
+	}
+	if _, err := ConfirmReset(token, testPassword, now.Add(time.Minute)); err != ErrInvalidResetToken {
+		t.Fatalf("ConfirmReset() again = %v, want ErrInvalidResetToken", err)
+	}
+
+	token = resetToken(t, mail, &person, now)
+	if _, err := ConfirmReset(token, testPassword, now.Add(resetTokenLifetime)); err != ErrInvalidResetToken {
+		t.Fatalf("ConfirmReset() once expired = %v, want ErrInvalidResetToken", err)
+	}
+}
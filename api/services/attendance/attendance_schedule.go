@@ -0,0 +1,325 @@
+package attendance
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateLayout is the layout of session ids and schedule dates
+const dateLayout = "2006-01-02"
+
+// maxScheduleDays bounds session expansion of classes without an end date
+const maxScheduleDays = 366 * 2
+
+// weekdays maps RFC 5545 weekday names to weekdays
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// recurrence is the parsed rule a class repeats by
+type recurrence struct {
+	freq     string
+	interval int
+	days     map[time.Weekday]bool
+	until    time.Time
+	count    int
+}
+
+// CurrentSession returns the session of the class taking place at t
+func (c *Class) CurrentSession(t time.Time) (Session, error) {
+	sessions, err := c.Sessions(t, t)
+	if err != nil {
+		return Session{}, err
+	}
+	if len(sessions) == 0 {
+		return Session{}, ErrNoSession
+	}
+	return sessions[0], nil
+}
+
+// Sessions expands the schedule of the class into the sessions overlapping
+// from through to, ordered by start. Classes without a schedule meet every
+// day between StartDate and EndDate. No sessions are held in the breaks of
+// the term of the class unless they were rescheduled.
+func (c *Class) Sessions(from, to time.Time) ([]Session, error) {
+	loc, err := c.timezone()
+	if err != nil {
+		return nil, err
+	}
+	rule, err := c.recurrence(loc)
+	if err != nil {
+		return nil, err
+	}
+
+	// index exceptions and reschedules by session id
+	skip := map[string]bool{}
+	moved := map[string]Reschedule{}
+	if c.Schedule != nil {
+		for _, date := range c.Schedule.Exceptions {
+			skip[date] = true
+		}
+		for _, r := range c.Schedule.Reschedules {
+			moved[r.Session] = r
+		}
+	}
+
+	// find breaks of the term of the class
+	breaks, err := c.termBreaks()
+	if err != nil {
+		return nil, err
+	}
+
+	// find last day the class can meet
+	first := dateOf(c.StartDate, loc)
+	last := dateOf(c.EndDate, loc)
+	if c.EndDate.IsZero() || last.After(first.AddDate(0, 0, maxScheduleDays)) {
+		last = first.AddDate(0, 0, maxScheduleDays)
+	}
+	if !rule.until.IsZero() && rule.until.Before(last) {
+		last = rule.until
+	}
+
+	sessions := []Session{}
+	count := 0
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		if !rule.occursOn(day, first) {
+			continue
+		}
+		count++
+		if rule.count > 0 && count > rule.count {
+			break
+		}
+
+		// skip cancelled sessions
+		id := day.Format(dateLayout)
+		if skip[id] {
+			continue
+		}
+
+		// skip sessions in term breaks unless they were moved
+		if _, ok := moved[id]; !ok && inBreak(breaks, id) {
+			continue
+		}
+
+		session := Session{
+			ID:       id,
+			Start:    clockOn(day, c.StartTime),
+			End:      clockOn(day, c.EndTime),
+			Location: c.Location,
+		}
+
+		// apply one off reschedules
+		if r, ok := moved[id]; ok {
+			session.Start = r.Start.In(loc)
+			session.End = r.End.In(loc)
+			session.Rescheduled = true
+			if r.Location != "" {
+				session.Location = r.Location
+			}
+		}
+
+		if session.End.Before(from) || session.Start.After(to) {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	// reschedules can move sessions past each other
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Start.Before(sessions[j].Start)
+	})
+
+	return sessions, nil
+}
+
+// ValidateSchedule ensures the timezone and schedule of the class are valid
+func (c *Class) ValidateSchedule() error {
+	loc, err := c.timezone()
+	if err != nil {
+		return err
+	}
+	_, err = c.recurrence(loc)
+	if err != nil {
+		return err
+	}
+	if c.Schedule == nil {
+		return nil
+	}
+
+	for _, date := range c.Schedule.Exceptions {
+		_, err = time.Parse(dateLayout, date)
+		if err != nil {
+			return fmt.Errorf("Invalid exception date %q", date)
+		}
+	}
+	for _, r := range c.Schedule.Reschedules {
+		_, err = time.Parse(dateLayout, r.Session)
+		if err != nil {
+			return fmt.Errorf("Invalid rescheduled session %q", r.Session)
+		}
+		if !r.End.After(r.Start) {
+			return fmt.Errorf("Rescheduled session %s must end after it starts", r.Session)
+		}
+	}
+
+	return nil
+}
+
+// timezone returns the location class times are evaluated in,
+// defaulting to UTC as stored times carry no offset
+func (c *Class) timezone() (*time.Location, error) {
+	if c.Timezone == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid timezone %q", c.Timezone)
+	}
+	return loc, nil
+}
+
+// recurrence returns the rule the class repeats by
+func (c *Class) recurrence(loc *time.Location) (recurrence, error) {
+	rule := recurrence{freq: "DAILY", interval: 1}
+	if c.Schedule == nil {
+		return rule, nil
+	}
+
+	if len(c.Schedule.Weekdays) > 0 && c.Schedule.RRule != "" {
+		return rule, fmt.Errorf("Schedule can have weekdays or rrule, not both")
+	}
+
+	if len(c.Schedule.Weekdays) > 0 {
+		rule.freq = "WEEKLY"
+		rule.days = map[time.Weekday]bool{}
+		for _, name := range c.Schedule.Weekdays {
+			day, ok := weekdays[strings.ToUpper(name)]
+			if !ok {
+				return rule, fmt.Errorf("Invalid weekday %q", name)
+			}
+			rule.days[day] = true
+		}
+	}
+
+	if c.Schedule.RRule != "" {
+		return parseRRule(c.Schedule.RRule, loc)
+	}
+
+	return rule, nil
+}
+
+// parseRRule parses the FREQ, INTERVAL, BYDAY, UNTIL and COUNT
+// parts of an RFC 5545 recurrence rule
+func parseRRule(value string, loc *time.Location) (recurrence, error) {
+	rule := recurrence{interval: 1}
+
+	value = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(value)), "RRULE:")
+	for _, part := range strings.Split(value, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return rule, fmt.Errorf("Invalid rrule part %q", part)
+		}
+
+		var err error
+		switch kv[0] {
+		case "FREQ":
+			if kv[1] != "DAILY" && kv[1] != "WEEKLY" {
+				return rule, fmt.Errorf("Unsupported rrule frequency %q", kv[1])
+			}
+			rule.freq = kv[1]
+		case "INTERVAL":
+			rule.interval, err = strconv.Atoi(kv[1])
+			if err != nil || rule.interval < 1 {
+				return rule, fmt.Errorf("Invalid rrule interval %q", kv[1])
+			}
+		case "COUNT":
+			rule.count, err = strconv.Atoi(kv[1])
+			if err != nil || rule.count < 1 {
+				return rule, fmt.Errorf("Invalid rrule count %q", kv[1])
+			}
+		case "UNTIL":
+			rule.until, err = parseRRuleDate(kv[1], loc)
+			if err != nil {
+				return rule, fmt.Errorf("Invalid rrule until %q", kv[1])
+			}
+		case "BYDAY":
+			rule.days = map[time.Weekday]bool{}
+			for _, name := range strings.Split(kv[1], ",") {
+				day, ok := weekdays[name]
+				if !ok {
+					return rule, fmt.Errorf("Unsupported rrule weekday %q", name)
+				}
+				rule.days[day] = true
+			}
+		case "WKST":
+			if kv[1] != "MO" {
+				return rule, fmt.Errorf("Unsupported rrule week start %q", kv[1])
+			}
+		default:
+			return rule, fmt.Errorf("Unsupported rrule part %q", kv[0])
+		}
+	}
+
+	if rule.freq == "" {
+		return rule, fmt.Errorf("rrule frequency is required")
+	}
+
+	return rule, nil
+}
+
+// parseRRuleDate parses an rrule date or utc date time as a date in loc
+func parseRRuleDate(value string, loc *time.Location) (time.Time, error) {
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return dateOf(t.In(loc), loc), err
+	}
+	return time.ParseInLocation("20060102", value, loc)
+}
+
+// occursOn returns true if the rule has an occurrence on day
+// for a class first meeting on first
+func (r recurrence) occursOn(day, first time.Time) bool {
+	switch r.freq {
+	case "DAILY":
+		if daysBetween(first, day)%r.interval != 0 {
+			return false
+		}
+		return len(r.days) == 0 || r.days[day.Weekday()]
+	case "WEEKLY":
+		// weeks start on monday
+		weekStart := first.AddDate(0, 0, -((int(first.Weekday()) + 6) % 7))
+		if daysBetween(weekStart, day)/7%r.interval != 0 {
+			return false
+		}
+		if len(r.days) == 0 {
+			return day.Weekday() == first.Weekday()
+		}
+		return r.days[day.Weekday()]
+	}
+	return false
+}
+
+// daysBetween returns the number of calendar days from a to b
+func daysBetween(a, b time.Time) int {
+	return int(b.Sub(a).Hours()/24 + 0.5)
+}
+
+// dateOf returns midnight in loc of the calendar date of t
+func dateOf(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// clockOn returns the wall clock time of clock in the location of day on day
+func clockOn(day time.Time, clock time.Time) time.Time {
+	clock = clock.In(day.Location())
+	return time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, day.Location())
+}
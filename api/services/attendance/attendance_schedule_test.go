@@ -0,0 +1,145 @@
+package attendance
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edwintcloud/classmate/api/services/server"
+	"github.com/globalsign/mgo/bson"
+)
+
+// scheduleClass returns a class of a teacher stored in a memory store
+// meeting daily from 18:00 to 20:00 at -05:00 in January 2026
+func scheduleClass(t *testing.T, timezone string) *Class {
+	db = NewMemoryStore()
+	teacher := Person{ID: bson.NewObjectId(), Email: "teacher@example.com", Role: RoleTeacher}
+	if err := db.Persons.Insert(&teacher); err != nil {
+		t.Fatal(err)
+	}
+
+	offset := time.FixedZone("", -5*3600)
+	return &Class{
+		Title:      "Evening class",
+		Instructor: teacher.ID,
+		StartTime:  time.Date(2026, 1, 1, 18, 0, 0, 0, offset),
+		EndTime:    time.Date(2026, 1, 1, 20, 0, 0, 0, offset),
+		StartDate:  time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC),
+		EndDate:    time.Date(2026, 1, 9, 0, 0, 0, 0, time.UTC),
+		Students:   []bson.ObjectId{},
+		Timezone:   timezone,
+	}
+}
+
+func TestSessionsUseClassTimezone(t *testing.T) {
+	class := scheduleClass(t, "America/New_York")
+	if err := class.Validate(); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+	if err := class.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	// stored times decode as UTC
+	stored := Class{ID: class.ID}
+	if err := stored.Find(); err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := stored.Sessions(stored.StartDate, stored.EndDate.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 5 {
+		t.Fatalf("got %d sessions, want 5", len(sessions))
+	}
+	for _, session := range sessions {
+		if h, m, _ := session.Start.Clock(); h != 18 || m != 0 {
+			t.Errorf("session %s starts at %s, want 18:00", session.ID, session.Start.Format("15:04"))
+		}
+		if h, m, _ := session.End.Clock(); h != 20 || m != 0 {
+			t.Errorf("session %s ends at %s, want 20:00", session.ID, session.End.Format("15:04"))
+		}
+		if session.Start.Location().String() != "America/New_York" {
+			t.Errorf("session %s is in %s", session.ID, session.Start.Location())
+		}
+	}
+}
+
+func TestValidateEndTimeInClassTimezone(t *testing.T) {
+	// without a timezone 18:00-20:00 at -05:00 is 23:00-01:00 UTC
+	class := scheduleClass(t, "")
+	err := class.Validate()
+	errs, ok := err.(server.ValidationErrors)
+	if !ok || len(errs) != 1 || errs[0].Field != "end_time" || errs[0].Code != CodeInvalid {
+		t.Fatalf("Validate() = %v, want invalid end_time", err)
+	}
+
+	class.Timezone = "America/Chicago"
+	if err := class.Validate(); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+}
+
+func TestParseRRule(t *testing.T) {
+	for _, value := range []string{
+		"FREQ=DAILY",
+		"RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE",
+		"freq=weekly;count=10;wkst=MO",
+		"FREQ=WEEKLY;UNTIL=20260131",
+		"FREQ=WEEKLY;UNTIL=20260131T235959Z",
+	} {
+		if _, err := parseRRule(value, time.UTC); err != nil {
+			t.Errorf("parseRRule(%q) = %v", value, err)
+		}
+	}
+	for _, value := range []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=MONTHLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=-1",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=WEEKLY;WKST=SU",
+		"FREQ=WEEKLY;UNTIL=tomorrow",
+		"FREQ=WEEKLY;BYMONTH=1",
+	} {
+		if _, err := parseRRule(value, time.UTC); err == nil {
+			t.Errorf("parseRRule(%q) succeeded", value)
+		}
+	}
+}
+
+func TestSessionsFollowRRule(t *testing.T) {
+	class := scheduleClass(t, "America/New_York")
+	class.EndDate = time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC)
+	class.Schedule = &Schedule{
+		RRule:      "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;COUNT=5",
+		Exceptions: []string{"2026-01-07"},
+		Reschedules: []Reschedule{{
+			Session: "2026-01-19",
+			Start:   time.Date(2026, 1, 20, 14, 0, 0, 0, time.UTC),
+			End:     time.Date(2026, 1, 20, 15, 0, 0, 0, time.UTC),
+		}},
+	}
+	if err := class.ValidateSchedule(); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := class.Sessions(class.StartDate, class.EndDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, session := range sessions {
+		got = append(got, session.ID)
+	}
+
+	// every other week, five occurrences with one cancelled
+	want := []string{"2026-01-05", "2026-01-19", "2026-01-21", "2026-02-02"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("sessions = %v, want %v", got, want)
+	}
+	if !sessions[1].Rescheduled || !sessions[1].Start.Equal(class.Schedule.Reschedules[0].Start) {
+		t.Errorf("session %s was not rescheduled: %+v", sessions[1].ID, sessions[1])
+	}
+}
@@ -1,57 +1,199 @@
 package attendance
 
 import (
-	"github.com/dgrijalva/jwt-go"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
 	"github.com/edwintcloud/classmate/api/services/server"
-	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 	"github.com/labstack/echo"
-	"github.com/labstack/echo/middleware"
 )
 
 var (
-	db = struct {
-		persons *mgo.Collection
-		classes *mgo.Collection
-	}{}
-	s *server.Server
+	db Store
+	s  *server.Server
 )
 
-// Register registers routes with echo
+// Register registers routes with echo, delivering webhooks with a
+// dispatcher configured by the environment
 func Register(svr *server.Server) {
+	RegisterWithDispatcher(svr, NewDispatcher())
+}
+
+// RegisterWithDispatcher registers routes with echo, delivering
+// webhooks with d
+func RegisterWithDispatcher(svr *server.Server, d *Dispatcher) {
+	var err error
 
 	// setup server var
 	s = svr
 
-	// setup db collections
-	db.persons = s.Db.C("persons")
-	db.classes = s.Db.C("classes")
+	// setup storage backend
+	switch s.Storage {
+	case server.StorageMongo:
+		db, err = NewMgoStore(s.Db)
+	case server.StorageMemory:
+		db = NewMemoryStore()
+	case server.StorageSQLite:
+		db, err = NewSQLStore(s.SQL, "sqlite")
+	case server.StoragePostgres:
+		db, err = NewSQLStore(s.SQL, "postgres")
+	}
+	if err != nil {
+		log.Fatalln("Unable to setup storage:", err.Error())
+	}
+
+	// setup events for servers built without one
+	if s.Events == nil {
+		s.Events = server.NewEventBus()
+	}
+
+	// deliver events to webhooks
+	dispatcher = d
+	dispatcher.Start()
 
 	s.Echo.POST("/api/v1/persons", CreatePerson)
 	s.Echo.POST("/api/v1/persons/login", LoginPerson)
+	s.Echo.POST("/api/v1/persons/refresh", RefreshPerson)
+	s.Echo.POST("/api/v1/persons/password/forgot", RequestPasswordReset)
+	s.Echo.POST("/api/v1/persons/password/reset", ConfirmPasswordReset)
+	s.Echo.GET("/api/v1/persons/calendar.ics", GetCalendar)
+	s.Echo.POST("/api/v1/persons/invitations/accept", AcceptInvitation)
 
 	s.Echo.GET("/", func(c echo.Context) error {
 		return c.JSON(200, server.Success())
 	})
 
 	// authorized routes
-	routes := s.Echo.Group("/api/v1")
-	routes.Use(middleware.JWT(s.JwtSecret))
+	routes := s.Echo.Group("/api/v1", s.Keys.Middleware(), LoadPerson)
 	{
+		routes.POST("/persons/logout", LogoutPerson)
+		routes.POST("/persons/logout/all", LogoutAllSessions)
+		routes.GET("/persons/me", GetMe)
+		routes.PATCH("/persons/me", UpdateMe)
 		routes.GET("/persons/classes", GetClassList)
-		routes.POST("/classes", CreateClass)
+		routes.GET("/persons/classes/current", GetCurrentClass)
+		routes.GET("/persons/terms/:id/classes", GetTermClassList)
+		routes.POST("/persons/calendar/token", CreateCalendarToken)
+		routes.GET("/persons/:id/attendance", GetPersonAttendance)
+		routes.POST("/classes/:id/checkin", CheckIn)
+		routes.POST("/checkin/qr", CheckInQR)
+		routes.GET("/classes/:id", GetClass)
+		routes.GET("/classes/:id/sessions", GetClassSessions)
+		routes.GET("/classes/:id/sessions/:session/stream", StreamSessionAttendance)
+		routes.PUT("/classes/:id/schedule", UpdateClassSchedule)
+		routes.GET("/classes/:id/attendance", GetClassAttendance)
+		routes.POST("/classes/:id/attendance/recompute", RecomputeClassAttendance)
+		routes.PUT("/classes/:id/policy", UpdateClassPolicy)
+		routes.POST("/classes/:id/excuses", SubmitExcuse)
+		routes.GET("/excuses", GetExcuseList)
+		routes.GET("/excuses/:id", GetExcuse)
+		routes.GET("/excuses/:id/attachment", GetExcuseAttachment)
+		routes.POST("/excuses/:id/approve", UpdateExcuse(ExcuseApproved))
+		routes.POST("/excuses/:id/reject", UpdateExcuse(ExcuseRejected))
+		routes.POST("/excuses/:id/withdraw", UpdateExcuse(ExcuseWithdrawn))
+		routes.GET("/locations", GetLocationList)
+		routes.GET("/terms", GetTermList)
+		routes.GET("/terms/:id", GetTerm)
+	}
+
+	// instructor routes, which check permissions route by route as
+	// middleware of a group would run for every unmatched route too
+	teaching := RequirePermission(PermTeach)
+	{
+		routes.GET("/classes/:id/code", GetClassCode, teaching)
+		routes.PUT("/classes/:id/code", UpdateClassCode, teaching)
+		routes.GET("/classes/:id/qr", GetClassQR, teaching)
+	}
+
+	// class administration routes
+	classes := RequirePermission(PermManageClasses)
+	{
+		routes.GET("/classes", GetClasses, classes)
+		routes.POST("/classes", CreateClass, classes)
+		routes.PATCH("/classes/:id", UpdateClass, classes)
+		routes.POST("/classes/:id/archive", ArchiveClass(true), classes)
+		routes.POST("/classes/:id/restore", ArchiveClass(false), classes)
+		routes.POST("/classes/:id/enroll", EnrollStudents(true), classes)
+		routes.POST("/classes/:id/unenroll", EnrollStudents(false), classes)
+		routes.PUT("/classes/:id/students/:student", EnrollStudent(true), classes)
+		routes.DELETE("/classes/:id/students/:student", EnrollStudent(false), classes)
+		routes.POST("/enrollment/repair", RepairEnrollments, classes)
+	}
+
+	// location administration routes
+	locations := RequirePermission(PermManageLocations)
+	{
+		routes.POST("/locations", CreateLocation, locations)
+		routes.PUT("/locations/:id", UpdateLocation, locations)
+	}
+
+	// term administration routes
+	terms := RequirePermission(PermManageTerms)
+	{
+		routes.POST("/terms", CreateTerm, terms)
+		routes.PUT("/terms/:id", UpdateTerm, terms)
+		routes.DELETE("/terms/:id", DeleteTerm, terms)
+	}
+
+	// person administration routes
+	persons := RequirePermission(PermManagePersons)
+	{
+		routes.POST("/persons/import", ImportPersons, persons)
+		routes.GET("/persons", GetPersons, persons)
+		routes.PATCH("/persons/:id", UpdatePerson, persons)
+		routes.PUT("/persons/:id/role", UpdatePersonRole, persons)
+		routes.POST("/persons/:id/deactivate", DeactivatePerson(true), persons)
+		routes.POST("/persons/:id/reactivate", DeactivatePerson(false), persons)
+	}
+
+	// webhook administration routes
+	webhooks := RequirePermission(PermManageWebhooks)
+	{
+		routes.GET("/webhooks", GetWebhookList, webhooks)
+		routes.POST("/webhooks", CreateWebhook, webhooks)
+		routes.GET("/webhooks/:id", GetWebhook, webhooks)
+		routes.PUT("/webhooks/:id", UpdateWebhook, webhooks)
+		routes.DELETE("/webhooks/:id", DeleteWebhook, webhooks)
+		routes.GET("/webhooks/:id/deliveries", GetWebhookDeliveries, webhooks)
+		routes.POST("/webhooks/:id/deliveries/:delivery/redeliver", RedeliverWebhook, webhooks)
+	}
+
+	// signing key administration routes
+	keys := RequirePermission(PermManageKeys)
+	{
+		routes.POST("/keys/rotate", RotateKeys, keys)
 	}
 }
 
 // CreatePerson is the a new person route
 func CreatePerson(c echo.Context) error {
-	person := Person{}
+	body := struct {
+		Email     string `json:"email"`
+		Password  string `json:"password"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+	}{}
 
-	// bind req body to person
-	err := c.Bind(&person)
+	// bind req body to body, so only these fields can be set
+	err := c.Bind(&body)
 	if err != nil {
 		return c.JSON(400, server.Error(err, 400))
 	}
+	person := Person{
+		Email:     body.Email,
+		Password:  body.Password,
+		FirstName: body.FirstName,
+		LastName:  body.LastName,
+	}
 
 	// save password so we can use to authenticate
 	password := person.Password
@@ -77,16 +219,20 @@ func CreatePerson(c echo.Context) error {
 
 // LoginPerson generates a jwt for subsequent interaction with the server
 func LoginPerson(c echo.Context) error {
-	person := Person{}
+	body := struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}{}
 
-	// bind req body to person
-	err := c.Bind(&person)
+	// bind req body to body
+	err := c.Bind(&body)
 	if err != nil {
 		return c.JSON(400, server.Error(err, 400))
 	}
 
-	// authenticate person
-	err = person.Authenticate(person.Password)
+	// authenticate person by email
+	person := Person{Email: body.Email}
+	err = person.Authenticate(body.Password)
 	if err != nil {
 		return c.JSON(401, server.Error(err, 401))
 	}
@@ -98,68 +244,2411 @@ func LoginPerson(c echo.Context) error {
 	return c.JSON(200, person)
 }
 
-// CreateClass creates a class
-func CreateClass(c echo.Context) error {
-	class := Class{}
-	person := Person{}
+// RefreshPerson exchanges a refresh token for a new access
+// token and refresh token
+func RefreshPerson(c echo.Context) error {
+	body := struct {
+		RefreshToken string `json:"refresh_token"`
+	}{}
 
-	// bind req body to class
-	err := c.Bind(&class)
+	// bind req body to body
+	err := c.Bind(&body)
 	if err != nil {
 		return c.JSON(400, server.Error(err, 400))
 	}
+	if body.RefreshToken == "" {
+		return c.JSON(400, server.Error("Refresh token is required", 400))
+	}
+
+	// renew login
+	person, err := RefreshLogin(body.RefreshToken, time.Now())
+	if err == ErrInvalidRefreshToken || err == ErrDeactivated {
+		return c.JSON(401, server.Error(err, 401))
+	}
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
 
-	// get person from jwt
-	payload := (c.Get("user").(*jwt.Token)).Claims.(jwt.MapClaims)
-	person.ID = bson.ObjectIdHex(payload["id"].(string))
+	// set Password to ""
+	person.Password = ""
 
-	// find person in db
-	err = person.Find()
+	// return person
+	return c.JSON(200, person)
+}
+
+// LogoutPerson revokes the login session of the current token
+func LogoutPerson(c echo.Context) error {
+	login := currentLogin(c)
+
+	// revoke login
+	now := time.Now()
+	login.RevokedAt = &now
+	err := login.Revoke()
+	if err != nil && err != ErrNotFound {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	return c.JSON(200, server.Success())
+}
+
+// LogoutAllSessions revokes every login session of the current person
+func LogoutAllSessions(c echo.Context) error {
+	person := currentPerson(c)
+
+	// revoke logins
+	revoked, err := RevokeLogins(person.ID, time.Now())
 	if err != nil {
-		return c.JSON(401, server.Error(err, 401))
+		return c.JSON(500, server.Error(err, 500))
 	}
 
-	// ensure person has role of admin
-	if person.Role != "admin" {
-		return c.JSON(401, server.Error("Only admins can create a class", 401))
+	return c.JSON(200, map[string]int{"revoked": revoked})
+}
+
+// RequestPasswordReset mails a password reset token to the person
+// with an email, responding the same whether or not they exist
+func RequestPasswordReset(c echo.Context) error {
+	body := struct {
+		Email string `json:"email"`
+	}{}
+
+	// bind req body to body
+	err := c.Bind(&body)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+	if body.Email == "" {
+		return c.JSON(400, server.Error("Email is required", 400))
 	}
 
-	// create class
-	err = class.Create()
+	// find person and mail token in the background so
+	// response time does not reveal if they exist
+	base := s.PublicURL
+	go func() {
+		person := Person{Email: body.Email}
+		err := person.Find()
+		if err != nil {
+			return
+		}
+		err = person.RequestReset(base, time.Now())
+		if err != nil {
+			log.Println("Unable to send password reset:", err.Error())
+		}
+	}()
+
+	return c.JSON(200, server.Success())
+}
+
+// ConfirmPasswordReset sets a new password with a reset token
+func ConfirmPasswordReset(c echo.Context) error {
+	body := struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}{}
+
+	// bind req body to body
+	err := c.Bind(&body)
 	if err != nil {
 		return c.JSON(400, server.Error(err, 400))
 	}
 
-	// return OK
+	// validate body
+	errs := server.ValidationErrors{}
+	if body.Token == "" {
+		errs.Add("token", CodeRequired, "Token is required")
+	}
+	validatePassword(&errs, "password", body.Password)
+	err = errs.Err()
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// set password
+	_, err = ConfirmReset(body.Token, body.Password, time.Now())
+	if err == ErrInvalidResetToken {
+		return c.JSON(400, server.Error(err, 400))
+	}
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
 	return c.JSON(200, server.Success())
 }
 
-// GetClassList returns list of classes for current person
-func GetClassList(c echo.Context) error {
-	classes := []Class{}
+// AcceptInvitation sets the password of an invited person
+// and logs them in
+func AcceptInvitation(c echo.Context) error {
 	person := Person{}
+	body := struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}{}
+
+	// bind req body to body
+	err := c.Bind(&body)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// validate body
+	errs := server.ValidationErrors{}
+	if body.Token == "" {
+		errs.Add("token", CodeRequired, "Token is required")
+	}
+	validatePassword(&errs, "password", body.Password)
+	err = errs.Err()
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// find person by invitation token
+	err = person.FindByInviteToken(body.Token, time.Now())
+	if err != nil {
+		return c.JSON(401, server.Error("Invalid or expired invitation token", 401))
+	}
+
+	// set password
+	err = person.AcceptInvite(body.Password)
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// authenticate person
+	err = person.Authenticate(body.Password)
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// set Password to ""
+	person.Password = ""
+
+	// return person
+	return c.JSON(200, person)
+}
 
-	// get person from jwt
-	payload := (c.Get("user").(*jwt.Token)).Claims.(jwt.MapClaims)
-	person.ID = bson.ObjectIdHex(payload["id"].(string))
+// ImportPersons imports a csv roster of students uploaded as the file
+// field of a multipart form, only validating it when ?dry_run=true
+func ImportPersons(c echo.Context) error {
+
+	// open uploaded file
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+	src, err := file.Open()
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+	defer src.Close()
+
+	// import roster
+	report, err := ImportRoster(src, c.QueryParam("dry_run") == "true")
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// return report
+	return c.JSON(200, report)
+}
+
+// UpdatePersonRole changes the role of a person
+func UpdatePersonRole(c echo.Context) error {
+	target := Person{}
+	body := struct {
+		Role string `json:"role"`
+	}{}
+
+	// bind req body to body
+	err := c.Bind(&body)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+	if !ValidRole(body.Role) {
+		return c.JSON(400, server.Error("Role must be student, teacher or admin", 400))
+	}
+
+	// get person id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid person id", 400))
+	}
+	target.ID = bson.ObjectIdHex(c.Param("id"))
 
 	// find person in db
-	err := person.Find()
+	err = target.Find()
 	if err != nil {
-		return c.JSON(401, server.Error(err, 401))
+		return c.JSON(404, server.Error(err, 404))
 	}
 
-	// loop through class id for person and find class in db
-	// then append to classes
-	for _, id := range person.Classes {
-		class := Class{ID: id}
-		err = class.Find()
+	// ensure there is always an admin left
+	if body.Role != RoleAdmin {
+		last, err := target.LastAdmin()
 		if err != nil {
-			return c.JSON(400, server.Error(err, 400))
+			return c.JSON(500, server.Error(err, 500))
+		}
+		if last {
+			return c.JSON(409, server.Error("Can not remove the last admin", 409))
+		}
+	}
+
+	// save role
+	target.Role = body.Role
+	err = target.UpdateRole()
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return person
+	target.Password = ""
+	return c.JSON(200, target)
+}
+
+// GetPersons returns a page of all persons for administrators,
+// searched by name or email and filtered by role and deactivation
+func GetPersons(c echo.Context) error {
+
+	// parse filters and paging
+	filter, err := parsePersonListFilter(c)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+	opts, err := parseListOptions(c, []string{"last_name", "first_name", "email"}, "last_name")
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// read page of persons from db
+	total, err := CountPersonsBy(filter)
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+	persons, err := FindPersonsBy(filter, opts.peek())
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+	key := personSorts[strings.TrimPrefix(opts.sort, "-")]
+	n, pagination := opts.paginate(total, len(persons), func(i int) (string, bson.ObjectId) {
+		return key(&persons[i]), persons[i].ID
+	})
+
+	// return page of persons
+	persons = persons[:n]
+	for i := range persons {
+		persons[i].Password = ""
+	}
+	return c.JSON(200, Page{Data: persons, Pagination: pagination})
+}
+
+// GetMe returns the profile of the current person
+func GetMe(c echo.Context) error {
+	person := *currentPerson(c)
+
+	// set Password to ""
+	person.Password = ""
+
+	return c.JSON(200, person)
+}
+
+// UpdateMe changes the names, email or password of the current
+// person. Changing the email or password requires the current
+// password, and changing the password ends all other logins.
+func UpdateMe(c echo.Context) error {
+	person := currentPerson(c)
+	body := struct {
+		FirstName       *string `json:"first_name"`
+		LastName        *string `json:"last_name"`
+		Email           *string `json:"email"`
+		CurrentPassword string  `json:"current_password"`
+		NewPassword     string  `json:"new_password"`
+	}{}
+
+	// bind req body to body
+	err := c.Bind(&body)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// apply changes
+	if body.FirstName != nil {
+		person.FirstName = *body.FirstName
+	}
+	if body.LastName != nil {
+		person.LastName = *body.LastName
+	}
+	emailChanged := false
+	if body.Email != nil {
+		email := normalizeEmail(*body.Email)
+		emailChanged = email != person.Email
+		person.Email = email
+	}
+
+	// validate changes
+	errs := server.ValidationErrors{}
+	err = person.validateProfile(&errs)
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+	if body.NewPassword != "" {
+		validatePassword(&errs, "new_password", body.NewPassword)
+	}
+
+	// ensure current password is given for sensitive changes
+	if emailChanged || body.NewPassword != "" {
+		switch {
+		case body.CurrentPassword == "":
+			errs.Add("current_password", CodeRequired, "Current password is required to change email or password")
+		case !person.CheckPassword(body.CurrentPassword):
+			errs.Add("current_password", CodeInvalid, "Current password is incorrect")
+		}
+	}
+	if err := errs.Err(); err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// save profile, failing if the email was
+	// registered since validation
+	err = person.UpdateProfile()
+	if err == ErrDuplicate {
+		err = server.ValidationErrors{{Field: "email", Code: CodeTaken, Message: "Email is already registered"}}
+		return c.JSON(400, server.Error(err, 400))
+	}
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// save password and issue new tokens
+	if body.NewPassword != "" {
+		err = person.ChangePassword(body.NewPassword, time.Now())
+		if err != nil {
+			return c.JSON(500, server.Error(err, 500))
+		}
+	}
+
+	// return person
+	updated := *person
+	updated.Password = ""
+	return c.JSON(200, updated)
+}
+
+// UpdatePerson changes the names, email or role of a person
+func UpdatePerson(c echo.Context) error {
+	target := Person{}
+	body := struct {
+		FirstName *string `json:"first_name"`
+		LastName  *string `json:"last_name"`
+		Email     *string `json:"email"`
+		Role      *string `json:"role"`
+	}{}
+
+	// bind req body to body
+	err := c.Bind(&body)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// get person id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid person id", 400))
+	}
+	target.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// find person in db
+	err = target.Find()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+
+	// apply changes
+	if body.FirstName != nil {
+		target.FirstName = *body.FirstName
+	}
+	if body.LastName != nil {
+		target.LastName = *body.LastName
+	}
+	if body.Email != nil {
+		target.Email = normalizeEmail(*body.Email)
+	}
+
+	// validate changes
+	errs := server.ValidationErrors{}
+	err = target.validateProfile(&errs)
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+	if body.Role != nil && !ValidRole(*body.Role) {
+		errs.Add("role", CodeInvalid, "Role must be student, teacher or admin")
+	}
+	if err := errs.Err(); err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// ensure there is always an admin left
+	if body.Role != nil && *body.Role != RoleAdmin {
+		last, err := target.LastAdmin()
+		if err != nil {
+			return c.JSON(500, server.Error(err, 500))
 		}
-		classes = append(classes, class)
+		if last {
+			return c.JSON(409, server.Error("Can not remove the last admin", 409))
+		}
+	}
+
+	// save profile, failing if the email was
+	// registered since validation
+	err = target.UpdateProfile()
+	if err == ErrDuplicate {
+		err = server.ValidationErrors{{Field: "email", Code: CodeTaken, Message: "Email is already registered"}}
+		return c.JSON(400, server.Error(err, 400))
+	}
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
 	}
 
-	// return classes
-	return c.JSON(200, classes)
+	// save role
+	if body.Role != nil && *body.Role != target.Role {
+		target.Role = *body.Role
+		err = target.UpdateRole()
+		if err != nil {
+			return c.JSON(500, server.Error(err, 500))
+		}
+	}
+
+	// return person
+	target.Password = ""
+	return c.JSON(200, target)
+}
+
+// DeactivatePerson returns a handler that deactivates a person,
+// ending their logins, or reactivates them
+func DeactivatePerson(deactivate bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		target := Person{}
+
+		// get person id from url
+		if !bson.IsObjectIdHex(c.Param("id")) {
+			return c.JSON(400, server.Error("Invalid person id", 400))
+		}
+		target.ID = bson.ObjectIdHex(c.Param("id"))
+
+		// find person in db
+		err := target.Find()
+		if err != nil {
+			return c.JSON(404, server.Error(err, 404))
+		}
+
+		// reactivate person
+		if !deactivate {
+			err = target.Reactivate()
+			if err != nil {
+				return c.JSON(500, server.Error(err, 500))
+			}
+			target.Password = ""
+			return c.JSON(200, target)
+		}
+
+		// ensure there is always an admin left
+		last, err := target.LastAdmin()
+		if err != nil {
+			return c.JSON(500, server.Error(err, 500))
+		}
+		if last {
+			return c.JSON(409, server.Error("Can not deactivate the last admin", 409))
+		}
+
+		// deactivate person, keeping the
+		// original time if already deactivated
+		if !target.Deactivated() {
+			err = target.Deactivate(time.Now())
+			if err != nil {
+				return c.JSON(500, server.Error(err, 500))
+			}
+		}
+
+		// return person
+		target.Password = ""
+		return c.JSON(200, target)
+	}
+}
+
+// RotateKeys generates a new jwt signing key, keeping older
+// keys to verify tokens issued before the rotation
+func RotateKeys(c echo.Context) error {
+
+	// generate and save new key
+	key, err := s.Keys.Rotate()
+	if err == server.ErrKeysPinned {
+		return c.JSON(409, server.Error(err, 409))
+	}
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return new key id
+	return c.JSON(200, map[string]string{"kid": key.ID, "alg": key.Algorithm})
+}
+
+// CreateClass creates a class
+func CreateClass(c echo.Context) error {
+	class := Class{}
+
+	// bind req body to class
+	err := c.Bind(&class)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// validate class
+	err = class.Validate()
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// create class without students so both sides of
+	// their enrollment are written together
+	students := []string{}
+	for _, id := range class.Students {
+		students = append(students, id.Hex())
+	}
+	class.Students = []bson.ObjectId{}
+	err = class.Create()
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// enroll students
+	for _, result := range class.EnrollAll(students, true) {
+		if result.Status == EnrollmentFailed {
+			log.Println("Unable to enroll", result.Student, "in", class.ID.Hex()+":", result.Error)
+		}
+	}
+
+	// find class with its enrolled students
+	err = class.Find()
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return class
+	c.Response().Header().Set("ETag", class.ETag())
+	return c.JSON(200, class)
+}
+
+// GetClassList returns a page of the classes of the current person,
+// searched with ?q= and filtered by whether they are active or archived
+// and by instructor. Classes are of the term active now unless another term
+// or, with ?term_id=all, every term is asked for. Enrollments in
+// classes that no longer exist are listed as missing.
+func GetClassList(c echo.Context) error {
+
+	// parse filters
+	filter, err := parseClassListFilter(c)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// default to the active term if there is one
+	if c.QueryParam("term_id") == "" {
+		term, err := FindActiveTerm(time.Now())
+		if err != nil && err != ErrNoActiveTerm {
+			return c.JSON(500, server.Error(err, 500))
+		}
+		if term != nil {
+			filter.Term = term.ID
+			return classPage(c, filter, term)
+		}
+	}
+
+	return classPage(c, filter, nil)
+}
+
+// GetTermClassList returns a page of the classes of the current person
+// in a term, or in the term active now if the term id is current
+func GetTermClassList(c echo.Context) error {
+	term := &Term{}
+
+	// find term by id or the active term
+	if c.Param("id") == "current" {
+		active, err := FindActiveTerm(time.Now())
+		if err == ErrNoActiveTerm {
+			return c.JSON(404, server.Error(err, 404))
+		}
+		if err != nil {
+			return c.JSON(500, server.Error(err, 500))
+		}
+		term = active
+	} else {
+		if !bson.IsObjectIdHex(c.Param("id")) {
+			return c.JSON(400, server.Error("Invalid term id", 400))
+		}
+		term.ID = bson.ObjectIdHex(c.Param("id"))
+		err := term.Find()
+		if err != nil {
+			return c.JSON(404, server.Error(err, 404))
+		}
+	}
+
+	// parse filters
+	filter, err := parseClassListFilter(c)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+	filter.Term = term.ID
+
+	return classPage(c, filter, term)
+}
+
+// classPage responds with a page of the classes of the current
+// person matching filter, scoped to term if it is not nil
+func classPage(c echo.Context, filter classListFilter, term *Term) error {
+	person := currentPerson(c)
+
+	// flag classes that no longer exist instead of failing
+	missing, err := FindMissingClasses(person.Classes)
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	filter.IDs = append([]bson.ObjectId{}, person.Classes...)
+	return respondClassPage(c, filter, Page{Missing: missing, Term: term})
+}
+
+// respondClassPage responds with page holding the page of classes
+// matching filter selected by the paging query parameters
+func respondClassPage(c echo.Context, filter classListFilter, page Page) error {
+
+	// parse paging
+	opts, err := parseListOptions(c, []string{"title", "start_date", "end_date"}, "title")
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+	key := classSorts[strings.TrimPrefix(opts.sort, "-")]
+
+	// page through active or inactive classes here, as
+	// stores can not tell when classes are active
+	if filter.active != nil {
+		classes, err := FindClassesBy(filter.ClassFilter, listOptions{sort: opts.sort, desc: opts.desc})
+		if err != nil {
+			return c.JSON(500, server.Error(err, 500))
+		}
+		now := time.Now()
+		matched := []Class{}
+		keys := []string{}
+		ids := []bson.ObjectId{}
+		for i := range classes {
+			if classes[i].Active(now) != *filter.active {
+				continue
+			}
+			matched = append(matched, classes[i])
+			keys = append(keys, key(&classes[i]))
+			ids = append(ids, classes[i].ID)
+		}
+		indexes, pagination := opts.page(keys, ids)
+		data := make([]Class, 0, len(indexes))
+		for _, i := range indexes {
+			data = append(data, matched[i])
+		}
+		page.Data, page.Pagination = data, pagination
+		return c.JSON(200, page)
+	}
+
+	// read page of classes from db
+	total, err := CountClassesBy(filter.ClassFilter)
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+	classes, err := FindClassesBy(filter.ClassFilter, opts.peek())
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+	n, pagination := opts.paginate(total, len(classes), func(i int) (string, bson.ObjectId) {
+		return key(&classes[i]), classes[i].ID
+	})
+	page.Data, page.Pagination = classes[:n], pagination
+	return c.JSON(200, page)
+}
+
+// GetClasses returns a page of all classes for administrators,
+// filtered like the class list of a person and additionally by
+// term and location
+func GetClasses(c echo.Context) error {
+
+	// parse filters
+	filter, err := parseClassListFilter(c)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	return respondClassPage(c, filter, Page{})
+}
+
+// GetClass returns a class to its members along with its
+// version as ETag for conditional updates
+func GetClass(c echo.Context) error {
+	class := Class{}
+
+	// get class id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid class id", 400))
+	}
+	class.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// get person loaded from jwt
+	person := currentPerson(c)
+
+	// find class in db
+	err := class.Find()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+
+	// ensure person is part of class
+	if person.Role != RoleAdmin && class.Instructor != person.ID && !class.HasStudent(person.ID) {
+		return c.JSON(403, server.Error("Only members of a class can view it", 403))
+	}
+
+	// return class
+	c.Response().Header().Set("ETag", class.ETag())
+	return c.JSON(200, class)
+}
+
+// UpdateClass changes the fields of a class given in the request body.
+// The version the change is based on must be given by an If-Match header
+// or the version field, failing if the class was changed since.
+func UpdateClass(c echo.Context) error {
+	class := Class{}
+	patch := classPatch{}
+
+	// bind req body to patch
+	err := c.Bind(&patch)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// get class id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid class id", 400))
+	}
+	class.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// get version change is based on
+	var version int
+	switch {
+	case c.Request().Header.Get("If-Match") != "":
+		version, err = parseIfMatch(c.Request().Header.Get("If-Match"))
+		if err != nil {
+			return c.JSON(400, server.Error(err, 400))
+		}
+	case patch.Version != nil:
+		version = *patch.Version
+	default:
+		return c.JSON(428, server.Error("If-Match header or version is required", 428))
+	}
+
+	// find class in db
+	err = class.Find()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+	if class.Version != version {
+		return c.JSON(412, server.Error(ErrVersionMismatch, 412))
+	}
+
+	// apply and validate changes
+	patch.apply(&class)
+	err = class.Validate()
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// update class
+	err = class.Update(version)
+	if err == ErrVersionMismatch {
+		return c.JSON(412, server.Error(err, 412))
+	}
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+	publishEvent(EventClassUpdated, class.ID, class)
+
+	// return class
+	c.Response().Header().Set("ETag", class.ETag())
+	return c.JSON(200, class)
+}
+
+// ArchiveClass returns a handler archiving a class if archive is true
+// and restoring it otherwise. Archived classes keep their attendance but
+// are hidden from listings and closed for check in.
+func ArchiveClass(archive bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		class := Class{}
+
+		// get class id from url
+		if !bson.IsObjectIdHex(c.Param("id")) {
+			return c.JSON(400, server.Error("Invalid class id", 400))
+		}
+		class.ID = bson.ObjectIdHex(c.Param("id"))
+
+		// find class in db
+		err := class.Find()
+		if err != nil {
+			return c.JSON(404, server.Error(err, 404))
+		}
+
+		// archive or restore class if it is not already
+		if class.Archived() != archive {
+			class.ArchivedAt = nil
+			if archive {
+				now := time.Now()
+				class.ArchivedAt = &now
+			}
+			err = class.Update(class.Version)
+			if err == ErrVersionMismatch {
+				return c.JSON(409, server.Error(err, 409))
+			}
+			if err != nil {
+				return c.JSON(500, server.Error(err, 500))
+			}
+			publishEvent(EventClassUpdated, class.ID, class)
+		}
+
+		// return class
+		c.Response().Header().Set("ETag", class.ETag())
+		return c.JSON(200, class)
+	}
+}
+
+// GetCurrentClass returns the class session the current person is in
+// now and the next upcoming session. Instructors also get the attendance
+// counts of the session they are teaching.
+func GetCurrentClass(c echo.Context) error {
+
+	// get person loaded from jwt
+	person := currentPerson(c)
+
+	// find current and next class sessions
+	current, err := FindCurrentClass(person, time.Now())
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return current class
+	return c.JSON(200, current)
+}
+
+// CheckIn checks the current person in to the current session of a class
+// using the code currently displayed by the instructor
+func CheckIn(c echo.Context) error {
+	class := Class{}
+	body := struct {
+		Code string `json:"code"`
+	}{}
+
+	// bind req body to body
+	err := c.Bind(&body)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// get class id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid class id", 400))
+	}
+	class.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// get person loaded from jwt
+	person := currentPerson(c)
+
+	// find class in db
+	err = class.Find()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+
+	// ensure code is valid for current or previous window
+	now := time.Now()
+	if !class.VerifyCode(body.Code, now) {
+		res := checkInErrors[ErrInvalidCode]
+		return c.JSON(res.status, server.ErrorCode(res.code, ErrInvalidCode, res.status))
+	}
+
+	// check in to current session
+	record, err := class.CheckIn(person, s.ClientIP(c.Request()), now)
+	if err != nil {
+		res, ok := checkInErrors[err]
+		if !ok {
+			return c.JSON(500, server.Error(err, 500))
+		}
+		return c.JSON(res.status, server.ErrorCode(res.code, err, res.status))
+	}
+
+	// return attendance record
+	return c.JSON(200, record)
+}
+
+// GetLocationList returns all locations
+func GetLocationList(c echo.Context) error {
+
+	// find locations in db
+	locations, err := FindLocations()
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return locations
+	return c.JSON(200, locations)
+}
+
+// CreateLocation creates a location
+func CreateLocation(c echo.Context) error {
+	location := Location{}
+
+	// bind req body to location
+	err := c.Bind(&location)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// validate networks
+	err = location.Normalize()
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// create location
+	err = location.Create()
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// return location
+	return c.JSON(200, location)
+}
+
+// UpdateLocation replaces the name, building and networks of a location
+func UpdateLocation(c echo.Context) error {
+	location := Location{}
+
+	// bind req body to location
+	err := c.Bind(&location)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// get location id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid location id", 400))
+	}
+	location.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// validate networks
+	err = location.Normalize()
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// update location
+	err = location.Update()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+
+	// return location
+	return c.JSON(200, location)
+}
+
+// GetTermList returns all terms, latest to start first
+func GetTermList(c echo.Context) error {
+
+	// find terms in db
+	terms, err := FindTerms()
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return terms
+	return c.JSON(200, terms)
+}
+
+// GetTerm returns a term
+func GetTerm(c echo.Context) error {
+	term := Term{}
+
+	// get term id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid term id", 400))
+	}
+	term.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// find term in db
+	err := term.Find()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+
+	// return term
+	return c.JSON(200, term)
+}
+
+// CreateTerm creates a term
+func CreateTerm(c echo.Context) error {
+	term := Term{}
+
+	// bind req body to term
+	err := c.Bind(&term)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// validate term
+	err = term.Validate()
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// create term
+	err = term.Create()
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return term
+	return c.JSON(200, term)
+}
+
+// UpdateTerm replaces the name, dates and breaks of a term
+func UpdateTerm(c echo.Context) error {
+	term := Term{}
+
+	// bind req body to term
+	err := c.Bind(&term)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// get term id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid term id", 400))
+	}
+	term.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// validate term
+	err = term.Validate()
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// update term
+	err = term.Update()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+
+	// return term
+	return c.JSON(200, term)
+}
+
+// DeleteTerm deletes a term no classes belong to
+func DeleteTerm(c echo.Context) error {
+	term := Term{}
+
+	// get term id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid term id", 400))
+	}
+	term.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// ensure no classes belong to term
+	n, err := term.CountClasses()
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+	if n > 0 {
+		return c.JSON(409, server.Error("Term still has classes", 409))
+	}
+
+	// delete term
+	err = term.Delete()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+
+	// return OK
+	return c.JSON(200, server.Success())
+}
+
+// GetWebhookList returns all webhooks without their secrets
+func GetWebhookList(c echo.Context) error {
+
+	// find webhooks in db
+	webhooks, err := FindWebhooks()
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return webhooks
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return c.JSON(200, webhooks)
+}
+
+// CreateWebhook subscribes a url to event types, generating a secret
+// if none is given. The secret is only returned on creation.
+func CreateWebhook(c echo.Context) error {
+	webhook := Webhook{}
+
+	// bind req body to webhook
+	err := c.Bind(&webhook)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// generate secret
+	if webhook.Secret == "" {
+		webhook.Secret, err = server.RandomToken(32)
+		if err != nil {
+			return c.JSON(500, server.Error(err, 500))
+		}
+	}
+
+	// validate webhook
+	err = webhook.Validate()
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// create webhook
+	err = webhook.Create(time.Now())
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return webhook
+	return c.JSON(200, webhook)
+}
+
+// GetWebhook returns a webhook without its secret
+func GetWebhook(c echo.Context) error {
+	webhook := Webhook{}
+
+	// get webhook id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid webhook id", 400))
+	}
+	webhook.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// find webhook in db
+	err := webhook.Find()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+
+	// return webhook
+	webhook.Secret = ""
+	return c.JSON(200, webhook)
+}
+
+// UpdateWebhook replaces the url and event types of a webhook,
+// and its secret if one is given
+func UpdateWebhook(c echo.Context) error {
+	webhook := Webhook{}
+	body := Webhook{}
+
+	// bind req body to body
+	err := c.Bind(&body)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// get webhook id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid webhook id", 400))
+	}
+	webhook.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// find webhook in db
+	err = webhook.Find()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+
+	// apply and validate changes
+	webhook.URL = body.URL
+	webhook.Events = body.Events
+	if body.Secret != "" {
+		webhook.Secret = body.Secret
+	}
+	err = webhook.Validate()
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// update webhook
+	err = webhook.Update()
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return webhook
+	webhook.Secret = ""
+	return c.JSON(200, webhook)
+}
+
+// DeleteWebhook removes a webhook and its delivery log
+func DeleteWebhook(c echo.Context) error {
+	webhook := Webhook{}
+
+	// get webhook id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid webhook id", 400))
+	}
+	webhook.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// delete webhook
+	err := webhook.Delete()
+	if err == ErrNotFound {
+		return c.JSON(404, server.Error(err, 404))
+	}
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return OK
+	return c.JSON(200, server.Success())
+}
+
+// GetWebhookDeliveries returns a page of the delivery log of a
+// webhook, newest first unless sorted by created_at
+func GetWebhookDeliveries(c echo.Context) error {
+	webhook := Webhook{}
+
+	// get webhook id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid webhook id", 400))
+	}
+	webhook.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// parse paging
+	opts, err := parseListOptions(c, []string{"created_at"}, "-created_at")
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// find webhook and its deliveries in db
+	err = webhook.Find()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+	deliveries, err := FindDeliveries(webhook.ID)
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return page of deliveries
+	keys := make([]string, len(deliveries))
+	ids := make([]bson.ObjectId, len(deliveries))
+	for i := range deliveries {
+		keys[i] = sortTime(deliveries[i].CreatedAt)
+		ids[i] = deliveries[i].ID
+	}
+	indexes, pagination := opts.page(keys, ids)
+	data := make([]Delivery, 0, len(indexes))
+	for _, i := range indexes {
+		data = append(data, deliveries[i])
+	}
+	return c.JSON(200, Page{Data: data, Pagination: pagination})
+}
+
+// RedeliverWebhook delivers the event of a delivery to its webhook
+// again as a new delivery, which is returned
+func RedeliverWebhook(c echo.Context) error {
+	delivery := Delivery{}
+
+	// get webhook and delivery ids from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid webhook id", 400))
+	}
+	if !bson.IsObjectIdHex(c.Param("delivery")) {
+		return c.JSON(400, server.Error("Invalid delivery id", 400))
+	}
+	delivery.ID = bson.ObjectIdHex(c.Param("delivery"))
+
+	// find delivery of webhook in db
+	err := delivery.Find()
+	if err == nil && delivery.Webhook != bson.ObjectIdHex(c.Param("id")) {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+
+	// schedule redelivery
+	redelivery, err := dispatcher.Redeliver(&delivery, time.Now())
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return new delivery
+	return c.JSON(200, redelivery)
+}
+
+// GetClassCode returns the current check in code of a class
+// for the instructor to display
+func GetClassCode(c echo.Context) error {
+	class := Class{}
+
+	// get class id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid class id", 400))
+	}
+	class.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// get person loaded from jwt
+	person := currentPerson(c)
+
+	// find class in db
+	err := class.Find()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+
+	// ensure person is instructor of class
+	if class.Instructor != person.ID {
+		return c.JSON(403, server.Error("Only the instructor can view the class code", 403))
+	}
+
+	// generate secret for classes created without one
+	if class.CodeSecret == "" {
+		err = class.GenerateCodeSecret()
+		if err != nil {
+			return c.JSON(500, server.Error(err, 500))
+		}
+		err = class.UpdateCode()
+		if err != nil {
+			return c.JSON(500, server.Error(err, 500))
+		}
+	}
+
+	// generate code for current window
+	code, expires, err := class.Code(time.Now())
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return code
+	return c.JSON(200, map[string]interface{}{
+		"code":       code,
+		"period":     class.codePeriod(),
+		"expires_at": expires,
+	})
+}
+
+// UpdateClassCode sets the code period of a class and rotates its secret
+func UpdateClassCode(c echo.Context) error {
+	class := Class{}
+	body := struct {
+		CodePeriod int `json:"code_period"`
+	}{}
+
+	// bind req body to body
+	err := c.Bind(&body)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// get class id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid class id", 400))
+	}
+	class.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// get person loaded from jwt
+	person := currentPerson(c)
+
+	// find class in db
+	err = class.Find()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+
+	// ensure person is instructor of class
+	if class.Instructor != person.ID {
+		return c.JSON(403, server.Error("Only the instructor can change the class code", 403))
+	}
+
+	// validate period and rotate secret
+	class.CodePeriod = body.CodePeriod
+	err = class.ValidateCodePeriod()
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+	err = class.GenerateCodeSecret()
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// save code settings
+	err = class.UpdateCode()
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return OK
+	return c.JSON(200, server.Success())
+}
+
+// GetClassQR returns a png or svg qr code allowing check in to the
+// current session of a class for the instructor to display
+func GetClassQR(c echo.Context) error {
+	class := Class{}
+
+	// get class id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid class id", 400))
+	}
+	class.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// get person loaded from jwt
+	person := currentPerson(c)
+
+	// find class in db
+	err := class.Find()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+
+	// ensure person is instructor of class
+	if class.Instructor != person.ID {
+		return c.JSON(403, server.Error("Only the instructor can view the class QR code", 403))
+	}
+
+	// find session for current time slot
+	session, err := class.CurrentSession(time.Now())
+	if err != nil {
+		res, ok := checkInErrors[err]
+		if !ok {
+			return c.JSON(500, server.Error(err, 500))
+		}
+		return c.JSON(res.status, server.ErrorCode(res.code, err, res.status))
+	}
+
+	// sign check in token, which expires with the check in code
+	content, expires, err := class.CheckInToken(session, time.Now())
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	c.Response().Header().Set("Expires", expires.UTC().Format(http.TimeFormat))
+
+	// render qr code in requested format
+	if c.QueryParam("format") == "svg" {
+		svg, err := QRCodeSVG(content)
+		if err != nil {
+			return c.JSON(500, server.Error(err, 500))
+		}
+		return c.Blob(200, "image/svg+xml", svg)
+	}
+	png, err := QRCodePNG(content)
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+	return c.Blob(200, "image/png", png)
+}
+
+// CheckInQR checks the current person in to a class session
+// using the payload scanned from the session qr code
+func CheckInQR(c echo.Context) error {
+	class := Class{}
+	body := struct {
+		Payload string `json:"payload"`
+	}{}
+
+	// bind req body to body
+	err := c.Bind(&body)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// verify payload
+	classID, sessionID, code, err := ParseCheckInPayload(body.Payload)
+	if err != nil {
+		res, ok := checkInErrors[err]
+		if !ok {
+			return c.JSON(400, server.Error(err, 400))
+		}
+		return c.JSON(res.status, server.ErrorCode(res.code, err, res.status))
+	}
+
+	// get person loaded from jwt
+	person := currentPerson(c)
+
+	// find class in db
+	class.ID = classID
+	err = class.Find()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+
+	// ensure qr code is for the current session and code
+	now := time.Now()
+	session, err := class.CurrentSession(now)
+	if err != nil || session.ID != sessionID || !class.VerifyCode(code, now) {
+		res := checkInErrors[ErrInvalidQR]
+		return c.JSON(res.status, server.ErrorCode(res.code, ErrInvalidQR, res.status))
+	}
+
+	// check in to current session
+	record, err := class.CheckIn(person, s.ClientIP(c.Request()), now)
+	if err != nil {
+		res, ok := checkInErrors[err]
+		if !ok {
+			return c.JSON(500, server.Error(err, 500))
+		}
+		return c.JSON(res.status, server.ErrorCode(res.code, err, res.status))
+	}
+
+	// return attendance record
+	return c.JSON(200, record)
+}
+
+// GetClassSessions returns the sessions of a class between the
+// from and to dates, defaulting to the whole class
+func GetClassSessions(c echo.Context) error {
+	class := Class{}
+
+	// get class id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid class id", 400))
+	}
+	class.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// get person loaded from jwt
+	person := currentPerson(c)
+
+	// find class in db
+	err := class.Find()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+
+	// ensure person is part of class
+	if person.Role != RoleAdmin && class.Instructor != person.ID && !class.HasStudent(person.ID) {
+		return c.JSON(403, server.Error("Only members of a class can view its sessions", 403))
+	}
+
+	// parse date range
+	from, to, err := dateRange(c)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+	if from.IsZero() {
+		from = class.StartDate
+	}
+	if to.IsZero() {
+		to = class.EndDate.AddDate(0, 0, 1)
+	}
+
+	// expand schedule into sessions
+	sessions, err := class.Sessions(from, to)
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return sessions
+	return c.JSON(200, sessions)
+}
+
+// StreamSessionAttendance streams the attendance of a class session to
+// its instructor as server sent events. The stream starts with a
+// snapshot of the roster followed by each check in and status change.
+// Clients dropped for falling behind reconnect for a new snapshot.
+func StreamSessionAttendance(c echo.Context) error {
+	class := Class{}
+
+	// get class id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid class id", 400))
+	}
+	class.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// get person loaded from jwt
+	person := currentPerson(c)
+
+	// find class in db
+	err := class.Find()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+
+	// ensure person is the instructor
+	if person.Role != RoleAdmin && class.Instructor != person.ID {
+		return c.JSON(403, server.Error("Only the instructor of a class can stream its attendance", 403))
+	}
+
+	// find session
+	session, err := class.FindSession(c.Param("session"), time.Now())
+	if err == ErrNoSession || err == ErrUnknownSession {
+		return c.JSON(404, server.Error(err, 404))
+	}
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// subscribe before taking the snapshot so no event is missed
+	sub := s.Events.Subscribe(classTopic(class.ID), streamBuffer)
+	defer sub.Close()
+	snapshot, err := class.Roster(session)
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// start stream with snapshot
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(200)
+	err = writeStreamEvent(res, server.Event{Type: "snapshot", Data: snapshot})
+	if err != nil {
+		return nil
+	}
+
+	// forward events of session until client leaves or falls behind
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-heartbeat.C:
+			_, err = res.Write([]byte(": heartbeat\n\n"))
+			res.Flush()
+		case event, ok := <-sub.C:
+			if !ok {
+				return nil
+			}
+			record, isRecord := event.Data.(Attendance)
+			if !isRecord || record.Session != session.ID {
+				continue
+			}
+			err = writeStreamEvent(res, event)
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+// writeStreamEvent writes event as a server sent event and flushes it
+func writeStreamEvent(res *echo.Response, event server.Event) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	if event.ID != "" {
+		fmt.Fprintf(res, "id: %s\n", event.ID)
+	}
+	_, err = fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event.Type, data)
+	res.Flush()
+	return err
+}
+
+// UpdateClassSchedule replaces the timezone and schedule of a class,
+// including its exceptions and rescheduled sessions
+func UpdateClassSchedule(c echo.Context) error {
+	class := Class{}
+	body := struct {
+		Timezone string    `json:"timezone"`
+		Schedule *Schedule `json:"schedule"`
+	}{}
+
+	// bind req body to body
+	err := c.Bind(&body)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// get class id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid class id", 400))
+	}
+	class.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// get person loaded from jwt
+	person := currentPerson(c)
+
+	// find class in db
+	err = class.Find()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+
+	// ensure person is instructor of class or admin
+	if person.Role != RoleAdmin && class.Instructor != person.ID {
+		return c.JSON(403, server.Error("Only the instructor or an admin can change the class schedule", 403))
+	}
+
+	// validate schedule
+	class.Timezone = body.Timezone
+	class.Schedule = body.Schedule
+	err = class.ValidateSchedule()
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// save schedule
+	err = class.UpdateSchedule()
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+	publishEvent(EventClassUpdated, class.ID, class)
+
+	// return class
+	return c.JSON(200, class)
+}
+
+// CreateCalendarToken generates a new calendar feed token for the current
+// person, revoking any previous feed url
+func CreateCalendarToken(c echo.Context) error {
+
+	// get person loaded from jwt
+	person := currentPerson(c)
+
+	// generate token
+	token, err := server.RandomToken(24)
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// save token hash
+	person.FeedToken = server.HashToken(token)
+	err = person.UpdateFeedToken()
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return token and feed url
+	return c.JSON(200, map[string]string{
+		"token": token,
+		"url":   s.PublicURL + "/api/v1/persons/calendar.ics?token=" + token,
+	})
+}
+
+// GetCalendar returns an iCalendar feed of the class sessions of the
+// person owning the feed token, since calendar apps cannot send a jwt
+func GetCalendar(c echo.Context) error {
+	person := Person{}
+
+	// find person by feed token
+	token := c.QueryParam("token")
+	if token == "" {
+		return c.JSON(401, server.Error("Feed token is required", 401))
+	}
+	err := person.FindByFeedToken(token)
+	if err != nil {
+		return c.JSON(401, server.Error("Invalid feed token", 401))
+	}
+
+	// find classes of person
+	classes, err := FindClasses(person.Classes)
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// render calendar
+	ics, err := Calendar("Classmate - "+person.FirstName+" "+person.LastName, classes, time.Now())
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return calendar
+	return c.Blob(200, "text/calendar; charset=utf-8", ics)
+}
+
+// GetClassAttendance returns the attendance matrix of students by sessions
+// of a class as json or csv. Students only see their own attendance.
+func GetClassAttendance(c echo.Context) error {
+	class := Class{}
+
+	// get class id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid class id", 400))
+	}
+	class.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// get person loaded from jwt
+	person := currentPerson(c)
+
+	// find class in db
+	err := class.Find()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+
+	// limit students to those person can see
+	students := class.Students
+	if person.Role != RoleAdmin && class.Instructor != person.ID {
+		if !class.HasStudent(person.ID) {
+			return c.JSON(403, server.Error("Only members of a class can view its attendance", 403))
+		}
+		students = []bson.ObjectId{person.ID}
+	}
+
+	// parse date range
+	from, to, err := dateRange(c)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// find sessions, students and attendance records
+	sessions, err := class.ReportSessions(from, to, time.Now())
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+	persons, err := FindPersons(students)
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+	records, err := FindClassAttendance(class.ID)
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return report
+	report := BuildClassReport(class, sessions, persons, records)
+	return respondReport(c, "attendance-"+class.ID.Hex(), &report)
+}
+
+// UpdateClassPolicy replaces the attendance policy of a class and
+// recomputes the status of its check ins under the new policy
+func UpdateClassPolicy(c echo.Context) error {
+	class := Class{}
+	policy := Policy{}
+
+	// bind req body to policy
+	err := c.Bind(&policy)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// get class id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid class id", 400))
+	}
+	class.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// get person loaded from jwt
+	person := currentPerson(c)
+
+	// find class in db
+	err = class.Find()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+
+	// ensure person is instructor of class or admin
+	if person.Role != RoleAdmin && class.Instructor != person.ID {
+		return c.JSON(403, server.Error("Only the instructor or an admin can change the class policy", 403))
+	}
+
+	// validate policy
+	err = policy.Validate()
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// save policy
+	class.Policy = policy
+	err = class.UpdatePolicy()
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+	publishEvent(EventClassUpdated, class.ID, class)
+
+	// recompute statuses under new policy
+	changed, err := class.RecomputeAttendance()
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return policy and number of records changed
+	return c.JSON(200, map[string]interface{}{
+		"policy":  class.Policy,
+		"changed": changed,
+	})
+}
+
+// RecomputeClassAttendance recomputes the status of the check ins of
+// a class, such as after sessions of the class have been rescheduled
+func RecomputeClassAttendance(c echo.Context) error {
+	class := Class{}
+
+	// get class id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid class id", 400))
+	}
+	class.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// get person loaded from jwt
+	person := currentPerson(c)
+
+	// find class in db
+	err := class.Find()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+
+	// ensure person is instructor of class or admin
+	if person.Role != RoleAdmin && class.Instructor != person.ID {
+		return c.JSON(403, server.Error("Only the instructor or an admin can recompute attendance", 403))
+	}
+
+	// recompute statuses
+	changed, err := class.RecomputeAttendance()
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return number of records changed
+	return c.JSON(200, map[string]int{"changed": changed})
+}
+
+// GetPersonAttendance returns the attendance history of a person across
+// classes as json or csv. Students only see their own history and
+// instructors only see the classes they teach.
+func GetPersonAttendance(c echo.Context) error {
+	target := Person{}
+
+	// get person id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid person id", 400))
+	}
+	target.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// get person loaded from jwt
+	person := currentPerson(c)
+
+	// ensure person can view target
+	instructorOnly := false
+	if person.Role != RoleAdmin && person.ID != target.ID {
+		if person.Role != RoleTeacher {
+			return c.JSON(403, server.Error("Students can only view their own attendance", 403))
+		}
+		instructorOnly = true
+	}
+
+	// find target in db
+	err := target.Find()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+
+	// parse date range
+	from, to, err := dateRange(c)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+
+	// find attendance records and classes of target
+	records, err := FindStudentAttendance(target.ID)
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+	ids := append([]bson.ObjectId{}, target.Classes...)
+	for _, record := range records {
+		ids = append(ids, record.Class)
+	}
+	classes, err := FindClasses(ids)
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		return classes[i].Title < classes[j].Title
+	})
+
+	// ensure teacher teaches target
+	if instructorOnly {
+		teaches := false
+		for _, class := range classes {
+			teaches = teaches || class.Instructor == person.ID
+		}
+		if !teaches {
+			return c.JSON(403, server.Error("Teachers can only view the attendance of their students", 403))
+		}
+	}
+
+	// build history of each class person can see
+	now := time.Now()
+	histories := []ClassHistory{}
+	for _, class := range classes {
+		if instructorOnly && class.Instructor != person.ID {
+			continue
+		}
+		sessions, err := class.ReportSessions(from, to, now)
+		if err != nil {
+			return c.JSON(500, server.Error(err, 500))
+		}
+		histories = append(histories, BuildClassHistory(class, sessions, records))
+	}
+
+	// return report
+	report := BuildPersonReport(target.ID, histories)
+	return respondReport(c, "attendance-"+target.ID.Hex(), &report)
+}
+
+// SubmitExcuse submits an excuse for a session of a class for the current
+// person. The body can be json or a multipart form with an attachment file.
+func SubmitExcuse(c echo.Context) error {
+	class := Class{}
+	excuse := Excuse{}
+	body := struct {
+		Session string `json:"session" form:"session"`
+		Reason  string `json:"reason" form:"reason"`
+	}{}
+
+	// bind req body to body
+	err := c.Bind(&body)
+	if err != nil {
+		return c.JSON(400, server.Error(err, 400))
+	}
+	if body.Session == "" || body.Reason == "" {
+		return c.JSON(400, server.Error("Session and reason are required", 400))
+	}
+
+	// get class id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return c.JSON(400, server.Error("Invalid class id", 400))
+	}
+	class.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// get person loaded from jwt
+	person := currentPerson(c)
+
+	// find class in db
+	err = class.Find()
+	if err != nil {
+		return c.JSON(404, server.Error(err, 404))
+	}
+
+	// read optional attachment
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		file, err := c.FormFile("attachment")
+		if err != nil && err != http.ErrMissingFile {
+			return c.JSON(400, server.Error(err, 400))
+		}
+		if file != nil {
+			if file.Size > maxAttachmentSize {
+				return c.JSON(413, server.Error("Attachment is too large", 413))
+			}
+			src, err := file.Open()
+			if err != nil {
+				return c.JSON(400, server.Error(err, 400))
+			}
+			data, err := ioutil.ReadAll(src)
+			src.Close()
+			if err != nil {
+				return c.JSON(400, server.Error(err, 400))
+			}
+			excuse.Attachment = &Attachment{
+				Name:        file.Filename,
+				ContentType: file.Header.Get(echo.HeaderContentType),
+				Size:        len(data),
+				Data:        data,
+			}
+		}
+	}
+
+	// submit excuse
+	excuse.Student = person.ID
+	excuse.Session = body.Session
+	excuse.Reason = body.Reason
+	err = excuse.Submit(&class, time.Now())
+	switch err {
+	case nil:
+	case ErrNotEnrolled:
+		return c.JSON(403, server.Error(err, 403))
+	case ErrUnknownSession:
+		return c.JSON(400, server.Error(err, 400))
+	case ErrExcuseExists:
+		return c.JSON(409, server.Error(err, 409))
+	default:
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return excuse
+	return c.JSON(200, excuse)
+}
+
+// GetExcuseList returns the excuses the current person can see, filtered
+// by ?status= and ?class=. Instructors see excuses for the classes they
+// teach, students their own and admins all excuses.
+func GetExcuseList(c echo.Context) error {
+	filter := ExcuseFilter{Status: c.QueryParam("status")}
+
+	// get person loaded from jwt
+	person := currentPerson(c)
+
+	// limit excuses to those person can see
+	switch person.Role {
+	case RoleAdmin:
+	case RoleTeacher:
+		classes, err := FindInstructorClasses(person.ID)
+		if err != nil {
+			return c.JSON(500, server.Error(err, 500))
+		}
+		filter.Classes = []bson.ObjectId{}
+		for _, class := range classes {
+			filter.Classes = append(filter.Classes, class.ID)
+		}
+	default:
+		filter.Student = person.ID
+	}
+
+	// filter by class
+	if c.QueryParam("class") != "" {
+		if !bson.IsObjectIdHex(c.QueryParam("class")) {
+			return c.JSON(400, server.Error("Invalid class id", 400))
+		}
+		class := bson.ObjectIdHex(c.QueryParam("class"))
+		if filter.Classes != nil {
+			visible := []bson.ObjectId{}
+			for _, id := range filter.Classes {
+				if id == class {
+					visible = append(visible, id)
+				}
+			}
+			filter.Classes = visible
+		} else {
+			filter.Classes = []bson.ObjectId{class}
+		}
+	}
+
+	// find excuses in db
+	excuses, err := FindExcuses(filter)
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return excuses
+	return c.JSON(200, excuses)
+}
+
+// GetExcuse returns an excuse along with its history
+func GetExcuse(c echo.Context) error {
+	excuse, _, err := findVisibleExcuse(c)
+	if err != nil || excuse == nil {
+		return err
+	}
+
+	// return excuse
+	return c.JSON(200, excuse)
+}
+
+// GetExcuseAttachment returns the attachment file of an excuse
+func GetExcuseAttachment(c echo.Context) error {
+	excuse, _, err := findVisibleExcuse(c)
+	if err != nil || excuse == nil {
+		return err
+	}
+
+	// ensure excuse has an attachment
+	if excuse.Attachment == nil {
+		return c.JSON(404, server.Error("Excuse has no attachment", 404))
+	}
+
+	// return attachment
+	c.Response().Header().Set(echo.HeaderContentDisposition, "attachment; filename=\""+excuse.Attachment.Name+"\"")
+	return c.Blob(200, excuse.Attachment.ContentType, excuse.Attachment.Data)
+}
+
+// UpdateExcuse returns a handler changing the status of an excuse.
+// Instructors of the class and admins approve or reject excuses and
+// students withdraw their own.
+func UpdateExcuse(status string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		body := struct {
+			Note string `json:"note"`
+		}{}
+
+		// bind req body to body
+		err := c.Bind(&body)
+		if err != nil {
+			return c.JSON(400, server.Error(err, 400))
+		}
+
+		excuse, person, err := findVisibleExcuse(c)
+		if err != nil || excuse == nil {
+			return err
+		}
+
+		// ensure person can make this change
+		if status == ExcuseWithdrawn && excuse.Student != person.ID {
+			return c.JSON(403, server.Error("Only the student can withdraw an excuse", 403))
+		}
+		if status != ExcuseWithdrawn && excuse.Student == person.ID {
+			return c.JSON(403, server.Error("Only the instructor or an admin can decide an excuse", 403))
+		}
+
+		// change status
+		err = excuse.Transition(status, person.ID, body.Note, time.Now())
+		if err == ErrExcuseTransition {
+			return c.JSON(409, server.Error(err, 409))
+		}
+		if err != nil {
+			return c.JSON(500, server.Error(err, 500))
+		}
+
+		// return excuse
+		return c.JSON(200, excuse)
+	}
+}
+
+// findVisibleExcuse finds the excuse in the url if the current person is
+// its student, the instructor of its class or an admin. On failure the
+// error response has already been written and the excuse is nil.
+func findVisibleExcuse(c echo.Context) (*Excuse, *Person, error) {
+	excuse := Excuse{}
+	class := Class{}
+
+	// get excuse id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return nil, nil, c.JSON(400, server.Error("Invalid excuse id", 400))
+	}
+	excuse.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// get person loaded from jwt
+	person := currentPerson(c)
+
+	// find excuse and class in db
+	err := excuse.Find()
+	if err != nil {
+		return nil, nil, c.JSON(404, server.Error(err, 404))
+	}
+	class.ID = excuse.Class
+	err = class.Find()
+	if err != nil {
+		return nil, nil, c.JSON(404, server.Error(err, 404))
+	}
+
+	// ensure person can see excuse
+	if person.Role != RoleAdmin && class.Instructor != person.ID && excuse.Student != person.ID {
+		return nil, nil, c.JSON(403, server.Error("Only the student, instructor or an admin can view an excuse", 403))
+	}
+
+	return &excuse, person, nil
+}
+
+// EnrollStudents returns a handler enrolling or unenrolling a batch of
+// students, given by id or email, in a class. Each student is handled on
+// its own so one failure does not stop the rest of the batch.
+func EnrollStudents(enroll bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		body := struct {
+			Students []string `json:"students"`
+		}{}
+
+		// bind req body to body
+		err := c.Bind(&body)
+		if err != nil {
+			return c.JSON(400, server.Error(err, 400))
+		}
+
+		class, err := findEnrollmentClass(c)
+		if err != nil || class == nil {
+			return err
+		}
+
+		// return result for each student
+		return c.JSON(200, class.EnrollAll(body.Students, enroll))
+	}
+}
+
+// EnrollStudent returns a handler enrolling or unenrolling
+// a single student, given by id or email, in a class
+func EnrollStudent(enroll bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		class, err := findEnrollmentClass(c)
+		if err != nil || class == nil {
+			return err
+		}
+
+		// return result for student
+		result := class.EnrollAll([]string{c.Param("student")}, enroll)[0]
+		if result.Status == EnrollmentFailed {
+			return c.JSON(400, server.Error(result.Error, 400))
+		}
+		return c.JSON(200, result)
+	}
+}
+
+// RepairEnrollments fixes drift between Class.Students and Person.Classes,
+// only reporting it when ?dry_run=true
+func RepairEnrollments(c echo.Context) error {
+
+	// repair enrollments
+	dryRun := c.QueryParam("dry_run") == "true"
+	fixes, err := RepairEnrollment(dryRun)
+	if err != nil {
+		return c.JSON(500, server.Error(err, 500))
+	}
+
+	// return fixes
+	return c.JSON(200, map[string]interface{}{
+		"dry_run": dryRun,
+		"fixes":   fixes,
+	})
+}
+
+// findEnrollmentClass finds the class in the url if the current person is
+// an admin. On failure the error response has already been written and
+// the class is nil.
+func findEnrollmentClass(c echo.Context) (*Class, error) {
+	class := Class{}
+
+	// get class id from url
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return nil, c.JSON(400, server.Error("Invalid class id", 400))
+	}
+	class.ID = bson.ObjectIdHex(c.Param("id"))
+
+	// find class in db
+	err := class.Find()
+	if err != nil {
+		return nil, c.JSON(404, server.Error(err, 404))
+	}
+
+	return &class, nil
+}
+
+// respondReport writes a report as json, or csv when format=csv
+func respondReport(c echo.Context, name string, report interface {
+	WriteCSV(io.Writer) error
+}) error {
+	switch c.QueryParam("format") {
+	case "", "json":
+		return c.JSON(200, report)
+	case "csv":
+		c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+		c.Response().Header().Set(echo.HeaderContentDisposition, "attachment; filename=\""+name+".csv\"")
+		c.Response().WriteHeader(200)
+		return report.WriteCSV(c.Response())
+	}
+	return c.JSON(400, server.Error("Format must be csv or json", 400))
+}
+
+// dateRange parses the optional from and to date query params,
+// returning the end of the to date so the range is inclusive
+func dateRange(c echo.Context) (from time.Time, to time.Time, err error) {
+	if c.QueryParam("from") != "" {
+		from, err = time.Parse(dateLayout, c.QueryParam("from"))
+		if err != nil {
+			return from, to, errors.New("Invalid from date")
+		}
+	}
+	if c.QueryParam("to") != "" {
+		to, err = time.Parse(dateLayout, c.QueryParam("to"))
+		if err != nil {
+			return from, to, errors.New("Invalid to date")
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	return from, to, nil
 }
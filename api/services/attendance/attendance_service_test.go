@@ -0,0 +1,415 @@
+package attendance
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/edwintcloud/classmate/api/services/server"
+	"github.com/globalsign/mgo/bson"
+	"github.com/labstack/echo"
+)
+
+// testPassword is the password of persons signed up by tests
+const testPassword = "analytical1"
+
+// testServer registers the service with a memory store on a new echo,
+// with a dispatcher that is stopped when the test ends
+func testServer(t *testing.T) *echo.Echo {
+	dir := t.TempDir()
+	t.Setenv("JWT_KEY_FILE", filepath.Join(dir, "keys.json"))
+	t.Setenv("JWT_SECRET", "")
+	t.Setenv("MAIL_DRIVER", "outbox")
+	t.Setenv("MAIL_OUTBOX", filepath.Join(dir, "outbox"))
+
+	svr := &server.Server{Echo: echo.New(), Storage: server.StorageMemory, PublicURL: "https://classmate.example"}
+	svr.LoadKeys()
+	svr.LoadMailer()
+
+	d := NewDispatcher()
+	d.Sweep = time.Hour
+	RegisterWithDispatcher(svr, d)
+	t.Cleanup(func() {
+		d.Stop()
+		dispatcher = nil
+	})
+	return svr.Echo
+}
+
+// request sends a json request to e as the person of token, returning
+// the response status and decoded body
+func request(t *testing.T, e *echo.Echo, method, path, token string, body interface{}) (int, map[string]interface{}) {
+	data := []byte{}
+	if body != nil {
+		var err error
+		data, err = json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	out := map[string]interface{}{}
+	if rec.Body.Len() > 0 && json.Unmarshal(rec.Body.Bytes(), &out) != nil {
+		out["body"] = rec.Body.String()
+	}
+	return rec.Code, out
+}
+
+// signup registers a person with role through the api and logs them
+// in, returning their access token
+func signup(t *testing.T, e *echo.Echo, email, role string) (string, *Person) {
+	status, out := request(t, e, "POST", "/api/v1/persons", "", map[string]string{
+		"email":      email,
+		"password":   testPassword,
+		"first_name": "First",
+		"last_name":  "Last",
+	})
+	if status != 200 {
+		t.Fatalf("signup %s: %d %v", email, status, out)
+	}
+	person := &Person{Email: email}
+	if err := person.Find(); err != nil {
+		t.Fatal(err)
+	}
+	if role != RoleStudent {
+		person.Role = role
+		if err := person.UpdateRole(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status, out = request(t, e, "POST", "/api/v1/persons/login", "", map[string]string{"email": email, "password": testPassword})
+	if status != 200 {
+		t.Fatalf("login %s: %d %v", email, status, out)
+	}
+	return out["token"].(string), person
+}
+
+// createClass creates a class meeting all day every day of 2026 as
+// admin, returning its id
+func createClass(t *testing.T, e *echo.Echo, admin string, fields map[string]interface{}) string {
+	body := map[string]interface{}{
+		"title":      "Class",
+		"start_date": "2026-01-01T00:00:00Z",
+		"end_date":   "2026-12-31T00:00:00Z",
+		"start_time": "2026-01-01T00:00:00Z",
+		"end_time":   "2026-01-01T23:59:00Z",
+		"students":   []string{},
+	}
+	for key, value := range fields {
+		body[key] = value
+	}
+	status, out := request(t, e, "POST", "/api/v1/classes", admin, body)
+	if status != 200 {
+		t.Fatalf("create class: %d %v", status, out)
+	}
+	return out["_id"].(string)
+}
+
+// ids returns the ids of the items of a page
+func ids(out map[string]interface{}) []string {
+	found := []string{}
+	items, _ := out["data"].([]interface{})
+	for _, item := range items {
+		found = append(found, item.(map[string]interface{})["_id"].(string))
+	}
+	return found
+}
+
+func TestClassListDefaultsToActiveTerm(t *testing.T) {
+	e := testServer(t)
+	admin, _ := signup(t, e, "admin@example.com", RoleAdmin)
+	_, teacher := signup(t, e, "teacher@example.com", RoleTeacher)
+	student, person := signup(t, e, "student@example.com", RoleStudent)
+
+	// without terms every class is listed
+	old := createClass(t, e, admin, map[string]interface{}{"instructor": teacher.ID, "students": []string{person.ID.Hex()}})
+	status, out := request(t, e, "GET", "/api/v1/persons/classes", student, nil)
+	if status != 200 || len(ids(out)) != 1 {
+		t.Fatalf("classes = %d %v", status, out)
+	}
+
+	// with an active term only its classes are listed
+	now := time.Now().UTC()
+	status, out = request(t, e, "POST", "/api/v1/terms", admin, map[string]interface{}{
+		"name":       "Current",
+		"start_date": now.AddDate(0, 0, -7).Format("2006-01-02T00:00:00Z"),
+		"end_date":   now.AddDate(0, 0, 7).Format("2006-01-02T00:00:00Z"),
+	})
+	if status != 200 {
+		t.Fatalf("create term: %d %v", status, out)
+	}
+	term := out["_id"].(string)
+	current := createClass(t, e, admin, map[string]interface{}{"instructor": teacher.ID, "students": []string{person.ID.Hex()}, "term": term})
+
+	status, out = request(t, e, "GET", "/api/v1/persons/classes", student, nil)
+	if got := ids(out); status != 200 || len(got) != 1 || got[0] != current {
+		t.Fatalf("classes = %d %v, want only %s", status, got, current)
+	}
+	if page, _ := out["term"].(map[string]interface{}); page["_id"] != term {
+		t.Errorf("page term = %v, want %s", out["term"], term)
+	}
+
+	// term_id=all opts out of the default
+	status, out = request(t, e, "GET", "/api/v1/persons/classes?term_id=all", student, nil)
+	if got := ids(out); status != 200 || len(got) != 2 {
+		t.Fatalf("all classes = %d %v, want %s and %s", status, got, old, current)
+	}
+}
+
+func TestSignupAndLoginIgnoreOtherFields(t *testing.T) {
+	e := testServer(t)
+	_, ada := signup(t, e, "ada@example.com", RoleStudent)
+
+	// the id and role of a new person are not taken from the body
+	id := bson.NewObjectId()
+	status, out := request(t, e, "POST", "/api/v1/persons", "", map[string]interface{}{
+		"_id":        id,
+		"role":       RoleAdmin,
+		"email":      "grace@example.com",
+		"password":   testPassword,
+		"first_name": "Grace",
+		"last_name":  "Hopper",
+	})
+	if status != 200 || out["_id"] == id.Hex() || out["role"] != RoleStudent {
+		t.Fatalf("signup = %d %v", status, out)
+	}
+
+	// logging in looks up the email, not an id in the body
+	status, out = request(t, e, "POST", "/api/v1/persons/login", "", map[string]interface{}{
+		"_id":      ada.ID,
+		"email":    "grace@example.com",
+		"password": testPassword,
+	})
+	if status != 200 || out["_id"] == ada.ID.Hex() {
+		t.Fatalf("login = %d %v", status, out)
+	}
+	status, _ = request(t, e, "POST", "/api/v1/persons/login", "", map[string]interface{}{"_id": ada.ID, "password": testPassword})
+	if status != 401 {
+		t.Fatalf("login by id = %d, want 401", status)
+	}
+}
+
+func TestClassWritesBumpVersion(t *testing.T) {
+	e := testServer(t)
+	admin, _ := signup(t, e, "admin@example.com", RoleAdmin)
+	_, teacher := signup(t, e, "teacher@example.com", RoleTeacher)
+	_, student := signup(t, e, "student@example.com", RoleStudent)
+
+	// a new class ignores the version and archival time in the body
+	id := createClass(t, e, admin, map[string]interface{}{
+		"instructor":  teacher.ID,
+		"version":     7,
+		"archived_at": "2026-01-01T00:00:00Z",
+	})
+	status, out := request(t, e, "GET", "/api/v1/classes/"+id, admin, nil)
+	if status != 200 || out["version"] != 0.0 || out["archived_at"] != nil {
+		t.Fatalf("class = %d %v", status, out)
+	}
+
+	// every write moves the version on, so patches based on
+	// the version before it fail
+	for i, write := range []struct{ method, path string }{
+		{"PUT", "/api/v1/classes/" + id + "/policy"},
+		{"PUT", "/api/v1/classes/" + id + "/students/" + student.ID.Hex()},
+		{"DELETE", "/api/v1/classes/" + id + "/students/" + student.ID.Hex()},
+	} {
+		status, out = request(t, e, write.method, write.path, admin, map[string]interface{}{})
+		if status != 200 {
+			t.Fatalf("%s %s = %d %v", write.method, write.path, status, out)
+		}
+		status, out = request(t, e, "PATCH", "/api/v1/classes/"+id, admin, map[string]interface{}{"version": i, "title": "Renamed"})
+		if status != 412 {
+			t.Fatalf("patch after %s %s = %d %v, want 412", write.method, write.path, status, out)
+		}
+	}
+	status, out = request(t, e, "PATCH", "/api/v1/classes/"+id, admin, map[string]interface{}{"version": 3, "title": "Renamed"})
+	if status != 200 || out["version"] != 4.0 {
+		t.Fatalf("patch at version 3 = %d %v", status, out)
+	}
+}
+
+func TestPermissionsAreCheckedPerRoute(t *testing.T) {
+	e := testServer(t)
+	admin, _ := signup(t, e, "admin@example.com", RoleAdmin)
+	teacher, person := signup(t, e, "teacher@example.com", RoleTeacher)
+	student, _ := signup(t, e, "student@example.com", RoleStudent)
+
+	// unmatched routes are not found rather than forbidden
+	status, out := request(t, e, "GET", "/api/v1/nothing/here", student, nil)
+	if status != 404 {
+		t.Fatalf("unknown route = %d %v, want 404", status, out)
+	}
+
+	// matched routes still check the permission of their role
+	status, out = request(t, e, "POST", "/api/v1/keys/rotate", student, nil)
+	if status != 403 {
+		t.Fatalf("rotate as student = %d %v, want 403", status, out)
+	}
+	status, out = request(t, e, "GET", "/api/v1/persons", admin, nil)
+	if status != 200 {
+		t.Fatalf("persons as admin = %d %v", status, out)
+	}
+
+	// teachers only see the attendance of their own students
+	_, other := signup(t, e, "other@example.com", RoleStudent)
+	status, out = request(t, e, "GET", "/api/v1/persons/"+other.ID.Hex()+"/attendance", teacher, nil)
+	if status != 403 {
+		t.Fatalf("attendance of another student = %d %v, want 403", status, out)
+	}
+	createClass(t, e, admin, map[string]interface{}{"instructor": person.ID, "students": []string{other.ID.Hex()}})
+	status, out = request(t, e, "GET", "/api/v1/persons/"+other.ID.Hex()+"/attendance", teacher, nil)
+	if status != 200 {
+		t.Fatalf("attendance of own student = %d %v", status, out)
+	}
+}
+
+func TestListingsSearchWithQ(t *testing.T) {
+	e := testServer(t)
+	admin, _ := signup(t, e, "admin@example.com", RoleAdmin)
+	_, teacher := signup(t, e, "teacher@example.com", RoleTeacher)
+	student, person := signup(t, e, "student@example.com", RoleStudent)
+
+	algebra := createClass(t, e, admin, map[string]interface{}{"title": "Algebra", "instructor": teacher.ID, "students": []string{person.ID.Hex()}})
+	createClass(t, e, admin, map[string]interface{}{"title": "Biology", "instructor": teacher.ID, "students": []string{person.ID.Hex()}})
+	status, out := request(t, e, "GET", "/api/v1/persons/classes?q=alg", student, nil)
+	if got := ids(out); status != 200 || len(got) != 1 || got[0] != algebra {
+		t.Fatalf("classes = %d %v, want only %s", status, got, algebra)
+	}
+
+	status, out = request(t, e, "GET", "/api/v1/persons?q=TEACHER", admin, nil)
+	if got := ids(out); status != 200 || len(got) != 1 || got[0] != teacher.ID.Hex() {
+		t.Fatalf("persons = %d %v, want only %s", status, got, teacher.ID.Hex())
+	}
+}
+
+func TestAdminClassListingFiltersAndPages(t *testing.T) {
+	e := testServer(t)
+	admin, _ := signup(t, e, "admin@example.com", RoleAdmin)
+	_, teacher := signup(t, e, "teacher@example.com", RoleTeacher)
+	_, other := signup(t, e, "other@example.com", RoleTeacher)
+
+	algebra := createClass(t, e, admin, map[string]interface{}{"title": "Algebra", "instructor": teacher.ID})
+	future := createClass(t, e, admin, map[string]interface{}{"title": "analysis", "instructor": teacher.ID,
+		"start_date": "2100-01-01T00:00:00Z", "end_date": "2100-12-31T00:00:00Z"})
+	createClass(t, e, admin, map[string]interface{}{"title": "Anatomy", "instructor": other.ID})
+	archived := createClass(t, e, admin, map[string]interface{}{"title": "Ancient history", "instructor": teacher.ID})
+	if status, out := request(t, e, "POST", "/api/v1/classes/"+archived+"/archive", admin, nil); status != 200 {
+		t.Fatalf("archive = %d %v", status, out)
+	}
+
+	// pages of one class follow the cursor through the filtered classes
+	got := []string{}
+	query := "q=A&instructor=" + teacher.ID.Hex() + "&limit=1"
+	for pages := 0; pages < 3; pages++ {
+		status, out := request(t, e, "GET", "/api/v1/classes?"+query, admin, nil)
+		pagination, _ := out["pagination"].(map[string]interface{})
+		if status != 200 || pagination["total"] != 2.0 {
+			t.Fatalf("classes = %d %v", status, out)
+		}
+		got = append(got, ids(out)...)
+		cursor, _ := pagination["next_cursor"].(string)
+		if cursor == "" {
+			break
+		}
+		query = "q=A&instructor=" + teacher.ID.Hex() + "&limit=1&cursor=" + cursor
+	}
+	if len(got) != 2 || got[0] != algebra || got[1] != future {
+		t.Fatalf("classes = %v, want %s then %s", got, algebra, future)
+	}
+
+	// archived and active classes are listed apart
+	status, out := request(t, e, "GET", "/api/v1/classes?archived=true", admin, nil)
+	if got := ids(out); status != 200 || len(got) != 1 || got[0] != archived {
+		t.Fatalf("archived classes = %d %v, want only %s", status, got, archived)
+	}
+	status, out = request(t, e, "GET", "/api/v1/classes?active=false&instructor="+teacher.ID.Hex(), admin, nil)
+	if got := ids(out); status != 200 || len(got) != 1 || got[0] != future {
+		t.Fatalf("inactive classes = %d %v, want only %s", status, got, future)
+	}
+}
+
+func TestCheckInWithCode(t *testing.T) {
+	e := testServer(t)
+	admin, _ := signup(t, e, "admin@example.com", RoleAdmin)
+	teacher, instructor := signup(t, e, "teacher@example.com", RoleTeacher)
+	student, person := signup(t, e, "student@example.com", RoleStudent)
+	outsider, _ := signup(t, e, "outsider@example.com", RoleStudent)
+	id := createClass(t, e, admin, map[string]interface{}{"instructor": instructor.ID, "students": []string{person.ID.Hex()}})
+
+	// only the instructor reads the code
+	status, out := request(t, e, "GET", "/api/v1/classes/"+id+"/code", student, nil)
+	if status != 403 {
+		t.Fatalf("code as student = %d %v, want 403", status, out)
+	}
+	status, out = request(t, e, "GET", "/api/v1/classes/"+id+"/code", teacher, nil)
+	if status != 200 {
+		t.Fatalf("code = %d %v", status, out)
+	}
+	code := out["code"].(string)
+
+	for _, c := range []struct {
+		token, code string
+		status      int
+		errCode     string
+	}{
+		{student, "000000x", 403, "invalid_code"},
+		{outsider, code, 403, "not_enrolled"},
+		{student, code, 200, ""},
+		{student, code, 409, "already_checked_in"},
+	} {
+		status, out = request(t, e, "POST", "/api/v1/classes/"+id+"/checkin", c.token, map[string]string{"code": c.code})
+		if status != c.status || c.errCode != "" && out["code"] != c.errCode {
+			t.Fatalf("check in with %s = %d %v, want %d %s", c.code, status, out, c.status, c.errCode)
+		}
+	}
+	// the session started at midnight so the student may be late
+	records, err := FindStudentAttendance(person.ID)
+	if err != nil || len(records) != 1 || records[0].Class.Hex() != id || records[0].Status == StatusAbsent {
+		t.Fatalf("records = %v, %v", records, err)
+	}
+}
+
+func TestCheckInWithQRToken(t *testing.T) {
+	e := testServer(t)
+	admin, _ := signup(t, e, "admin@example.com", RoleAdmin)
+	_, instructor := signup(t, e, "teacher@example.com", RoleTeacher)
+	student, person := signup(t, e, "student@example.com", RoleStudent)
+	id := createClass(t, e, admin, map[string]interface{}{"instructor": instructor.ID, "students": []string{person.ID.Hex()}})
+
+	// the qr code holds the bare token clients post
+	class := Class{ID: bson.ObjectIdHex(id)}
+	if err := class.Find(); err != nil {
+		t.Fatal(err)
+	}
+	session, err := class.CurrentSession(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := class.CheckInToken(session, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		payload string
+		status  int
+	}{
+		{"https://classmate.example/api/v1/checkin/qr?token=" + token, 403},
+		{token, 200},
+	} {
+		status, out := request(t, e, "POST", "/api/v1/checkin/qr", student, map[string]string{"payload": c.payload})
+		if status != c.status {
+			t.Fatalf("check in with %q = %d %v, want %d", c.payload, status, out, c.status)
+		}
+	}
+}
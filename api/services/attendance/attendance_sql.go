@@ -0,0 +1,1796 @@
+package attendance
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqlDB is a sql database the attendance data is kept in. Every field
+// of a model has a column of its own, enrollments a table of their own
+// and lists of values nested in a model a json column, so sqlite and
+// postgres share one schema both can query.
+type sqlDB struct {
+	db       *sql.DB
+	postgres bool
+}
+
+// sqlQuerier is a database or transaction queries run in
+type sqlQuerier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// sqlScanner is a row of a query result
+type sqlScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// sql repositories sharing one database
+type (
+	sqlPersons    struct{ d *sqlDB }
+	sqlClasses    struct{ d *sqlDB }
+	sqlAttendance struct{ d *sqlDB }
+	sqlExcuses    struct{ d *sqlDB }
+	sqlLocations  struct{ d *sqlDB }
+	sqlLogins     struct{ d *sqlDB }
+	sqlTerms      struct{ d *sqlDB }
+	sqlWebhooks   struct{ d *sqlDB }
+	sqlDeliveries struct{ d *sqlDB }
+)
+
+// sqlTable is a table and its columns, id first, in the order
+// the values and scan functions of its model use them
+type sqlTable struct {
+	name    string
+	columns []string
+}
+
+// tables of the models
+var (
+	personsTable = sqlTable{"persons", []string{
+		"id", "email", "password", "first_name", "last_name", "role", "feed_token",
+		"invite_token", "invited_at", "reset_token", "reset_expires", "deactivated_at",
+	}}
+	classesTable = sqlTable{"classes", []string{
+		"id", "title", "instructor", "start_time", "end_time", "start_date", "end_date",
+		"location", "location_id", "code_secret", "code_period", "timezone", "schedule",
+		"grace_period", "late_cutoff", "term", "archived_at", "version",
+	}}
+	attendanceTable = sqlTable{"attendance", []string{
+		"id", "class", "student", "session", "checked_in", "status",
+	}}
+	excusesTable = sqlTable{"excuses", []string{
+		"id", "class", "student", "session", "reason", "status", "history", "created_at", "updated_at", "open_key",
+		"attachment_name", "attachment_content_type", "attachment_size", "attachment_data",
+	}}
+	locationsTable = sqlTable{"locations", []string{
+		"id", "name", "building", "networks",
+	}}
+	loginsTable = sqlTable{"logins", []string{
+		"id", "person", "token_hash", "created_at", "used_at", "expires_at", "revoked_at",
+	}}
+	termsTable = sqlTable{"terms", []string{
+		"id", "name", "start_date", "end_date", "breaks",
+	}}
+	webhooksTable = sqlTable{"webhooks", []string{
+		"id", "url", "events", "secret", "created_at",
+	}}
+	deliveriesTable = sqlTable{"deliveries", []string{
+		"id", "webhook", "event", "type", "payload", "status", "attempts", "response_status",
+		"error", "next_attempt", "leased_until", "redelivery", "created_at", "updated_at",
+	}}
+)
+
+// sqlMigrations are the schema changes of each version in order.
+// BLOB is replaced with BYTEA on postgres.
+var sqlMigrations = [][]string{
+	{
+		`CREATE TABLE persons (
+			id CHAR(24) PRIMARY KEY,
+			email VARCHAR(255) NOT NULL UNIQUE,
+			role VARCHAR(32) NOT NULL,
+			first_name VARCHAR(255) NOT NULL,
+			last_name VARCHAR(255) NOT NULL,
+			invite_token VARCHAR(64) NOT NULL,
+			feed_token VARCHAR(64) NOT NULL,
+			reset_token VARCHAR(64) NOT NULL,
+			doc BLOB NOT NULL
+		)`,
+		`CREATE INDEX persons_role ON persons (role)`,
+		`CREATE INDEX persons_invite_token ON persons (invite_token)`,
+		`CREATE INDEX persons_feed_token ON persons (feed_token)`,
+		`CREATE INDEX persons_reset_token ON persons (reset_token)`,
+		`CREATE TABLE classes (
+			id CHAR(24) PRIMARY KEY,
+			instructor CHAR(24) NOT NULL,
+			doc BLOB NOT NULL
+		)`,
+		`CREATE INDEX classes_instructor ON classes (instructor)`,
+		`CREATE TABLE attendance (
+			id CHAR(24) PRIMARY KEY,
+			class CHAR(24) NOT NULL,
+			student CHAR(24) NOT NULL,
+			session VARCHAR(32) NOT NULL,
+			doc BLOB NOT NULL,
+			UNIQUE (class, session, student)
+		)`,
+		`CREATE INDEX attendance_student ON attendance (student)`,
+		`CREATE TABLE excuses (
+			id CHAR(24) PRIMARY KEY,
+			class CHAR(24) NOT NULL,
+			student CHAR(24) NOT NULL,
+			session VARCHAR(32) NOT NULL,
+			status VARCHAR(32) NOT NULL,
+			created_at BIGINT NOT NULL,
+			doc BLOB NOT NULL
+		)`,
+		`CREATE INDEX excuses_session ON excuses (class, student, session)`,
+		`CREATE TABLE locations (
+			id CHAR(24) PRIMARY KEY,
+			building VARCHAR(255) NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			doc BLOB NOT NULL
+		)`,
+		`CREATE TABLE logins (
+			id CHAR(24) PRIMARY KEY,
+			person CHAR(24) NOT NULL,
+			token_hash VARCHAR(64) NOT NULL UNIQUE,
+			doc BLOB NOT NULL
+		)`,
+		`CREATE INDEX logins_person ON logins (person)`,
+	},
+	{
+		`ALTER TABLE classes ADD COLUMN term CHAR(24) NOT NULL DEFAULT ''`,
+		`CREATE INDEX classes_term ON classes (term)`,
+		`CREATE TABLE terms (
+			id CHAR(24) PRIMARY KEY,
+			start_date BIGINT NOT NULL,
+			doc BLOB NOT NULL
+		)`,
+	},
+	{
+		`CREATE TABLE webhooks (
+			id CHAR(24) PRIMARY KEY,
+			doc BLOB NOT NULL
+		)`,
+		`CREATE TABLE deliveries (
+			id CHAR(24) PRIMARY KEY,
+			webhook CHAR(24) NOT NULL,
+			status VARCHAR(32) NOT NULL,
+			created_at BIGINT NOT NULL,
+			doc BLOB NOT NULL
+		)`,
+		`CREATE INDEX deliveries_webhook ON deliveries (webhook, created_at)`,
+		`CREATE INDEX deliveries_status ON deliveries (status)`,
+	},
+	{
+		`ALTER TABLE excuses ADD COLUMN open_key VARCHAR(96)`,
+		`UPDATE excuses SET open_key = class || '/' || student || '/' || session WHERE status IN ('pending', 'approved')`,
+		`CREATE UNIQUE INDEX excuses_open_key ON excuses (open_key)`,
+	},
+	{
+		`ALTER TABLE persons ADD COLUMN deactivated_at BIGINT`,
+		`ALTER TABLE classes ADD COLUMN location_id CHAR(24) NOT NULL DEFAULT ''`,
+		`ALTER TABLE classes ADD COLUMN archived_at BIGINT`,
+		`CREATE INDEX classes_location_id ON classes (location_id)`,
+	},
+	{
+		// move the bson documents of versions 1 to 5 aside
+		// for their backfill to copy into columns
+		`DROP INDEX persons_role`,
+		`DROP INDEX persons_invite_token`,
+		`DROP INDEX persons_feed_token`,
+		`DROP INDEX persons_reset_token`,
+		`DROP INDEX classes_instructor`,
+		`DROP INDEX classes_term`,
+		`DROP INDEX classes_location_id`,
+		`DROP INDEX attendance_student`,
+		`DROP INDEX excuses_session`,
+		`DROP INDEX excuses_open_key`,
+		`DROP INDEX logins_person`,
+		`DROP INDEX deliveries_webhook`,
+		`DROP INDEX deliveries_status`,
+		`ALTER TABLE persons RENAME TO documents_persons`,
+		`ALTER TABLE classes RENAME TO documents_classes`,
+		`ALTER TABLE attendance RENAME TO documents_attendance`,
+		`ALTER TABLE excuses RENAME TO documents_excuses`,
+		`ALTER TABLE locations RENAME TO documents_locations`,
+		`ALTER TABLE logins RENAME TO documents_logins`,
+		`ALTER TABLE terms RENAME TO documents_terms`,
+		`ALTER TABLE webhooks RENAME TO documents_webhooks`,
+		`ALTER TABLE deliveries RENAME TO documents_deliveries`,
+		`CREATE TABLE persons (
+			id VARCHAR(24) PRIMARY KEY,
+			email VARCHAR(255) NOT NULL UNIQUE,
+			password VARCHAR(255) NOT NULL,
+			first_name VARCHAR(255) NOT NULL,
+			last_name VARCHAR(255) NOT NULL,
+			role VARCHAR(32) NOT NULL,
+			feed_token VARCHAR(64) NOT NULL,
+			invite_token VARCHAR(64) NOT NULL,
+			invited_at BIGINT,
+			reset_token VARCHAR(64) NOT NULL,
+			reset_expires BIGINT,
+			deactivated_at BIGINT
+		)`,
+		`CREATE INDEX persons_role ON persons (role)`,
+		`CREATE INDEX persons_feed_token ON persons (feed_token)`,
+		`CREATE INDEX persons_invite_token ON persons (invite_token)`,
+		`CREATE INDEX persons_reset_token ON persons (reset_token)`,
+		`CREATE TABLE classes (
+			id VARCHAR(24) PRIMARY KEY,
+			title VARCHAR(255) NOT NULL,
+			instructor VARCHAR(24) NOT NULL,
+			start_time BIGINT NOT NULL,
+			end_time BIGINT NOT NULL,
+			start_date BIGINT NOT NULL,
+			end_date BIGINT NOT NULL,
+			location VARCHAR(255) NOT NULL,
+			location_id VARCHAR(24) NOT NULL,
+			code_secret VARCHAR(64) NOT NULL,
+			code_period INTEGER NOT NULL,
+			timezone VARCHAR(64) NOT NULL,
+			schedule TEXT,
+			grace_period INTEGER NOT NULL,
+			late_cutoff INTEGER NOT NULL,
+			term VARCHAR(24) NOT NULL,
+			archived_at BIGINT,
+			version INTEGER NOT NULL
+		)`,
+		`CREATE INDEX classes_instructor ON classes (instructor)`,
+		`CREATE INDEX classes_term ON classes (term)`,
+		`CREATE INDEX classes_location_id ON classes (location_id)`,
+		`CREATE TABLE enrollments (
+			class VARCHAR(24) NOT NULL,
+			student VARCHAR(24) NOT NULL,
+			PRIMARY KEY (class, student)
+		)`,
+		`CREATE INDEX enrollments_student ON enrollments (student)`,
+		`CREATE TABLE attendance (
+			id VARCHAR(24) PRIMARY KEY,
+			class VARCHAR(24) NOT NULL,
+			student VARCHAR(24) NOT NULL,
+			session VARCHAR(32) NOT NULL,
+			checked_in BIGINT NOT NULL,
+			status VARCHAR(32) NOT NULL,
+			UNIQUE (class, session, student)
+		)`,
+		`CREATE INDEX attendance_student ON attendance (student)`,
+		`CREATE TABLE excuses (
+			id VARCHAR(24) PRIMARY KEY,
+			class VARCHAR(24) NOT NULL,
+			student VARCHAR(24) NOT NULL,
+			session VARCHAR(32) NOT NULL,
+			reason TEXT NOT NULL,
+			status VARCHAR(32) NOT NULL,
+			history TEXT NOT NULL,
+			created_at BIGINT NOT NULL,
+			updated_at BIGINT NOT NULL,
+			open_key VARCHAR(96) UNIQUE,
+			attachment_name VARCHAR(255),
+			attachment_content_type VARCHAR(255),
+			attachment_size INTEGER,
+			attachment_data BLOB
+		)`,
+		`CREATE INDEX excuses_session ON excuses (class, student, session)`,
+		`CREATE TABLE locations (
+			id VARCHAR(24) PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			building VARCHAR(255) NOT NULL,
+			networks TEXT NOT NULL
+		)`,
+		`CREATE TABLE logins (
+			id VARCHAR(24) PRIMARY KEY,
+			person VARCHAR(24) NOT NULL,
+			token_hash VARCHAR(64) NOT NULL UNIQUE,
+			created_at BIGINT NOT NULL,
+			used_at BIGINT NOT NULL,
+			expires_at BIGINT NOT NULL,
+			revoked_at BIGINT
+		)`,
+		`CREATE INDEX logins_person ON logins (person)`,
+		`CREATE TABLE terms (
+			id VARCHAR(24) PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			start_date BIGINT NOT NULL,
+			end_date BIGINT NOT NULL,
+			breaks TEXT NOT NULL
+		)`,
+		`CREATE TABLE webhooks (
+			id VARCHAR(24) PRIMARY KEY,
+			url TEXT NOT NULL,
+			events TEXT NOT NULL,
+			secret VARCHAR(255) NOT NULL,
+			created_at BIGINT NOT NULL
+		)`,
+		`CREATE TABLE deliveries (
+			id VARCHAR(24) PRIMARY KEY,
+			webhook VARCHAR(24) NOT NULL,
+			event VARCHAR(255) NOT NULL,
+			type VARCHAR(255) NOT NULL,
+			payload TEXT NOT NULL,
+			status VARCHAR(32) NOT NULL,
+			attempts INTEGER NOT NULL,
+			response_status INTEGER NOT NULL,
+			error TEXT NOT NULL,
+			next_attempt BIGINT,
+			leased_until BIGINT,
+			redelivery VARCHAR(24) NOT NULL,
+			created_at BIGINT NOT NULL,
+			updated_at BIGINT NOT NULL
+		)`,
+		`CREATE INDEX deliveries_webhook ON deliveries (webhook, created_at)`,
+		`CREATE INDEX deliveries_status ON deliveries (status)`,
+	},
+	{
+		// lowercase the emails saved before emails were normalised,
+		// which only needs a backfill
+	},
+}
+
+// sqlBackfills fill the columns added by a version from the rows
+// saved before it, in the transaction of the version
+var sqlBackfills = map[int]func(d *sqlDB, tx *sql.Tx) error{
+	5: func(d *sqlDB, tx *sql.Tx) error {
+		persons, classes := []Person{}, []Class{}
+		err := documents(tx, "persons", func() interface{} {
+			persons = append(persons, Person{})
+			return &persons[len(persons)-1]
+		})
+		if err != nil {
+			return err
+		}
+		err = documents(tx, "classes", func() interface{} {
+			classes = append(classes, Class{})
+			return &classes[len(classes)-1]
+		})
+		if err != nil {
+			return err
+		}
+		for _, p := range persons {
+			_, err = tx.Exec(d.rebind(`UPDATE persons SET deactivated_at = ? WHERE id = ?`), sqlNanos(p.DeactivatedAt), p.ID.Hex())
+			if err != nil {
+				return err
+			}
+		}
+		for _, c := range classes {
+			_, err = tx.Exec(d.rebind(`UPDATE classes SET location_id = ?, archived_at = ? WHERE id = ?`), c.LocationID.Hex(), sqlNanos(c.ArchivedAt), c.ID.Hex())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	6: func(d *sqlDB, tx *sql.Tx) error {
+		copies := []struct {
+			table  string
+			next   func() interface{}
+			insert func(doc interface{}) error
+		}{
+			{"persons", func() interface{} { return &Person{} }, func(doc interface{}) error { return d.insertPerson(tx, doc.(*Person)) }},
+			{"classes", func() interface{} { return &Class{} }, func(doc interface{}) error { return d.insertClass(tx, doc.(*Class)) }},
+			{"attendance", func() interface{} { return &Attendance{} }, func(doc interface{}) error {
+				return d.insert(tx, attendanceTable, attendanceValues(doc.(*Attendance)))
+			}},
+			{"excuses", func() interface{} { return &Excuse{} }, func(doc interface{}) error {
+				// open keys were only kept in a column
+				e := doc.(*Excuse)
+				e.Open = e.openKey()
+				return d.insert(tx, excusesTable, excuseValues(e))
+			}},
+			{"locations", func() interface{} { return &Location{} }, func(doc interface{}) error {
+				return d.insert(tx, locationsTable, locationValues(doc.(*Location)))
+			}},
+			{"logins", func() interface{} { return &Login{} }, func(doc interface{}) error {
+				return d.insert(tx, loginsTable, loginValues(doc.(*Login)))
+			}},
+			{"terms", func() interface{} { return &Term{} }, func(doc interface{}) error {
+				return d.insert(tx, termsTable, termValues(doc.(*Term)))
+			}},
+			{"webhooks", func() interface{} { return &Webhook{} }, func(doc interface{}) error {
+				return d.insert(tx, webhooksTable, webhookValues(doc.(*Webhook)))
+			}},
+			{"deliveries", func() interface{} { return &Delivery{} }, func(doc interface{}) error {
+				return d.insert(tx, deliveriesTable, deliveryValues(doc.(*Delivery)))
+			}},
+		}
+		for _, c := range copies {
+			docs := []interface{}{}
+			err := documents(tx, "documents_"+c.table, func() interface{} {
+				docs = append(docs, c.next())
+				return docs[len(docs)-1]
+			})
+			if err != nil {
+				return err
+			}
+			for _, doc := range docs {
+				err = c.insert(doc)
+				if err != nil {
+					return fmt.Errorf("Unable to copy %s: %s", c.table, err.Error())
+				}
+			}
+			_, err = tx.Exec(`DROP TABLE documents_` + c.table)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	7: func(d *sqlDB, tx *sql.Tx) error {
+		persons := []Person{}
+		err := d.list(tx, "SELECT id, email FROM persons ORDER BY id", func(row sqlScanner) error {
+			var id, email string
+			err := row.Scan(&id, &email)
+			persons = append(persons, Person{ID: objectID(id), Email: email})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		changes, conflicts := emailChanges(persons)
+		for id, email := range changes {
+			err = d.exec(tx, "UPDATE persons SET email = ? WHERE id = ?", email, id.Hex())
+			if err != nil {
+				return fmt.Errorf("Unable to lowercase email %s: %s", email, err.Error())
+			}
+		}
+		for _, conflict := range conflicts {
+			log.Printf("Left email %s of persons %v as it is, rename all but one of them to sign in with it", conflict.Email, hexIDs(conflict.Persons))
+		}
+		return nil
+	},
+}
+
+// documents decodes the bson documents of a table of versions 1 to 5
+// into the values next returns, reading them all before returning so
+// the transaction can be written to
+func documents(tx *sql.Tx, table string, next func() interface{}) error {
+	rows, err := tx.Query("SELECT doc FROM " + table + " ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		data := []byte{}
+		err = rows.Scan(&data)
+		if err != nil {
+			return err
+		}
+		err = bson.Unmarshal(data, next())
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// NewSQLStore returns a store backed by a sqlite or postgres
+// database, migrating its schema to the latest version
+func NewSQLStore(database *sql.DB, driver string) (Store, error) {
+	d := &sqlDB{db: database, postgres: driver == "postgres"}
+	store := Store{
+		Persons:    sqlPersons{d},
+		Classes:    sqlClasses{d},
+		Attendance: sqlAttendance{d},
+		Excuses:    sqlExcuses{d},
+		Locations:  sqlLocations{d},
+		Logins:     sqlLogins{d},
+		Terms:      sqlTerms{d},
+		Webhooks:   sqlWebhooks{d},
+		Deliveries: sqlDeliveries{d},
+	}
+	return store, d.migrate()
+}
+
+// migrate applies the migrations newer than the schema version
+func (d *sqlDB) migrate() error {
+	_, err := d.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	// find current version
+	version := 0
+	err = d.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	// apply each newer version in its own transaction
+	for i := version; i < len(sqlMigrations); i++ {
+		err = d.transact(func(tx *sql.Tx) error {
+			for _, statement := range sqlMigrations[i] {
+				if d.postgres {
+					statement = strings.Replace(statement, "BLOB", "BYTEA", -1)
+				}
+				_, err := tx.Exec(statement)
+				if err != nil {
+					return err
+				}
+			}
+			if backfill, ok := sqlBackfills[i+1]; ok {
+				err := backfill(d, tx)
+				if err != nil {
+					return err
+				}
+			}
+			_, err := tx.Exec(d.rebind(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`), i+1, time.Now().Unix())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to migrate to version %d: %s", i+1, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// rebind replaces ? placeholders with the numbered ones of postgres
+func (d *sqlDB) rebind(query string) string {
+	if !d.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// forUpdate locks selected rows until the end of a transaction
+// on postgres, sqlite locking the whole database instead
+func (d *sqlDB) forUpdate() string {
+	if d.postgres {
+		return " FOR UPDATE"
+	}
+	return ""
+}
+
+// transact runs fn in a transaction, rolling back if it fails
+func (d *sqlDB) transact(fn func(tx *sql.Tx) error) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return sqlError(tx.Commit())
+}
+
+// sqlError translates driver errors to repository errors
+func sqlError(err error) error {
+	if e, ok := err.(*sqlite.Error); ok && (e.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || e.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY) {
+		return ErrDuplicate
+	}
+	if e, ok := err.(*pq.Error); ok && e.Code == "23505" {
+		return ErrDuplicate
+	}
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+// placeholders returns n comma separated placeholders
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// hexIDs returns ids as hex strings for use as query arguments
+func hexIDs(ids []bson.ObjectId) []interface{} {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id.Hex()
+	}
+	return args
+}
+
+// objectID returns the object id of a hex column, which is
+// empty for ids that were not set
+func objectID(hex string) bson.ObjectId {
+	if hex == "" {
+		return ""
+	}
+	return bson.ObjectIdHex(hex)
+}
+
+// sqlNull returns value or NULL if it is empty, so unique
+// columns hold any number of empty values
+func sqlNull(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// sqlNanos returns t in nanoseconds or NULL if it is nil, as
+// versions 1 to 5 kept times
+func sqlNanos(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UnixNano()
+}
+
+// sqlTime returns t in milliseconds, the precision mongo keeps times in
+func sqlTime(t time.Time) int64 {
+	return t.UnixMilli()
+}
+
+// sqlNullTime returns t in milliseconds or NULL if it is nil
+func sqlNullTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UnixMilli()
+}
+
+// timeOf returns the time of a column in milliseconds, keeping the
+// zero time zero
+func timeOf(ms int64) time.Time {
+	if ms == (time.Time{}).UnixMilli() {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+// nullTimeOf returns the time of a nullable column or nil if it is NULL
+func nullTimeOf(ms sql.NullInt64) *time.Time {
+	if !ms.Valid {
+		return nil
+	}
+	t := timeOf(ms.Int64)
+	return &t
+}
+
+// sqlJSON returns v encoded as json for a json column
+func sqlJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("attendance: unable to encode %T: %s", v, err.Error()))
+	}
+	return string(data)
+}
+
+// selectFrom returns a select of the columns of the table, selecting
+// NULL in place of the columns of omit
+func (t sqlTable) selectFrom(omit ...string) string {
+	columns := make([]string, len(t.columns))
+	for i, column := range t.columns {
+		columns[i] = column
+		for _, o := range omit {
+			if column == o {
+				columns[i] = "NULL"
+			}
+		}
+	}
+	return "SELECT " + strings.Join(columns, ", ") + " FROM " + t.name
+}
+
+// insert adds a row of values to table
+func (d *sqlDB) insert(q sqlQuerier, t sqlTable, values []interface{}) error {
+	query := "INSERT INTO " + t.name + " (" + strings.Join(t.columns, ", ") + ") VALUES (" + placeholders(len(values)) + ")"
+	_, err := q.Exec(d.rebind(query), values...)
+	return sqlError(err)
+}
+
+// replace saves every column of the row of values, whose id is first
+func (d *sqlDB) replace(q sqlQuerier, t sqlTable, values []interface{}) error {
+	set := make([]string, len(t.columns)-1)
+	for i, column := range t.columns[1:] {
+		set[i] = column + " = ?"
+	}
+	return d.exec(q, "UPDATE "+t.name+" SET "+strings.Join(set, ", ")+" WHERE id = ?", append(values[1:], values[0])...)
+}
+
+// exec runs a statement changing rows, returning ErrNotFound if it
+// matched none
+func (d *sqlDB) exec(q sqlQuerier, query string, args ...interface{}) error {
+	res, err := q.Exec(d.rebind(query), args...)
+	if err != nil {
+		return sqlError(err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// get scans the row of query with scan, locking it when run in a
+// transaction
+func (d *sqlDB) get(q sqlQuerier, query string, scan func(row sqlScanner) error, args ...interface{}) error {
+	if _, ok := q.(*sql.Tx); ok {
+		query += d.forUpdate()
+	}
+	return sqlError(scan(q.QueryRow(d.rebind(query), args...)))
+}
+
+// list scans each row of query with scan
+func (d *sqlDB) list(q sqlQuerier, query string, scan func(row sqlScanner) error, args ...interface{}) error {
+	rows, err := q.Query(d.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err = scan(rows)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// count counts the rows of table matching where
+func (d *sqlDB) count(table, where string, args ...interface{}) (int, error) {
+	n := 0
+	err := d.db.QueryRow(d.rebind("SELECT COUNT(*) FROM "+table+" WHERE "+where), args...).Scan(&n)
+	return n, err
+}
+
+// where joins conditions into a where clause, which is empty
+// without conditions
+func where(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// sqlSearch returns a condition matching rows with any of exprs
+// containing search, which is in lower case
+func sqlSearch(search string, exprs ...string) (string, []interface{}) {
+	pattern := "%" + likeEscaper.Replace(search) + "%"
+	conditions := make([]string, len(exprs))
+	args := make([]interface{}, len(exprs))
+	for i, expr := range exprs {
+		conditions[i] = "LOWER(" + expr + `) LIKE ? ESCAPE '\'`
+		args[i] = pattern
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")", args
+}
+
+// page returns the where clause and order selecting the rows matching
+// conditions in the order and page of opts, comparing text in lower case
+func (d *sqlDB) page(conditions []string, args []interface{}, opts listOptions) (string, string, []interface{}) {
+	fields := opts.fields()
+	exprs := make([]string, len(fields))
+	for i, field := range fields {
+		exprs[i] = "LOWER(" + field + ")"
+		if dateFields[field] {
+			exprs[i] = field
+		}
+	}
+	values := make([]interface{}, len(opts.after))
+	for i, value := range opts.after {
+		values[i] = value
+		if t, ok := value.(time.Time); ok {
+			values[i] = sqlTime(t)
+		}
+	}
+	dir, op := " ASC", " > ?"
+	if opts.desc {
+		dir, op = " DESC", " < ?"
+	}
+
+	// select rows after the cursor, which come after it in
+	// the first field differing from it
+	if opts.cursor != nil {
+		after := []string{}
+		for i := 0; i <= len(fields); i++ {
+			parts := []string{}
+			for j := 0; j < i; j++ {
+				parts = append(parts, exprs[j]+" = ?")
+				args = append(args, values[j])
+			}
+			if i < len(fields) {
+				parts = append(parts, exprs[i]+op)
+				args = append(args, values[i])
+			} else {
+				parts = append(parts, "id"+op)
+				args = append(args, opts.cursor.ID.Hex())
+			}
+			after = append(after, "("+strings.Join(parts, " AND ")+")")
+		}
+		conditions = append(conditions, "("+strings.Join(after, " OR ")+")")
+	}
+
+	order := []string{}
+	for _, expr := range exprs {
+		order = append(order, expr+dir)
+	}
+	order = append(order, "id"+dir)
+	limit := ""
+	if opts.limit > 0 {
+		limit = " LIMIT " + strconv.Itoa(opts.limit)
+	}
+	return where(conditions), strings.Join(order, ", ") + limit, args
+}
+
+// enrollmentBatch is the number of ids enrollments are read for at once,
+// keeping queries under the parameter limits of sqlite and postgres
+const enrollmentBatch = 500
+
+// enrolled returns the enrollments of the persons or classes with ids,
+// keyed by their id, where by is student or class. The other side of
+// each enrollment is listed in order of id.
+func (d *sqlDB) enrolled(q sqlQuerier, by string, ids []bson.ObjectId) (map[bson.ObjectId][]bson.ObjectId, error) {
+	other := "class"
+	if by == "class" {
+		other = "student"
+	}
+	found := map[bson.ObjectId][]bson.ObjectId{}
+	for start := 0; start < len(ids); start += enrollmentBatch {
+		batch := ids[start:]
+		if len(batch) > enrollmentBatch {
+			batch = batch[:enrollmentBatch]
+		}
+		query := "SELECT " + by + ", " + other + " FROM enrollments WHERE " + by + " IN (" + placeholders(len(batch)) + ") ORDER BY " + other
+		err := d.list(q, query, func(row sqlScanner) error {
+			var key, value string
+			err := row.Scan(&key, &value)
+			found[objectID(key)] = append(found[objectID(key)], objectID(value))
+			return err
+		}, hexIDs(batch)...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// enroll adds enrollments of classes and students, skipping
+// those that exist
+func (d *sqlDB) enroll(q sqlQuerier, class bson.ObjectId, students []bson.ObjectId) error {
+	for _, student := range students {
+		_, err := q.Exec(d.rebind(`INSERT INTO enrollments (class, student) VALUES (?, ?) ON CONFLICT DO NOTHING`), class.Hex(), student.Hex())
+		if err != nil {
+			return sqlError(err)
+		}
+	}
+	return nil
+}
+
+// personValues returns the column values of a person
+func personValues(p *Person) []interface{} {
+	return []interface{}{
+		p.ID.Hex(), p.Email, p.Password, p.FirstName, p.LastName, p.Role, p.FeedToken,
+		p.InviteToken, sqlNullTime(p.InvitedAt), p.ResetToken, sqlNullTime(p.ResetExpires), sqlNullTime(p.DeactivatedAt),
+	}
+}
+
+// scanPerson scans a row of persons into p, without its classes
+func scanPerson(row sqlScanner, p *Person) error {
+	var id string
+	var invited, expires, deactivated sql.NullInt64
+	found := Person{}
+	err := row.Scan(&id, &found.Email, &found.Password, &found.FirstName, &found.LastName, &found.Role, &found.FeedToken,
+		&found.InviteToken, &invited, &found.ResetToken, &expires, &deactivated)
+	if err != nil {
+		return err
+	}
+	found.ID = objectID(id)
+	found.InvitedAt = nullTimeOf(invited)
+	found.ResetExpires = nullTimeOf(expires)
+	found.DeactivatedAt = nullTimeOf(deactivated)
+	*p = found
+	return nil
+}
+
+// insertPerson adds a person and their enrollments
+func (d *sqlDB) insertPerson(q sqlQuerier, p *Person) error {
+	err := d.insert(q, personsTable, personValues(p))
+	if err != nil {
+		return err
+	}
+	for _, class := range p.Classes {
+		err = d.enroll(q, class, []bson.ObjectId{p.ID})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findPerson fills p with the first person matching where, along
+// with their classes
+func (d *sqlDB) findPerson(q sqlQuerier, where string, p *Person, args ...interface{}) error {
+	found := Person{}
+	err := d.get(q, personsTable.selectFrom()+" WHERE "+where+" ORDER BY id LIMIT 1", func(row sqlScanner) error {
+		return scanPerson(row, &found)
+	}, args...)
+	if err != nil {
+		return err
+	}
+	classes, err := d.enrolled(q, "student", []bson.ObjectId{found.ID})
+	if err != nil {
+		return err
+	}
+	found.Classes = append([]bson.ObjectId{}, classes[found.ID]...)
+	*p = found
+	return nil
+}
+
+// listPersons returns the persons matching where in order,
+// along with their classes
+func (d *sqlDB) listPersons(where, order string, args ...interface{}) ([]Person, error) {
+	found := []Person{}
+	err := d.list(d.db, personsTable.selectFrom()+where+" ORDER BY "+order, func(row sqlScanner) error {
+		p := Person{}
+		err := scanPerson(row, &p)
+		found = append(found, p)
+		return err
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]bson.ObjectId, len(found))
+	for i := range found {
+		ids[i] = found[i].ID
+	}
+	classes, err := d.enrolled(d.db, "student", ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range found {
+		found[i].Classes = append([]bson.ObjectId{}, classes[found[i].ID]...)
+	}
+	return found, nil
+}
+
+// classValues returns the column values of a class
+func classValues(c *Class) []interface{} {
+	var schedule interface{}
+	if c.Schedule != nil {
+		schedule = sqlJSON(c.Schedule)
+	}
+	return []interface{}{
+		c.ID.Hex(), c.Title, c.Instructor.Hex(), sqlTime(c.StartTime), sqlTime(c.EndTime), sqlTime(c.StartDate), sqlTime(c.EndDate),
+		c.Location, c.LocationID.Hex(), c.CodeSecret, c.CodePeriod, c.Timezone, schedule,
+		c.Policy.GracePeriod, c.Policy.LateCutoff, c.Term.Hex(), sqlNullTime(c.ArchivedAt), c.Version,
+	}
+}
+
+// scanClass scans a row of classes into c, without its students
+func scanClass(row sqlScanner, c *Class) error {
+	var id, instructor, location, term string
+	var start, end, startDate, endDate int64
+	var schedule sql.NullString
+	var archived sql.NullInt64
+	found := Class{}
+	err := row.Scan(&id, &found.Title, &instructor, &start, &end, &startDate, &endDate,
+		&found.Location, &location, &found.CodeSecret, &found.CodePeriod, &found.Timezone, &schedule,
+		&found.Policy.GracePeriod, &found.Policy.LateCutoff, &term, &archived, &found.Version)
+	if err != nil {
+		return err
+	}
+	found.ID = objectID(id)
+	found.Instructor = objectID(instructor)
+	found.StartTime, found.EndTime = timeOf(start), timeOf(end)
+	found.StartDate, found.EndDate = timeOf(startDate), timeOf(endDate)
+	found.LocationID = objectID(location)
+	found.Term = objectID(term)
+	found.ArchivedAt = nullTimeOf(archived)
+	if schedule.Valid {
+		found.Schedule = &Schedule{}
+		err = json.Unmarshal([]byte(schedule.String), found.Schedule)
+		if err != nil {
+			return err
+		}
+	}
+	*c = found
+	return nil
+}
+
+// insertClass adds a class and its enrollments
+func (d *sqlDB) insertClass(q sqlQuerier, c *Class) error {
+	err := d.insert(q, classesTable, classValues(c))
+	if err != nil {
+		return err
+	}
+	return d.enroll(q, c.ID, c.Students)
+}
+
+// listClasses returns the classes matching where in order,
+// along with their students
+func (d *sqlDB) listClasses(where, order string, args ...interface{}) ([]Class, error) {
+	found := []Class{}
+	err := d.list(d.db, classesTable.selectFrom()+where+" ORDER BY "+order, func(row sqlScanner) error {
+		c := Class{}
+		err := scanClass(row, &c)
+		found = append(found, c)
+		return err
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]bson.ObjectId, len(found))
+	for i := range found {
+		ids[i] = found[i].ID
+	}
+	students, err := d.enrolled(d.db, "class", ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range found {
+		found[i].Students = append([]bson.ObjectId{}, students[found[i].ID]...)
+	}
+	return found, nil
+}
+
+// updateClass runs set on the class with id and increments its version
+func (d *sqlDB) updateClass(q sqlQuerier, id bson.ObjectId, set string, args ...interface{}) error {
+	if set != "" {
+		set += ", "
+	}
+	return d.exec(q, "UPDATE classes SET "+set+"version = version + 1 WHERE id = ?", append(args, id.Hex())...)
+}
+
+// attendanceValues returns the column values of an attendance record
+func attendanceValues(a *Attendance) []interface{} {
+	return []interface{}{a.ID.Hex(), a.Class.Hex(), a.Student.Hex(), a.Session, sqlTime(a.CheckedIn), a.Status}
+}
+
+// scanAttendance scans a row of attendance into a
+func scanAttendance(row sqlScanner, a *Attendance) error {
+	var id, class, student string
+	var checkedIn int64
+	found := Attendance{}
+	err := row.Scan(&id, &class, &student, &found.Session, &checkedIn, &found.Status)
+	if err != nil {
+		return err
+	}
+	found.ID, found.Class, found.Student = objectID(id), objectID(class), objectID(student)
+	found.CheckedIn = timeOf(checkedIn)
+	*a = found
+	return nil
+}
+
+// listAttendance returns the attendance records matching where
+func (d *sqlDB) listAttendance(where string, args ...interface{}) ([]Attendance, error) {
+	found := []Attendance{}
+	err := d.list(d.db, attendanceTable.selectFrom()+where+" ORDER BY id", func(row sqlScanner) error {
+		a := Attendance{}
+		err := scanAttendance(row, &a)
+		found = append(found, a)
+		return err
+	}, args...)
+	return found, err
+}
+
+// excuseValues returns the column values of an excuse
+func excuseValues(e *Excuse) []interface{} {
+	values := []interface{}{
+		e.ID.Hex(), e.Class.Hex(), e.Student.Hex(), e.Session, e.Reason, e.Status, sqlJSON(e.History),
+		sqlTime(e.CreatedAt), sqlTime(e.UpdatedAt), sqlNull(e.Open), nil, nil, nil, nil,
+	}
+	if a := e.Attachment; a != nil {
+		values[10], values[11], values[12], values[13] = a.Name, a.ContentType, a.Size, a.Data
+	}
+	return values
+}
+
+// scanExcuse scans a row of excuses into e
+func scanExcuse(row sqlScanner, e *Excuse) error {
+	var id, class, student, history string
+	var created, updated int64
+	var open, name, contentType sql.NullString
+	var size sql.NullInt64
+	var data []byte
+	found := Excuse{}
+	err := row.Scan(&id, &class, &student, &found.Session, &found.Reason, &found.Status, &history,
+		&created, &updated, &open, &name, &contentType, &size, &data)
+	if err != nil {
+		return err
+	}
+	found.ID, found.Class, found.Student = objectID(id), objectID(class), objectID(student)
+	found.CreatedAt, found.UpdatedAt = timeOf(created), timeOf(updated)
+	found.Open = open.String
+	if name.Valid {
+		found.Attachment = &Attachment{Name: name.String, ContentType: contentType.String, Size: int(size.Int64), Data: data}
+	}
+	err = json.Unmarshal([]byte(history), &found.History)
+	if err != nil {
+		return err
+	}
+	*e = found
+	return nil
+}
+
+// locationValues returns the column values of a location
+func locationValues(l *Location) []interface{} {
+	return []interface{}{l.ID.Hex(), l.Name, l.Building, sqlJSON(l.Networks)}
+}
+
+// scanLocation scans a row of locations into l
+func scanLocation(row sqlScanner, l *Location) error {
+	var id, networks string
+	found := Location{}
+	err := row.Scan(&id, &found.Name, &found.Building, &networks)
+	if err != nil {
+		return err
+	}
+	found.ID = objectID(id)
+	err = json.Unmarshal([]byte(networks), &found.Networks)
+	if err != nil {
+		return err
+	}
+	*l = found
+	return nil
+}
+
+// loginValues returns the column values of a login
+func loginValues(l *Login) []interface{} {
+	return []interface{}{
+		l.ID.Hex(), l.Person.Hex(), l.TokenHash, sqlTime(l.CreatedAt), sqlTime(l.UsedAt), sqlTime(l.ExpiresAt), sqlNullTime(l.RevokedAt),
+	}
+}
+
+// scanLogin scans a row of logins into l
+func scanLogin(row sqlScanner, l *Login) error {
+	var id, person string
+	var created, used, expires int64
+	var revoked sql.NullInt64
+	found := Login{}
+	err := row.Scan(&id, &person, &found.TokenHash, &created, &used, &expires, &revoked)
+	if err != nil {
+		return err
+	}
+	found.ID, found.Person = objectID(id), objectID(person)
+	found.CreatedAt, found.UsedAt, found.ExpiresAt = timeOf(created), timeOf(used), timeOf(expires)
+	found.RevokedAt = nullTimeOf(revoked)
+	*l = found
+	return nil
+}
+
+// termValues returns the column values of a term
+func termValues(t *Term) []interface{} {
+	return []interface{}{t.ID.Hex(), t.Name, sqlTime(t.StartDate), sqlTime(t.EndDate), sqlJSON(t.Breaks)}
+}
+
+// scanTerm scans a row of terms into t
+func scanTerm(row sqlScanner, t *Term) error {
+	var id, breaks string
+	var start, end int64
+	found := Term{}
+	err := row.Scan(&id, &found.Name, &start, &end, &breaks)
+	if err != nil {
+		return err
+	}
+	found.ID = objectID(id)
+	found.StartDate, found.EndDate = timeOf(start), timeOf(end)
+	err = json.Unmarshal([]byte(breaks), &found.Breaks)
+	if err != nil {
+		return err
+	}
+	*t = found
+	return nil
+}
+
+// webhookValues returns the column values of a webhook
+func webhookValues(w *Webhook) []interface{} {
+	return []interface{}{w.ID.Hex(), w.URL, sqlJSON(w.Events), w.Secret, sqlTime(w.CreatedAt)}
+}
+
+// scanWebhook scans a row of webhooks into w
+func scanWebhook(row sqlScanner, w *Webhook) error {
+	var id, events string
+	var created int64
+	found := Webhook{}
+	err := row.Scan(&id, &found.URL, &events, &found.Secret, &created)
+	if err != nil {
+		return err
+	}
+	found.ID = objectID(id)
+	found.CreatedAt = timeOf(created)
+	err = json.Unmarshal([]byte(events), &found.Events)
+	if err != nil {
+		return err
+	}
+	*w = found
+	return nil
+}
+
+// deliveryValues returns the column values of a delivery
+func deliveryValues(d *Delivery) []interface{} {
+	return []interface{}{
+		d.ID.Hex(), d.Webhook.Hex(), d.Event, d.Type, d.Payload, d.Status, d.Attempts, d.ResponseStatus,
+		d.Error, sqlNullTime(d.NextAttempt), sqlNullTime(d.LeasedUntil), d.Redelivery.Hex(), sqlTime(d.CreatedAt), sqlTime(d.UpdatedAt),
+	}
+}
+
+// scanDelivery scans a row of deliveries into d
+func scanDelivery(row sqlScanner, d *Delivery) error {
+	var id, webhook, redelivery string
+	var next, leased sql.NullInt64
+	var created, updated int64
+	found := Delivery{}
+	err := row.Scan(&id, &webhook, &found.Event, &found.Type, &found.Payload, &found.Status, &found.Attempts, &found.ResponseStatus,
+		&found.Error, &next, &leased, &redelivery, &created, &updated)
+	if err != nil {
+		return err
+	}
+	found.ID, found.Webhook, found.Redelivery = objectID(id), objectID(webhook), objectID(redelivery)
+	found.NextAttempt, found.LeasedUntil = nullTimeOf(next), nullTimeOf(leased)
+	found.CreatedAt, found.UpdatedAt = timeOf(created), timeOf(updated)
+	*d = found
+	return nil
+}
+
+// listDeliveries returns the deliveries matching where in order
+func (d *sqlDB) listDeliveries(where, order string, args ...interface{}) ([]Delivery, error) {
+	found := []Delivery{}
+	err := d.list(d.db, deliveriesTable.selectFrom()+where+" ORDER BY "+order, func(row sqlScanner) error {
+		delivery := Delivery{}
+		err := scanDelivery(row, &delivery)
+		found = append(found, delivery)
+		return err
+	}, args...)
+	return found, err
+}
+
+func (r sqlPersons) Insert(p *Person) error {
+	return r.d.transact(func(tx *sql.Tx) error {
+		return r.d.insertPerson(tx, p)
+	})
+}
+
+func (r sqlPersons) Find(id bson.ObjectId, p *Person) error {
+	return r.d.findPerson(r.d.db, "id = ?", p, id.Hex())
+}
+
+func (r sqlPersons) FindByEmail(email string, p *Person) error {
+	return r.d.findPerson(r.d.db, "email = ?", p, email)
+}
+
+func (r sqlPersons) FindByInviteToken(hash string, p *Person) error {
+	return r.d.findPerson(r.d.db, "invite_token = ?", p, hash)
+}
+
+func (r sqlPersons) FindByFeedToken(hash string, p *Person) error {
+	return r.d.findPerson(r.d.db, "feed_token = ?", p, hash)
+}
+
+func (r sqlPersons) FindAll(ids []bson.ObjectId) ([]Person, error) {
+	if len(ids) == 0 {
+		return []Person{}, nil
+	}
+	return r.d.listPersons(" WHERE id IN ("+placeholders(len(ids))+")", "last_name, first_name", hexIDs(ids)...)
+}
+
+func (r sqlPersons) FindEnrollments() ([]Person, error) {
+	return r.d.listPersons("", "id")
+}
+
+func (r sqlPersons) EmailTaken(email string, except bson.ObjectId) (bool, error) {
+	n, err := r.d.count("persons", "email = ? AND id <> ?", email, except.Hex())
+	return n > 0, err
+}
+
+func (r sqlPersons) UpdateNames(id bson.ObjectId, first, last string) error {
+	return r.d.exec(r.d.db, "UPDATE persons SET first_name = ?, last_name = ? WHERE id = ?", first, last, id.Hex())
+}
+
+// sqlPersonFilter returns the conditions selecting the persons of filter
+func sqlPersonFilter(filter PersonFilter) ([]string, []interface{}) {
+	conditions := []string{"deactivated_at IS NULL"}
+	args := []interface{}{}
+	if filter.Deactivated {
+		conditions[0] = "deactivated_at IS NOT NULL"
+	}
+	if filter.Role != "" {
+		conditions = append(conditions, "role = ?")
+		args = append(args, filter.Role)
+	}
+	if filter.Search != "" {
+		condition, search := sqlSearch(filter.Search, "first_name || ' ' || last_name", "email")
+		conditions = append(conditions, condition)
+		args = append(args, search...)
+	}
+	return conditions, args
+}
+
+func (r sqlPersons) FindByFilter(filter PersonFilter, opts listOptions) ([]Person, error) {
+	conditions, args := sqlPersonFilter(filter)
+	clause, order, args := r.d.page(conditions, args, opts)
+	return r.d.listPersons(clause, order, args...)
+}
+
+func (r sqlPersons) CountByFilter(filter PersonFilter) (int, error) {
+	conditions, args := sqlPersonFilter(filter)
+	return r.d.count("persons", strings.Join(conditions, " AND "), args...)
+}
+
+func (r sqlPersons) UpdateProfile(id bson.ObjectId, first, last, email string) error {
+	return r.d.exec(r.d.db, "UPDATE persons SET first_name = ?, last_name = ?, email = ? WHERE id = ?", first, last, email, id.Hex())
+}
+
+func (r sqlPersons) UpdatePassword(id bson.ObjectId, password string) error {
+	return r.d.exec(r.d.db, "UPDATE persons SET password = ? WHERE id = ?", password, id.Hex())
+}
+
+func (r sqlPersons) SetDeactivated(id bson.ObjectId, t *time.Time) error {
+	return r.d.exec(r.d.db, "UPDATE persons SET deactivated_at = ? WHERE id = ?", sqlNullTime(t), id.Hex())
+}
+
+func (r sqlPersons) UpdateRole(id bson.ObjectId, role string) error {
+	return r.d.exec(r.d.db, "UPDATE persons SET role = ? WHERE id = ?", role, id.Hex())
+}
+
+func (r sqlPersons) UpdateFeedToken(id bson.ObjectId, hash string) error {
+	return r.d.exec(r.d.db, "UPDATE persons SET feed_token = ? WHERE id = ?", hash, id.Hex())
+}
+
+func (r sqlPersons) UpdateResetToken(id bson.ObjectId, hash string, expires *time.Time) error {
+	return r.d.exec(r.d.db, "UPDATE persons SET reset_token = ?, reset_expires = ? WHERE id = ?", hash, sqlNullTime(expires), id.Hex())
+}
+
+func (r sqlPersons) UpdateInviteToken(id bson.ObjectId, hash string, invited *time.Time) error {
+	return r.d.exec(r.d.db, "UPDATE persons SET invite_token = ?, invited_at = ? WHERE id = ?", hash, sqlNullTime(invited), id.Hex())
+}
+
+func (r sqlPersons) AcceptInvite(id bson.ObjectId, password string) error {
+	return r.d.exec(r.d.db, "UPDATE persons SET password = ?, invite_token = '', invited_at = NULL WHERE id = ?", password, id.Hex())
+}
+
+func (r sqlPersons) ResetPassword(hash, password string, t time.Time, p *Person) error {
+	found := Person{}
+	err := r.d.transact(func(tx *sql.Tx) error {
+		err := r.d.findPerson(tx, "reset_token = ? AND reset_expires > ?", &found, hash, sqlTime(t))
+		if err != nil {
+			return err
+		}
+		found.Password = password
+		found.ResetToken = ""
+		found.ResetExpires = nil
+		found.InviteToken = ""
+		found.InvitedAt = nil
+		return r.d.replace(tx, personsTable, personValues(&found))
+	})
+	if err == nil {
+		*p = found
+	}
+	return err
+}
+
+func (r sqlPersons) AddClass(id, class bson.ObjectId) error {
+	return r.d.transact(func(tx *sql.Tx) error {
+		n := 0
+		err := tx.QueryRow(r.d.rebind("SELECT COUNT(*) FROM persons WHERE id = ?"), id.Hex()).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrNotFound
+		}
+		return r.d.enroll(tx, class, []bson.ObjectId{id})
+	})
+}
+
+func (r sqlPersons) RemoveClass(id, class bson.ObjectId) error {
+	_, err := r.d.db.Exec(r.d.rebind("DELETE FROM enrollments WHERE class = ? AND student = ?"), class.Hex(), id.Hex())
+	return sqlError(err)
+}
+
+func (r sqlClasses) Insert(c *Class) error {
+	return r.d.transact(func(tx *sql.Tx) error {
+		return r.d.insertClass(tx, c)
+	})
+}
+
+func (r sqlClasses) Find(id bson.ObjectId, c *Class) error {
+	found := Class{}
+	err := r.d.get(r.d.db, classesTable.selectFrom()+" WHERE id = ?", func(row sqlScanner) error {
+		return scanClass(row, &found)
+	}, id.Hex())
+	if err != nil {
+		return err
+	}
+	students, err := r.d.enrolled(r.d.db, "class", []bson.ObjectId{id})
+	if err != nil {
+		return err
+	}
+	found.Students = append([]bson.ObjectId{}, students[id]...)
+	*c = found
+	return nil
+}
+
+func (r sqlClasses) FindAll(ids []bson.ObjectId) ([]Class, error) {
+	if len(ids) == 0 {
+		return []Class{}, nil
+	}
+	return r.d.listClasses(" WHERE id IN ("+placeholders(len(ids))+")", "id", hexIDs(ids)...)
+}
+
+func (r sqlClasses) FindByInstructor(instructor bson.ObjectId) ([]Class, error) {
+	return r.d.listClasses(" WHERE instructor = ?", "id", instructor.Hex())
+}
+
+func (r sqlClasses) FindEnrollments() ([]Class, error) {
+	return r.d.listClasses("", "id")
+}
+
+func (r sqlClasses) CountByTerm(term bson.ObjectId) (int, error) {
+	return r.d.count("classes", "term = ?", term.Hex())
+}
+
+// sqlClassFilter returns the conditions selecting the classes of filter
+func sqlClassFilter(filter ClassFilter) ([]string, []interface{}) {
+	conditions := []string{"archived_at IS NULL"}
+	args := []interface{}{}
+	if filter.Archived {
+		conditions[0] = "archived_at IS NOT NULL"
+	}
+	if filter.Instructor != "" {
+		conditions = append(conditions, "instructor = ?")
+		args = append(args, filter.Instructor.Hex())
+	}
+	if filter.Term != "" {
+		conditions = append(conditions, "term = ?")
+		args = append(args, filter.Term.Hex())
+	}
+	if filter.Location != "" {
+		conditions = append(conditions, "location_id = ?")
+		args = append(args, filter.Location.Hex())
+	}
+	if filter.IDs != nil && len(filter.IDs) == 0 {
+		conditions = append(conditions, "1 = 0")
+	} else if filter.IDs != nil {
+		conditions = append(conditions, "id IN ("+placeholders(len(filter.IDs))+")")
+		args = append(args, hexIDs(filter.IDs)...)
+	}
+	if filter.Search != "" {
+		condition, search := sqlSearch(filter.Search, "title", "location")
+		conditions = append(conditions, condition)
+		args = append(args, search...)
+	}
+	return conditions, args
+}
+
+func (r sqlClasses) FindByFilter(filter ClassFilter, opts listOptions) ([]Class, error) {
+	conditions, args := sqlClassFilter(filter)
+	clause, order, args := r.d.page(conditions, args, opts)
+	return r.d.listClasses(clause, order, args...)
+}
+
+func (r sqlClasses) CountByFilter(filter ClassFilter) (int, error) {
+	conditions, args := sqlClassFilter(filter)
+	return r.d.count("classes", strings.Join(conditions, " AND "), args...)
+}
+
+func (r sqlClasses) FindMissing(ids []bson.ObjectId) ([]bson.ObjectId, error) {
+	found := map[bson.ObjectId]bool{}
+	for start := 0; start < len(ids); start += enrollmentBatch {
+		batch := ids[start:]
+		if len(batch) > enrollmentBatch {
+			batch = batch[:enrollmentBatch]
+		}
+		err := r.d.list(r.d.db, "SELECT id FROM classes WHERE id IN ("+placeholders(len(batch))+")", func(row sqlScanner) error {
+			id := ""
+			err := row.Scan(&id)
+			found[objectID(id)] = true
+			return err
+		}, hexIDs(batch)...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	missing := []bson.ObjectId{}
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
+
+func (r sqlClasses) Update(c *Class, version int) error {
+	var archived interface{}
+	if c.ArchivedAt != nil {
+		archived = sqlTime(*c.ArchivedAt)
+	}
+	err := r.d.exec(r.d.db, `UPDATE classes SET title = ?, instructor = ?, start_time = ?, end_time = ?, start_date = ?, end_date = ?,
+		location = ?, location_id = ?, timezone = ?, term = ?, archived_at = ?, version = ? WHERE id = ? AND version = ?`,
+		c.Title, c.Instructor.Hex(), sqlTime(c.StartTime), sqlTime(c.EndTime), sqlTime(c.StartDate), sqlTime(c.EndDate),
+		c.Location, c.LocationID.Hex(), c.Timezone, c.Term.Hex(), archived, version+1, c.ID.Hex(), version)
+	if err == nil {
+		c.Version = version + 1
+	}
+	return err
+}
+
+func (r sqlClasses) UpdateSchedule(id bson.ObjectId, timezone string, schedule *Schedule) error {
+	var value interface{}
+	if schedule != nil {
+		value = sqlJSON(schedule)
+	}
+	return r.d.updateClass(r.d.db, id, "timezone = ?, schedule = ?", timezone, value)
+}
+
+func (r sqlClasses) UpdatePolicy(id bson.ObjectId, policy Policy) error {
+	return r.d.updateClass(r.d.db, id, "grace_period = ?, late_cutoff = ?", policy.GracePeriod, policy.LateCutoff)
+}
+
+func (r sqlClasses) UpdateCode(id bson.ObjectId, secret string, period int) error {
+	return r.d.updateClass(r.d.db, id, "code_secret = ?, code_period = ?", secret, period)
+}
+
+func (r sqlClasses) AddStudent(id, student bson.ObjectId) error {
+	return r.d.transact(func(tx *sql.Tx) error {
+		err := r.d.updateClass(tx, id, "")
+		if err != nil {
+			return err
+		}
+		return r.d.enroll(tx, id, []bson.ObjectId{student})
+	})
+}
+
+func (r sqlClasses) RemoveStudent(id, student bson.ObjectId) error {
+	return r.d.transact(func(tx *sql.Tx) error {
+		err := r.d.updateClass(tx, id, "")
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(r.d.rebind("DELETE FROM enrollments WHERE class = ? AND student = ?"), id.Hex(), student.Hex())
+		return sqlError(err)
+	})
+}
+
+func (r sqlAttendance) Insert(a *Attendance) error {
+	return r.d.insert(r.d.db, attendanceTable, attendanceValues(a))
+}
+
+func (r sqlAttendance) UpdateStatus(id bson.ObjectId, status string) error {
+	return r.d.exec(r.d.db, "UPDATE attendance SET status = ? WHERE id = ?", status, id.Hex())
+}
+
+func (r sqlAttendance) SetStatus(class, student bson.ObjectId, session, status string) error {
+	a := Attendance{ID: bson.NewObjectId(), Class: class, Student: student, Session: session, Status: status}
+	query := "INSERT INTO attendance (" + strings.Join(attendanceTable.columns, ", ") + ") VALUES (" + placeholders(len(attendanceTable.columns)) + ")" +
+		" ON CONFLICT (class, session, student) DO UPDATE SET status = excluded.status"
+	_, err := r.d.db.Exec(r.d.rebind(query), attendanceValues(&a)...)
+	return sqlError(err)
+}
+
+func (r sqlAttendance) FindByClass(class bson.ObjectId) ([]Attendance, error) {
+	return r.d.listAttendance(" WHERE class = ?", class.Hex())
+}
+
+func (r sqlAttendance) FindByStudent(student bson.ObjectId) ([]Attendance, error) {
+	return r.d.listAttendance(" WHERE student = ?", student.Hex())
+}
+
+func (r sqlExcuses) Insert(e *Excuse) error {
+	return r.d.insert(r.d.db, excusesTable, excuseValues(e))
+}
+
+func (r sqlExcuses) Find(id bson.ObjectId, e *Excuse) error {
+	return r.d.get(r.d.db, excusesTable.selectFrom()+" WHERE id = ?", func(row sqlScanner) error {
+		return scanExcuse(row, e)
+	}, id.Hex())
+}
+
+func (r sqlExcuses) UpdateStatus(id bson.ObjectId, from string, event ExcuseEvent) error {
+	return r.d.transact(func(tx *sql.Tx) error {
+		e := Excuse{}
+		err := r.d.get(tx, excusesTable.selectFrom("attachment_data")+" WHERE id = ? AND status = ?", func(row sqlScanner) error {
+			return scanExcuse(row, &e)
+		}, id.Hex(), from)
+		if err != nil {
+			return err
+		}
+		e.Status = event.Status
+		e.Open = e.openKey()
+		e.UpdatedAt = event.At
+		e.History = append(e.History, event)
+		return r.d.exec(tx, "UPDATE excuses SET status = ?, open_key = ?, updated_at = ?, history = ? WHERE id = ?",
+			e.Status, sqlNull(e.Open), sqlTime(e.UpdatedAt), sqlJSON(e.History), id.Hex())
+	})
+}
+
+func (r sqlExcuses) FindAll(filter ExcuseFilter) ([]Excuse, error) {
+	found := []Excuse{}
+	conditions := []string{}
+	args := []interface{}{}
+	if filter.Classes != nil {
+		if len(filter.Classes) == 0 {
+			return found, nil
+		}
+		conditions = append(conditions, "class IN ("+placeholders(len(filter.Classes))+")")
+		args = append(args, hexIDs(filter.Classes)...)
+	}
+	if filter.Student != "" {
+		conditions = append(conditions, "student = ?")
+		args = append(args, filter.Student.Hex())
+	}
+	if filter.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, filter.Status)
+	}
+
+	// leave out attachment data, which is only read by id
+	query := excusesTable.selectFrom("attachment_data") + where(conditions) + " ORDER BY created_at DESC"
+	err := r.d.list(r.d.db, query, func(row sqlScanner) error {
+		e := Excuse{}
+		err := scanExcuse(row, &e)
+		found = append(found, e)
+		return err
+	}, args...)
+	return found, err
+}
+
+func (r sqlLocations) Insert(l *Location) error {
+	return r.d.insert(r.d.db, locationsTable, locationValues(l))
+}
+
+func (r sqlLocations) Find(id bson.ObjectId, l *Location) error {
+	return r.d.get(r.d.db, locationsTable.selectFrom()+" WHERE id = ?", func(row sqlScanner) error {
+		return scanLocation(row, l)
+	}, id.Hex())
+}
+
+func (r sqlLocations) Update(l *Location) error {
+	return r.d.replace(r.d.db, locationsTable, locationValues(l))
+}
+
+func (r sqlLocations) FindAll() ([]Location, error) {
+	found := []Location{}
+	err := r.d.list(r.d.db, locationsTable.selectFrom()+" ORDER BY building, name", func(row sqlScanner) error {
+		l := Location{}
+		err := scanLocation(row, &l)
+		found = append(found, l)
+		return err
+	})
+	return found, err
+}
+
+func (r sqlTerms) Insert(t *Term) error {
+	return r.d.insert(r.d.db, termsTable, termValues(t))
+}
+
+func (r sqlTerms) Find(id bson.ObjectId, t *Term) error {
+	return r.d.get(r.d.db, termsTable.selectFrom()+" WHERE id = ?", func(row sqlScanner) error {
+		return scanTerm(row, t)
+	}, id.Hex())
+}
+
+func (r sqlTerms) Update(t *Term) error {
+	return r.d.replace(r.d.db, termsTable, termValues(t))
+}
+
+func (r sqlTerms) Delete(id bson.ObjectId) error {
+	return r.d.exec(r.d.db, "DELETE FROM terms WHERE id = ?", id.Hex())
+}
+
+func (r sqlTerms) FindAll() ([]Term, error) {
+	found := []Term{}
+	err := r.d.list(r.d.db, termsTable.selectFrom()+" ORDER BY start_date DESC", func(row sqlScanner) error {
+		t := Term{}
+		err := scanTerm(row, &t)
+		found = append(found, t)
+		return err
+	})
+	return found, err
+}
+
+func (r sqlWebhooks) Insert(w *Webhook) error {
+	return r.d.insert(r.d.db, webhooksTable, webhookValues(w))
+}
+
+func (r sqlWebhooks) Find(id bson.ObjectId, w *Webhook) error {
+	return r.d.get(r.d.db, webhooksTable.selectFrom()+" WHERE id = ?", func(row sqlScanner) error {
+		return scanWebhook(row, w)
+	}, id.Hex())
+}
+
+func (r sqlWebhooks) Update(w *Webhook) error {
+	return r.d.replace(r.d.db, webhooksTable, webhookValues(w))
+}
+
+func (r sqlWebhooks) Delete(id bson.ObjectId) error {
+	return r.d.exec(r.d.db, "DELETE FROM webhooks WHERE id = ?", id.Hex())
+}
+
+func (r sqlWebhooks) FindAll() ([]Webhook, error) {
+	found := []Webhook{}
+	err := r.d.list(r.d.db, webhooksTable.selectFrom()+" ORDER BY id", func(row sqlScanner) error {
+		w := Webhook{}
+		err := scanWebhook(row, &w)
+		found = append(found, w)
+		return err
+	})
+	return found, err
+}
+
+func (r sqlDeliveries) Insert(d *Delivery) error {
+	return r.d.insert(r.d.db, deliveriesTable, deliveryValues(d))
+}
+
+func (r sqlDeliveries) Find(id bson.ObjectId, d *Delivery) error {
+	return r.d.get(r.d.db, deliveriesTable.selectFrom()+" WHERE id = ?", func(row sqlScanner) error {
+		return scanDelivery(row, d)
+	}, id.Hex())
+}
+
+func (r sqlDeliveries) Update(d *Delivery) error {
+	return r.d.replace(r.d.db, deliveriesTable, deliveryValues(d))
+}
+
+func (r sqlDeliveries) Claim(id bson.ObjectId, attempts int, t, until time.Time) error {
+	return r.d.exec(r.d.db, `UPDATE deliveries SET leased_until = ?
+		WHERE id = ? AND status = ? AND attempts = ? AND (leased_until IS NULL OR leased_until <= ?)`,
+		sqlTime(until), id.Hex(), DeliveryPending, attempts, sqlTime(t))
+}
+
+func (r sqlDeliveries) FindByWebhook(webhook bson.ObjectId) ([]Delivery, error) {
+	return r.d.listDeliveries(" WHERE webhook = ?", "created_at DESC, id DESC", webhook.Hex())
+}
+
+func (r sqlDeliveries) FindPending() ([]Delivery, error) {
+	return r.d.listDeliveries(" WHERE status = ?", "id", DeliveryPending)
+}
+
+func (r sqlDeliveries) DeleteByWebhook(webhook bson.ObjectId) error {
+	_, err := r.d.db.Exec(r.d.rebind("DELETE FROM deliveries WHERE webhook = ?"), webhook.Hex())
+	return sqlError(err)
+}
+
+func (r sqlLogins) Insert(l *Login) error {
+	return r.d.insert(r.d.db, loginsTable, loginValues(l))
+}
+
+func (r sqlLogins) Find(id bson.ObjectId, l *Login) error {
+	return r.d.get(r.d.db, loginsTable.selectFrom()+" WHERE id = ?", func(row sqlScanner) error {
+		return scanLogin(row, l)
+	}, id.Hex())
+}
+
+func (r sqlLogins) FindByToken(hash string, t time.Time, l *Login) error {
+	return r.d.get(r.d.db, loginsTable.selectFrom()+" WHERE token_hash = ? AND revoked_at IS NULL AND expires_at > ?", func(row sqlScanner) error {
+		return scanLogin(row, l)
+	}, hash, sqlTime(t))
+}
+
+func (r sqlLogins) Renew(l *Login, previous string) error {
+	return r.d.exec(r.d.db, "UPDATE logins SET token_hash = ?, used_at = ?, expires_at = ? WHERE id = ? AND token_hash = ? AND revoked_at IS NULL",
+		l.TokenHash, sqlTime(l.UsedAt), sqlTime(l.ExpiresAt), l.ID.Hex(), previous)
+}
+
+func (r sqlLogins) Revoke(id bson.ObjectId, t time.Time) error {
+	return r.d.exec(r.d.db, "UPDATE logins SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", sqlTime(t), id.Hex())
+}
+
+func (r sqlLogins) RevokeAll(person bson.ObjectId, t time.Time) (int, error) {
+	res, err := r.d.db.Exec(r.d.rebind("UPDATE logins SET revoked_at = ? WHERE person = ? AND revoked_at IS NULL"), sqlTime(t), person.Hex())
+	if err != nil {
+		return 0, sqlError(err)
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,89 @@
+package attendance
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	_ "modernc.org/sqlite"
+)
+
+func TestSQLMigrationsMoveDocumentsIntoColumns(t *testing.T) {
+	database, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "classmate.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	database.SetMaxOpenConns(1)
+
+	// migrate to the version before filter columns
+	latest := sqlMigrations
+	sqlMigrations = latest[:4]
+	_, err = NewSQLStore(database, "sqlite")
+	sqlMigrations = latest
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// save rows as they were saved at that version
+	now := time.Now()
+	person := Person{ID: bson.NewObjectId(), Email: "ada@example.com", FirstName: "Ada", Role: RoleStudent, DeactivatedAt: &now}
+	class := Class{ID: bson.NewObjectId(), Title: "Algebra", Instructor: bson.NewObjectId(), LocationID: bson.NewObjectId(), ArchivedAt: &now,
+		Schedule: &Schedule{RRule: "FREQ=WEEKLY;BYDAY=MO"}}
+	person.Classes = []bson.ObjectId{class.ID}
+	class.Students = []bson.ObjectId{person.ID}
+	for _, row := range []struct {
+		query string
+		args  []interface{}
+		doc   interface{}
+	}{
+		{`INSERT INTO persons (id, email, role, first_name, last_name, invite_token, feed_token, reset_token, doc) VALUES (?, ?, ?, '', '', '', '', '', ?)`,
+			[]interface{}{person.ID.Hex(), person.Email, person.Role}, &person},
+		{`INSERT INTO classes (id, instructor, term, doc) VALUES (?, ?, '', ?)`,
+			[]interface{}{class.ID.Hex(), class.Instructor.Hex()}, &class},
+	} {
+		data, err := bson.Marshal(row.doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := database.Exec(row.query, append(row.args, data)...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// filters query the backfilled columns
+	store, err := NewSQLStore(database, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	persons, err := store.Persons.FindByFilter(PersonFilter{Deactivated: true}, listOptions{})
+	if err != nil || len(persons) != 1 || persons[0].ID != person.ID {
+		t.Fatalf("deactivated persons = %v, %v", persons, err)
+	}
+	if persons, _ := store.Persons.FindByFilter(PersonFilter{}, listOptions{}); len(persons) != 0 {
+		t.Fatalf("active persons = %v", persons)
+	}
+	classes, err := store.Classes.FindByFilter(ClassFilter{Location: class.LocationID, Archived: true}, listOptions{})
+	if err != nil || len(classes) != 1 || classes[0].ID != class.ID {
+		t.Fatalf("archived classes = %v, %v", classes, err)
+	}
+	if classes, _ := store.Classes.FindByFilter(ClassFilter{Location: class.LocationID}, listOptions{}); len(classes) != 0 {
+		t.Fatalf("unarchived classes = %v", classes)
+	}
+
+	// documents are copied into columns and enrollments
+	found := Person{}
+	if err := store.Persons.Find(person.ID, &found); err != nil || found.FirstName != "Ada" || len(found.Classes) != 1 || found.Classes[0] != class.ID {
+		t.Fatalf("person = %+v, %v", found, err)
+	}
+	copied := Class{}
+	if err := store.Classes.Find(class.ID, &copied); err != nil || copied.Title != "Algebra" || len(copied.Students) != 1 ||
+		copied.Schedule == nil || copied.Schedule.RRule != class.Schedule.RRule {
+		t.Fatalf("class = %+v, %v", copied, err)
+	}
+	if _, err := database.Exec(`SELECT 1 FROM documents_persons`); err == nil {
+		t.Fatal("documents_persons was not dropped")
+	}
+}
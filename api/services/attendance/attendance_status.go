@@ -0,0 +1,87 @@
+package attendance
+
+import (
+	"fmt"
+	"time"
+)
+
+// attendance statuses of a student for a session
+const (
+	StatusPresent = "present"
+	StatusLate    = "late"
+	StatusAbsent  = "absent"
+	StatusExcused = "excused"
+)
+
+// Validate ensures the grace period and late cutoff are consistent
+func (p Policy) Validate() error {
+	if p.GracePeriod < 0 || p.LateCutoff < 0 {
+		return fmt.Errorf("Grace period and late cutoff can not be negative")
+	}
+	if p.LateCutoff > 0 && p.LateCutoff < p.GracePeriod {
+		return fmt.Errorf("Late cutoff must be after the grace period")
+	}
+	return nil
+}
+
+// Status returns the status of a check in at t to session. Check ins within
+// the grace period are present, after it late and after the late cutoff
+// absent. A zero late cutoff allows late check in until the session ends.
+func (p Policy) Status(session Session, t time.Time) string {
+	if !t.After(session.Start.Add(time.Duration(p.GracePeriod) * time.Minute)) {
+		return StatusPresent
+	}
+	if p.LateCutoff > 0 && t.After(session.Start.Add(time.Duration(p.LateCutoff)*time.Minute)) {
+		return StatusAbsent
+	}
+	return StatusLate
+}
+
+// RecomputeAttendance recomputes the status of every check in to the class
+// under its current policy and schedule, returning the number of records
+// changed. Excused records are left as they are.
+func (c *Class) RecomputeAttendance() (int, error) {
+	sessions, err := c.Sessions(c.StartDate, c.EndDate.AddDate(0, 0, 1))
+	if err != nil {
+		return 0, err
+	}
+	byID := map[string]Session{}
+	for _, session := range sessions {
+		byID[session.ID] = session
+	}
+
+	records, err := FindClassAttendance(c.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for _, record := range records {
+		session, ok := byID[record.Session]
+		if !ok || record.Status == StatusExcused {
+			continue
+		}
+		status := c.Policy.Status(session, record.CheckedIn)
+		if status == record.Status {
+			continue
+		}
+		record.Status = status
+		err = record.UpdateStatus()
+		if err != nil {
+			return changed, err
+		}
+		publishEvent(EventStatusChanged, c.ID, record)
+		changed++
+	}
+
+	return changed, nil
+}
+
+// statusOf returns the status of a record, treating records
+// made before statuses existed as present
+func statusOf(record Attendance) string {
+	if record.Status == "" {
+		return StatusPresent
+	}
+	return record.Status
+}
@@ -0,0 +1,60 @@
+package attendance
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPolicyStatus(t *testing.T) {
+	start := time.Date(2026, 9, 7, 9, 0, 0, 0, time.UTC)
+	session := Session{ID: "2026-09-07", Start: start, End: start.Add(time.Hour)}
+	policy := Policy{GracePeriod: 5, LateCutoff: 15}
+
+	// grace and cutoff are inclusive to the instant
+	for _, c := range []struct {
+		after time.Duration
+		want  string
+	}{
+		{-10 * time.Minute, StatusPresent},
+		{0, StatusPresent},
+		{5 * time.Minute, StatusPresent},
+		{5*time.Minute + time.Nanosecond, StatusLate},
+		{15 * time.Minute, StatusLate},
+		{15*time.Minute + time.Nanosecond, StatusAbsent},
+		{2 * time.Hour, StatusAbsent},
+	} {
+		if got := policy.Status(session, start.Add(c.after)); got != c.want {
+			t.Errorf("Status() %s after start = %s, want %s", c.after, got, c.want)
+		}
+	}
+
+	// without a grace period only check ins at the start are present,
+	// and without a cutoff check ins are late however late they are
+	open := Policy{}
+	for after, want := range map[time.Duration]string{
+		0:                StatusPresent,
+		time.Nanosecond:  StatusLate,
+		59 * time.Minute: StatusLate,
+		3 * time.Hour:    StatusLate,
+	} {
+		if got := open.Status(session, start.Add(after)); got != want {
+			t.Errorf("Status() %s after start without policy = %s, want %s", after, got, want)
+		}
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	for policy, valid := range map[Policy]bool{
+		{}:                               true,
+		{GracePeriod: 5}:                 true,
+		{GracePeriod: 5, LateCutoff: 5}:  true,
+		{GracePeriod: 5, LateCutoff: 15}: true,
+		{GracePeriod: 10, LateCutoff: 5}: false,
+		{GracePeriod: -1}:                false,
+		{LateCutoff: -1}:                 false,
+	} {
+		if err := policy.Validate(); (err == nil) != valid {
+			t.Errorf("Validate(%+v) = %v, want valid %t", policy, err, valid)
+		}
+	}
+}
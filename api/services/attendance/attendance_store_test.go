@@ -0,0 +1,369 @@
+package attendance
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	_ "modernc.org/sqlite"
+)
+
+// testStores returns a new store of each backend. Mongo is only
+// tested when MONGODB_TEST_URI points at a server, in a database
+// that is dropped when the test ends.
+func testStores(t *testing.T) map[string]Store {
+	database, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "classmate.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	database.SetMaxOpenConns(1)
+	sqlite, err := NewSQLStore(database, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]Store{"memory": NewMemoryStore(), "sqlite": sqlite}
+
+	if uri := os.Getenv("MONGODB_TEST_URI"); uri != "" {
+		session, err := mgo.DialWithTimeout(uri, 3*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mongo := session.DB("classmate_test_" + bson.NewObjectId().Hex())
+		t.Cleanup(func() {
+			mongo.DropDatabase()
+			session.Close()
+		})
+		stores["mongo"], err = NewMgoStore(mongo)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return stores
+}
+
+func TestPersonRepositoryContract(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			persons := store.Persons
+			ada := Person{ID: bson.NewObjectId(), Email: "ada@example.com", FirstName: "Ada", LastName: "Lovelace", Role: RoleStudent, Classes: []bson.ObjectId{}}
+			grace := Person{ID: bson.NewObjectId(), Email: "grace@example.com", FirstName: "Grace", LastName: "Hopper", Role: RoleTeacher, Classes: []bson.ObjectId{}}
+			for _, p := range []*Person{&ada, &grace} {
+				if err := persons.Insert(p); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// emails are unique
+			again := Person{ID: bson.NewObjectId(), Email: ada.Email, Role: RoleStudent}
+			if err := persons.Insert(&again); err != ErrDuplicate {
+				t.Fatalf("Insert() of a taken email = %v, want ErrDuplicate", err)
+			}
+			taken, err := persons.EmailTaken(ada.Email, grace.ID)
+			if err != nil || !taken {
+				t.Fatalf("EmailTaken() = %v, %v", taken, err)
+			}
+			if taken, _ := persons.EmailTaken(ada.Email, ada.ID); taken {
+				t.Fatal("EmailTaken() counts the person excepted")
+			}
+
+			// finds fill the person or leave it untouched
+			found := Person{}
+			if err := persons.FindByEmail(grace.Email, &found); err != nil || found.ID != grace.ID || found.LastName != "Hopper" {
+				t.Fatalf("FindByEmail() = %+v, %v", found, err)
+			}
+			missing := Person{FirstName: "Unchanged"}
+			if err := persons.Find(bson.NewObjectId(), &missing); err != ErrNotFound || missing.FirstName != "Unchanged" {
+				t.Fatalf("Find() of a missing person = %v, %+v", err, missing)
+			}
+			all, err := persons.FindAll([]bson.ObjectId{ada.ID, grace.ID, bson.NewObjectId()})
+			if err != nil || len(all) != 2 {
+				t.Fatalf("FindAll() = %d persons, %v", len(all), err)
+			}
+
+			// filters select by role and deactivation
+			now := time.Now()
+			if err := persons.SetDeactivated(grace.ID, &now); err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range []struct {
+				filter PersonFilter
+				want   bson.ObjectId
+			}{
+				{PersonFilter{}, ada.ID},
+				{PersonFilter{Role: RoleStudent}, ada.ID},
+				{PersonFilter{Deactivated: true}, grace.ID},
+			} {
+				found, err := persons.FindByFilter(c.filter, listOptions{})
+				if err != nil || len(found) != 1 || found[0].ID != c.want {
+					t.Errorf("FindByFilter(%+v) = %v, %v", c.filter, found, err)
+				}
+			}
+
+			// classes are a set
+			class := bson.NewObjectId()
+			for i := 0; i < 2; i++ {
+				if err := persons.AddClass(ada.ID, class); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := persons.Find(ada.ID, &found); err != nil || len(found.Classes) != 1 || found.Classes[0] != class {
+				t.Fatalf("classes after AddClass() = %v, %v", found.Classes, err)
+			}
+			if err := persons.RemoveClass(ada.ID, class); err != nil {
+				t.Fatal(err)
+			}
+			if err := persons.Find(ada.ID, &found); err != nil || len(found.Classes) != 0 {
+				t.Fatalf("classes after RemoveClass() = %v, %v", found.Classes, err)
+			}
+		})
+	}
+}
+
+func TestClassRepositoryContract(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			classes := store.Classes
+			location := bson.NewObjectId()
+			class := Class{ID: bson.NewObjectId(), Title: "Algebra", Instructor: bson.NewObjectId(), LocationID: location, Students: []bson.ObjectId{}}
+			if err := classes.Insert(&class); err != nil {
+				t.Fatal(err)
+			}
+			if err := classes.Insert(&class); err != ErrDuplicate {
+				t.Fatalf("Insert() again = %v, want ErrDuplicate", err)
+			}
+
+			// updates apply at the version they are based on only
+			class.Title = "Linear algebra"
+			if err := classes.Update(&class, 0); err != nil || class.Version != 1 {
+				t.Fatalf("Update() = %v at version %d", err, class.Version)
+			}
+			if err := classes.Update(&class, 0); err != ErrNotFound {
+				t.Fatalf("Update() of a stale version = %v, want ErrNotFound", err)
+			}
+
+			// every other write moves the version on
+			student := bson.NewObjectId()
+			for _, write := range []func() error{
+				func() error { return classes.AddStudent(class.ID, student) },
+				func() error { return classes.UpdatePolicy(class.ID, Policy{GracePeriod: 5}) },
+				func() error { return classes.UpdateCode(class.ID, "SECRET", 60) },
+				func() error { return classes.UpdateSchedule(class.ID, "UTC", &Schedule{Weekdays: []string{"MO"}}) },
+			} {
+				if err := write(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			found := Class{}
+			if err := classes.Find(class.ID, &found); err != nil {
+				t.Fatal(err)
+			}
+			if found.Title != "Linear algebra" || found.Version != 5 || len(found.Students) != 1 ||
+				found.Policy.GracePeriod != 5 || found.CodePeriod != 60 || found.Timezone != "UTC" {
+				t.Fatalf("class = %+v", found)
+			}
+			if err := classes.RemoveStudent(class.ID, student); err != nil {
+				t.Fatal(err)
+			}
+			if err := classes.Find(class.ID, &found); err != nil || len(found.Students) != 0 || found.Version != 6 {
+				t.Fatalf("class after RemoveStudent() = %+v, %v", found, err)
+			}
+
+			// filters select by location and archival
+			if found, err := classes.FindByFilter(ClassFilter{Location: location}, listOptions{}); err != nil || len(found) != 1 {
+				t.Fatalf("FindByFilter() = %v, %v", found, err)
+			}
+			if found, err := classes.FindByFilter(ClassFilter{Location: location, Archived: true}, listOptions{}); err != nil || len(found) != 0 {
+				t.Fatalf("FindByFilter() of archived = %v, %v", found, err)
+			}
+		})
+	}
+}
+
+func TestClassListingsPageInStores(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			// titles tie in lower case, which ids then order
+			start := time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC)
+			titles := []string{"b", "A", "c", "a", "B"}
+			ids := make([]bson.ObjectId, len(titles))
+			for i, title := range titles {
+				ids[i] = bson.NewObjectId()
+				class := Class{ID: ids[i], Title: title, Instructor: bson.NewObjectId(), StartDate: start.AddDate(0, 0, -i), Students: []bson.ObjectId{}}
+				if err := store.Classes.Insert(&class); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, c := range []struct {
+				sort string
+				want []int
+			}{
+				{"title", []int{1, 3, 0, 4, 2}},
+				{"-title", []int{2, 4, 0, 3, 1}},
+				{"start_date", []int{4, 3, 2, 1, 0}},
+			} {
+				got := []int{}
+				query := "limit=2&sort=" + c.sort
+				for pages := 0; pages < len(titles); pages++ {
+					opts, err := parseListOptions(listContext(query), []string{"title", "start_date"}, "title")
+					if err != nil {
+						t.Fatal(err)
+					}
+					found, err := store.Classes.FindByFilter(ClassFilter{}, opts.peek())
+					if err != nil {
+						t.Fatal(err)
+					}
+					key := classSorts[strings.TrimPrefix(opts.sort, "-")]
+					n, pagination := opts.paginate(len(titles), len(found), func(i int) (string, bson.ObjectId) {
+						return key(&found[i]), found[i].ID
+					})
+					for _, class := range found[:n] {
+						for i, id := range ids {
+							if class.ID == id {
+								got = append(got, i)
+							}
+						}
+					}
+					if pagination.NextCursor == "" {
+						break
+					}
+					query = "limit=2&sort=" + c.sort + "&cursor=" + pagination.NextCursor
+				}
+				if !reflect.DeepEqual(got, c.want) {
+					t.Errorf("sort %s pages through %v, want %v", c.sort, got, c.want)
+				}
+			}
+
+			// filters select by id and search in any case
+			filter := ClassFilter{IDs: []bson.ObjectId{ids[0], ids[1], ids[4]}, Search: "b"}
+			if n, err := store.Classes.CountByFilter(filter); err != nil || n != 2 {
+				t.Fatalf("CountByFilter() = %d, %v", n, err)
+			}
+			if n, err := store.Classes.CountByFilter(ClassFilter{IDs: []bson.ObjectId{}}); err != nil || n != 0 {
+				t.Fatalf("CountByFilter() of no ids = %d, %v", n, err)
+			}
+			missing := bson.NewObjectId()
+			if found, err := store.Classes.FindMissing([]bson.ObjectId{ids[2], missing}); err != nil || len(found) != 1 || found[0] != missing {
+				t.Fatalf("FindMissing() = %v, %v", found, err)
+			}
+		})
+	}
+}
+
+func TestPersonListingsSearchAndPageInStores(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			names := [][2]string{{"Ada", "Lovelace"}, {"Grace", "Hopper"}, {"alan", "turing"}, {"Ada", "King"}}
+			ids := make([]bson.ObjectId, len(names))
+			for i, n := range names {
+				ids[i] = bson.NewObjectId()
+				person := Person{ID: ids[i], Email: strings.ToLower(n[0]+"."+n[1]) + "@example.com", FirstName: n[0], LastName: n[1], Role: RoleStudent, Classes: []bson.ObjectId{}}
+				if err := store.Persons.Insert(&person); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// last names sort in any case, ties on first names
+			got := []bson.ObjectId{}
+			query := "limit=3"
+			for pages := 0; pages < len(names); pages++ {
+				opts, err := parseListOptions(listContext(query), []string{"last_name", "first_name", "email"}, "last_name")
+				if err != nil {
+					t.Fatal(err)
+				}
+				found, err := store.Persons.FindByFilter(PersonFilter{}, opts.peek())
+				if err != nil {
+					t.Fatal(err)
+				}
+				n, pagination := opts.paginate(len(names), len(found), func(i int) (string, bson.ObjectId) {
+					return personSorts["last_name"](&found[i]), found[i].ID
+				})
+				for _, p := range found[:n] {
+					got = append(got, p.ID)
+				}
+				if pagination.NextCursor == "" {
+					break
+				}
+				query = "limit=3&cursor=" + pagination.NextCursor
+			}
+			if want := []bson.ObjectId{ids[1], ids[3], ids[0], ids[2]}; !reflect.DeepEqual(got, want) {
+				t.Errorf("persons by last name = %v, want %v", got, want)
+			}
+
+			// searches match full names and emails
+			for search, want := range map[string]int{"ada l": 1, "ada": 2, "turing@": 1, "100%": 0} {
+				if n, err := store.Persons.CountByFilter(PersonFilter{Search: search}); err != nil || n != want {
+					t.Errorf("CountByFilter(%q) = %d, %v, want %d", search, n, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAttendanceRepositoryContract(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			records := store.Attendance
+			record := Attendance{ID: bson.NewObjectId(), Class: bson.NewObjectId(), Student: bson.NewObjectId(), Session: "2026-03-02", Status: StatusPresent}
+			if err := records.Insert(&record); err != nil {
+				t.Fatal(err)
+			}
+
+			// a student has one record per session
+			again := record
+			again.ID = bson.NewObjectId()
+			if err := records.Insert(&again); err != ErrDuplicate {
+				t.Fatalf("Insert() of a second record = %v, want ErrDuplicate", err)
+			}
+
+			// setting a status updates the record or creates it
+			if err := records.SetStatus(record.Class, record.Student, record.Session, StatusExcused); err != nil {
+				t.Fatal(err)
+			}
+			if err := records.SetStatus(record.Class, record.Student, "2026-03-03", StatusExcused); err != nil {
+				t.Fatal(err)
+			}
+			found, err := records.FindByStudent(record.Student)
+			if err != nil || len(found) != 2 {
+				t.Fatalf("FindByStudent() = %v, %v", found, err)
+			}
+			for _, r := range found {
+				if r.Status != StatusExcused {
+					t.Errorf("record of %s is %s, want excused", r.Session, r.Status)
+				}
+			}
+		})
+	}
+}
+
+func TestDeliveryClaimedOnce(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			delivery := Delivery{ID: bson.NewObjectId(), Webhook: bson.NewObjectId(), Status: DeliveryPending, CreatedAt: now}
+			if err := store.Deliveries.Insert(&delivery); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := store.Deliveries.Claim(delivery.ID, 0, now, now.Add(time.Minute)); err != nil {
+				t.Fatalf("Claim() = %v", err)
+			}
+			if err := store.Deliveries.Claim(delivery.ID, 0, now, now.Add(time.Minute)); err != ErrNotFound {
+				t.Fatalf("Claim() while leased = %v, want ErrNotFound", err)
+			}
+
+			// an expired lease can be claimed again
+			later := now.Add(2 * time.Minute)
+			if err := store.Deliveries.Claim(delivery.ID, 0, later, later.Add(time.Minute)); err != nil {
+				t.Fatalf("Claim() after the lease = %v", err)
+			}
+		})
+	}
+}
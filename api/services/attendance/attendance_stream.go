@@ -0,0 +1,146 @@
+package attendance
+
+import (
+	"log"
+	"time"
+
+	"github.com/edwintcloud/classmate/api/services/server"
+	"github.com/globalsign/mgo/bson"
+)
+
+// types of attendance events. Check ins after the grace period are
+// published as late, and changes to existing records as status changed.
+const (
+	EventCheckedIn     = "attendance.checked_in"
+	EventLate          = "attendance.late"
+	EventStatusChanged = "attendance.status_changed"
+)
+
+// StatusPending is the roster status of a student who has not
+// checked in to a session yet
+const StatusPending = "pending"
+
+// streamBuffer is the number of events a stream may fall behind by
+// before it is dropped, and streamHeartbeat how often idle streams
+// are written to so proxies keep them open
+const (
+	streamBuffer    = 64
+	streamHeartbeat = 15 * time.Second
+)
+
+// RosterEntry is the attendance of a student in a session
+type RosterEntry struct {
+	Student   bson.ObjectId `json:"student"`
+	FirstName string        `json:"first_name"`
+	LastName  string        `json:"last_name"`
+	Email     string        `json:"email"`
+	Status    string        `json:"status"`
+	CheckedIn *time.Time    `json:"checked_in,omitempty"`
+}
+
+// RosterSnapshot is the attendance of every student of a session
+// when a stream starts
+type RosterSnapshot struct {
+	Class   bson.ObjectId  `json:"class"`
+	Session Session        `json:"session"`
+	Roster  []RosterEntry  `json:"roster"`
+	Counts  *SessionCounts `json:"counts"`
+}
+
+// classTopic is the event topic of a class
+func classTopic(class bson.ObjectId) string {
+	return "class:" + class.Hex()
+}
+
+// publishEvent publishes an event of type about class, queueing its
+// webhook deliveries first so none are lost to slow subscribers
+func publishEvent(eventType string, class bson.ObjectId, data interface{}) {
+	event := server.Event{
+		ID:    bson.NewObjectId().Hex(),
+		Type:  eventType,
+		Topic: classTopic(class),
+		Time:  time.Now(),
+		Data:  data,
+	}
+	if dispatcher != nil {
+		err := dispatcher.Deliver(event)
+		if err != nil {
+			log.Println("Unable to deliver webhook event:", err.Error())
+		}
+	}
+	s.Events.Publish(event)
+}
+
+// publishCheckIn publishes a new attendance record as checked in or late
+func publishCheckIn(record Attendance) {
+	eventType := EventCheckedIn
+	if record.Status == StatusLate {
+		eventType = EventLate
+	}
+	publishEvent(eventType, record.Class, record)
+}
+
+// FindSession finds a session of the class by id, or the session
+// taking place at t if id is current
+func (c *Class) FindSession(id string, t time.Time) (Session, error) {
+	if id == "current" {
+		return c.CurrentSession(t)
+	}
+	sessions, err := c.Sessions(c.StartDate, c.EndDate.AddDate(0, 0, 1))
+	if err != nil {
+		return Session{}, err
+	}
+	for _, session := range sessions {
+		if session.ID == id {
+			return session, nil
+		}
+	}
+	return Session{}, ErrUnknownSession
+}
+
+// Roster builds the attendance of every student of a session, ordered
+// by last and first name. Students without a record are pending.
+func (c *Class) Roster(session Session) (*RosterSnapshot, error) {
+	snapshot := &RosterSnapshot{Class: c.ID, Session: session, Roster: []RosterEntry{}}
+
+	// find students and records of the session
+	students, err := FindPersons(c.Students)
+	if err != nil {
+		return nil, err
+	}
+	records, err := FindClassAttendance(c.ID)
+	if err != nil {
+		return nil, err
+	}
+	byStudent := map[bson.ObjectId]Attendance{}
+	for _, record := range records {
+		if record.Session == session.ID {
+			byStudent[record.Student] = record
+		}
+	}
+
+	for _, student := range students {
+		entry := RosterEntry{
+			Student:   student.ID,
+			FirstName: student.FirstName,
+			LastName:  student.LastName,
+			Email:     student.Email,
+			Status:    StatusPending,
+		}
+		if record, ok := byStudent[student.ID]; ok {
+			entry.Status = statusOf(record)
+			if !record.CheckedIn.IsZero() {
+				checkedIn := record.CheckedIn
+				entry.CheckedIn = &checkedIn
+			}
+		}
+		snapshot.Roster = append(snapshot.Roster, entry)
+	}
+
+	snapshot.Counts, err = CountSession(c, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
@@ -0,0 +1,118 @@
+package attendance
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/labstack/echo"
+)
+
+// streamEvent is an event read from a server sent event stream
+type streamEvent struct {
+	id, event, data string
+}
+
+// openStream opens the attendance stream of a class session as the
+// person of token, returning the response and a function reading the
+// next event of the stream
+func openStream(t *testing.T, e *echo.Echo, path, token string) (*http.Response, func() streamEvent) {
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+
+	lines := bufio.NewReader(res.Body)
+	return res, func() streamEvent {
+		event := streamEvent{}
+		for {
+			line, err := lines.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read stream: %v", err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "" && event.event != "":
+				return event
+			case strings.HasPrefix(line, "id: "):
+				event.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				event.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				event.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}
+}
+
+func TestStreamSessionAttendance(t *testing.T) {
+	e := testServer(t)
+	admin, _ := signup(t, e, "admin@example.com", RoleAdmin)
+	teacher, instructor := signup(t, e, "teacher@example.com", RoleTeacher)
+	student, person := signup(t, e, "student@example.com", RoleStudent)
+	id := createClass(t, e, admin, map[string]interface{}{"instructor": instructor.ID, "students": []string{person.ID.Hex()}})
+	path := "/api/v1/classes/" + id + "/sessions/current/stream"
+
+	// only the instructor streams attendance
+	if status, out := request(t, e, "GET", path, student, nil); status != 403 {
+		t.Fatalf("stream as student = %d %v, want 403", status, out)
+	}
+
+	// the stream starts with a snapshot of the roster
+	res, next := openStream(t, e, path, teacher)
+	if res.StatusCode != 200 || res.Header.Get(echo.HeaderContentType) != "text/event-stream" {
+		t.Fatalf("stream = %d %s", res.StatusCode, res.Header.Get(echo.HeaderContentType))
+	}
+	first := next()
+	snapshot := RosterSnapshot{}
+	if err := json.Unmarshal([]byte(first.data), &snapshot); first.event != "snapshot" || err != nil {
+		t.Fatalf("first event = %+v, %v", first, err)
+	}
+	if len(snapshot.Roster) != 1 || snapshot.Roster[0].Student != person.ID || snapshot.Roster[0].Status != StatusPending {
+		t.Fatalf("roster = %+v", snapshot.Roster)
+	}
+	if snapshot.Counts == nil || snapshot.Counts.Enrolled != 1 || snapshot.Counts.Pending != 1 {
+		t.Fatalf("counts = %+v", snapshot.Counts)
+	}
+
+	// events of other sessions are left out of the stream
+	publishCheckIn(Attendance{ID: bson.NewObjectId(), Class: bson.ObjectIdHex(id), Student: person.ID, Session: "2026-01-01", Status: StatusPresent})
+
+	// check ins follow the snapshot as they happen
+	status, out := request(t, e, "GET", "/api/v1/classes/"+id+"/code", teacher, nil)
+	if status != 200 {
+		t.Fatalf("code = %d %v", status, out)
+	}
+	status, out = request(t, e, "POST", "/api/v1/classes/"+id+"/checkin", student, map[string]string{"code": out["code"].(string)})
+	if status != 200 {
+		t.Fatalf("check in = %d %v", status, out)
+	}
+	event := next()
+	record := Attendance{}
+	if err := json.Unmarshal([]byte(event.data), &record); err != nil {
+		t.Fatal(err)
+	}
+	if event.event != EventCheckedIn && event.event != EventLate || event.id == "" {
+		t.Fatalf("event = %+v, want check in", event)
+	}
+	if record.Student != person.ID || record.Session != snapshot.Session.ID {
+		t.Fatalf("record = %+v, want check in of %s to %s", record, person.ID.Hex(), snapshot.Session.ID)
+	}
+}
@@ -0,0 +1,54 @@
+package attendance
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNoActiveTerm is returned when no term is taking place
+var ErrNoActiveTerm = errors.New("No term is active")
+
+// Active returns true if t falls on or between the
+// first and last day of the term
+func (t *Term) Active(at time.Time) bool {
+	return !at.Before(t.StartDate) && at.Before(t.EndDate.AddDate(0, 0, 1))
+}
+
+// FindActiveTerm finds the term taking place at t, preferring
+// the latest to start if terms overlap
+func FindActiveTerm(t time.Time) (*Term, error) {
+	terms, err := FindTerms()
+	if err != nil {
+		return nil, err
+	}
+	for i := range terms {
+		if terms[i].Active(t) {
+			return &terms[i], nil
+		}
+	}
+	return nil, ErrNoActiveTerm
+}
+
+// termBreaks returns the breaks of the term of the class, ignoring
+// terms that no longer exist
+func (c *Class) termBreaks() ([]Break, error) {
+	if c.Term == "" {
+		return nil, nil
+	}
+	term := Term{ID: c.Term}
+	err := term.Find()
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	return term.Breaks, err
+}
+
+// inBreak returns true if the date day falls in one of breaks
+func inBreak(breaks []Break, day string) bool {
+	for _, b := range breaks {
+		if day >= b.Start && day <= b.End {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,305 @@
+package attendance
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/edwintcloud/classmate/api/services/server"
+	"github.com/globalsign/mgo/bson"
+)
+
+// minPasswordLength is the shortest password accepted
+const minPasswordLength = 8
+
+// field error codes
+const (
+	CodeRequired = "required"
+	CodeInvalid  = "invalid"
+	CodeTaken    = "taken"
+	CodeWeak     = "weak"
+	CodeNotFound = "not_found"
+	CodeRole     = "invalid_role"
+)
+
+// Validate validates a new person, including that no
+// other person is registered with their email
+func (p *Person) Validate() error {
+	errs := server.ValidationErrors{}
+	err := p.validateProfile(&errs)
+	if err != nil {
+		return err
+	}
+	validatePassword(&errs, "password", p.Password)
+	return errs.Err()
+}
+
+// validateProfile adds errors to errs for invalid names and
+// an invalid or taken email
+func (p *Person) validateProfile(errs *server.ValidationErrors) error {
+
+	// validate names
+	if strings.TrimSpace(p.FirstName) == "" {
+		errs.Add("first_name", CodeRequired, "First name is required")
+	}
+	if strings.TrimSpace(p.LastName) == "" {
+		errs.Add("last_name", CodeRequired, "Last name is required")
+	}
+
+	// validate email
+	switch {
+	case p.Email == "":
+		errs.Add("email", CodeRequired, "Email is required")
+	case !validEmail(p.Email):
+		errs.Add("email", CodeInvalid, "Email is not a valid address")
+	default:
+		taken, err := EmailTaken(p.Email, p.ID)
+		if err != nil {
+			return err
+		}
+		if taken {
+			errs.Add("email", CodeTaken, "Email is already registered")
+		}
+	}
+
+	return nil
+}
+
+// Validate validates the fields of a class and that its
+// instructor and students exist
+func (c *Class) Validate() error {
+	errs := server.ValidationErrors{}
+
+	if strings.TrimSpace(c.Title) == "" {
+		errs.Add("title", CodeRequired, "Title is required")
+	}
+
+	// validate dates
+	if c.StartDate.IsZero() {
+		errs.Add("start_date", CodeRequired, "Start date is required")
+	}
+	if c.EndDate.IsZero() {
+		errs.Add("end_date", CodeRequired, "End date is required")
+	} else if c.EndDate.Before(c.StartDate) {
+		errs.Add("end_date", CodeInvalid, "End date must not be before start date")
+	}
+
+	// validate times of day
+	if c.StartTime.IsZero() {
+		errs.Add("start_time", CodeRequired, "Start time is required")
+	}
+	if c.EndTime.IsZero() {
+		errs.Add("end_time", CodeRequired, "End time is required")
+	} else if loc, err := c.timezone(); err == nil && secondOfDay(c.EndTime.In(loc)) <= secondOfDay(c.StartTime.In(loc)) {
+		// sessions take the wall clock of the times in the class timezone
+		errs.Add("end_time", CodeInvalid, "End time must be after start time in the class timezone")
+	}
+
+	// validate check in code, schedule and policy
+	if err := c.ValidateCodePeriod(); err != nil {
+		errs.Add("code_period", CodeInvalid, err.Error())
+	}
+	if err := c.ValidateSchedule(); err != nil {
+		errs.Add("schedule", CodeInvalid, err.Error())
+	}
+	if err := c.Policy.Validate(); err != nil {
+		errs.Add("policy", CodeInvalid, err.Error())
+	}
+
+	// ensure instructor is a teacher
+	if c.Instructor == "" {
+		errs.Add("instructor", CodeRequired, "Instructor is required")
+	} else {
+		instructor := Person{ID: c.Instructor}
+		err := instructor.Find()
+		switch {
+		case err != nil:
+			errs.Add("instructor", CodeNotFound, "Instructor not found")
+		case instructor.Role != RoleTeacher:
+			errs.Add("instructor", CodeRole, "Instructor must have the teacher role")
+		}
+	}
+
+	// ensure term exists
+	if c.Term != "" {
+		term := Term{ID: c.Term}
+		if err := term.Find(); err != nil {
+			errs.Add("term", CodeNotFound, "Term not found")
+		}
+	}
+
+	// ensure students exist
+	if len(c.Students) > 0 {
+		persons, err := FindPersons(c.Students)
+		if err != nil {
+			return err
+		}
+		found := map[string]bool{}
+		for _, person := range persons {
+			found[person.ID.Hex()] = true
+		}
+		for i, id := range c.Students {
+			if !found[id.Hex()] {
+				errs.Add(fmt.Sprintf("students[%d]", i), CodeNotFound, "Student "+id.Hex()+" not found")
+			}
+		}
+	}
+
+	return errs.Err()
+}
+
+// Validate validates the dates of a term and that its
+// breaks are valid ranges of days within it
+func (t *Term) Validate() error {
+	errs := server.ValidationErrors{}
+
+	if strings.TrimSpace(t.Name) == "" {
+		errs.Add("name", CodeRequired, "Name is required")
+	}
+
+	// validate dates
+	if t.StartDate.IsZero() {
+		errs.Add("start_date", CodeRequired, "Start date is required")
+	}
+	if t.EndDate.IsZero() {
+		errs.Add("end_date", CodeRequired, "End date is required")
+	} else if t.EndDate.Before(t.StartDate) {
+		errs.Add("end_date", CodeInvalid, "End date must not be before start date")
+	}
+
+	// validate breaks
+	first, last := t.StartDate.Format(dateLayout), t.EndDate.Format(dateLayout)
+	for i, b := range t.Breaks {
+		field := fmt.Sprintf("breaks[%d]", i)
+		_, startErr := time.Parse(dateLayout, b.Start)
+		_, endErr := time.Parse(dateLayout, b.End)
+		switch {
+		case startErr != nil:
+			errs.Add(field+".start", CodeInvalid, "Start must be a date in the form "+dateLayout)
+		case endErr != nil:
+			errs.Add(field+".end", CodeInvalid, "End must be a date in the form "+dateLayout)
+		case b.End < b.Start:
+			errs.Add(field+".end", CodeInvalid, "End must not be before start")
+		case b.Start < first || b.End > last:
+			errs.Add(field, CodeInvalid, "Break must be within the term")
+		}
+	}
+
+	return errs.Err()
+}
+
+// Validate validates the url and event types of a webhook
+func (w *Webhook) Validate() error {
+	errs := server.ValidationErrors{}
+
+	// validate url
+	u, err := url.Parse(w.URL)
+	switch {
+	case w.URL == "":
+		errs.Add("url", CodeRequired, "URL is required")
+	case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+		errs.Add("url", CodeInvalid, "URL must be an absolute http or https url")
+	}
+
+	// validate events
+	if len(w.Events) == 0 {
+		errs.Add("events", CodeRequired, "At least one event type is required")
+	}
+	for i, e := range w.Events {
+		known := false
+		for _, k := range webhookEvents {
+			known = known || k == e
+		}
+		if !known {
+			errs.Add(fmt.Sprintf("events[%d]", i), CodeInvalid, "Event type must be one of "+strings.Join(webhookEvents, ", "))
+		}
+	}
+
+	if strings.TrimSpace(w.Secret) == "" {
+		errs.Add("secret", CodeRequired, "Secret is required")
+	}
+
+	return errs.Err()
+}
+
+// normalizeEmail returns email as it is stored and looked up,
+// trimmed and in lower case
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// EmailConflict is an email several persons were saved with in
+// different cases, left as it is for an admin to resolve
+type EmailConflict struct {
+	Email   string          `json:"email"`
+	Persons []bson.ObjectId `json:"persons"`
+}
+
+// emailChanges returns the normalised email of each person saved before
+// emails were normalised, leaving out those whose emails would collide
+func emailChanges(persons []Person) (map[bson.ObjectId]string, []EmailConflict) {
+	byEmail := map[string][]Person{}
+	emails := []string{}
+	for _, p := range persons {
+		email := normalizeEmail(p.Email)
+		if _, ok := byEmail[email]; !ok {
+			emails = append(emails, email)
+		}
+		byEmail[email] = append(byEmail[email], p)
+	}
+
+	changes := map[bson.ObjectId]string{}
+	conflicts := []EmailConflict{}
+	for _, email := range emails {
+		same := byEmail[email]
+		if len(same) > 1 {
+			conflict := EmailConflict{Email: email}
+			for _, p := range same {
+				conflict.Persons = append(conflict.Persons, p.ID)
+			}
+			conflicts = append(conflicts, conflict)
+			continue
+		}
+		if same[0].Email != email {
+			changes[same[0].ID] = email
+		}
+	}
+	return changes, conflicts
+}
+
+// validEmail reports whether email is a bare address with a domain
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return false
+	}
+	at := strings.LastIndex(email, "@")
+	return at > 0 && strings.Contains(email[at+1:], ".")
+}
+
+// validatePassword adds an error to errs if password is too weak
+func validatePassword(errs *server.ValidationErrors, field, password string) {
+	letter, digit := false, false
+	for _, r := range password {
+		letter = letter || unicode.IsLetter(r)
+		digit = digit || unicode.IsDigit(r)
+	}
+
+	switch {
+	case password == "":
+		errs.Add(field, CodeRequired, "Password is required")
+	case len([]rune(password)) < minPasswordLength:
+		errs.Add(field, CodeWeak, fmt.Sprintf("Password must be at least %d characters", minPasswordLength))
+	case !letter || !digit:
+		errs.Add(field, CodeWeak, "Password must contain a letter and a number")
+	}
+}
+
+// secondOfDay returns the wall clock time of t in seconds after midnight
+func secondOfDay(t time.Time) int {
+	hour, min, sec := t.Clock()
+	return hour*3600 + min*60 + sec
+}
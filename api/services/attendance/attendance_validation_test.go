@@ -0,0 +1,92 @@
+package attendance
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	_ "modernc.org/sqlite"
+)
+
+func TestEmailsAreNormalised(t *testing.T) {
+	db = NewMemoryStore()
+	person := Person{FirstName: "Ada", LastName: "Lovelace", Email: "  Ada@Example.COM ", Password: "analytical1"}
+	if err := person.Create(); err != nil {
+		t.Fatal(err)
+	}
+	if person.Email != "ada@example.com" {
+		t.Fatalf("email = %q, want ada@example.com", person.Email)
+	}
+
+	// lookups ignore case
+	found := Person{Email: "ADA@example.com"}
+	if err := found.Find(); err != nil || found.ID != person.ID {
+		t.Fatalf("Find() = %v", err)
+	}
+	if err := found.Authenticate("analytical1"); err != nil {
+		t.Fatalf("Authenticate() = %v", err)
+	}
+	taken, err := EmailTaken("ada@EXAMPLE.com", "")
+	if err != nil || !taken {
+		t.Fatalf("EmailTaken() = %v, %v", taken, err)
+	}
+
+	// registering again in another case fails
+	again := Person{FirstName: "Ada", LastName: "King", Email: "ADA@EXAMPLE.COM", Password: "analytical1"}
+	if err := again.Create(); err == nil {
+		t.Fatal("Create() registered an email twice")
+	}
+}
+
+func TestSQLMigrationNormalisesSavedEmailsOnce(t *testing.T) {
+	database, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "classmate.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	database.SetMaxOpenConns(1)
+
+	// save persons as emails were saved before they were normalised
+	latest := sqlMigrations
+	sqlMigrations = latest[:6]
+	store, err := NewSQLStore(database, "sqlite")
+	sqlMigrations = latest
+	if err != nil {
+		t.Fatal(err)
+	}
+	grace := Person{ID: bson.NewObjectId(), Email: "Grace@Example.com", Role: RoleStudent}
+	ada := Person{ID: bson.NewObjectId(), Email: "Ada@Example.com", Role: RoleStudent}
+	again := Person{ID: bson.NewObjectId(), Email: "ada@example.com", Role: RoleStudent}
+	for _, p := range []*Person{&grace, &ada, &again} {
+		if err := store.Persons.Insert(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// conflicting emails are left as they are instead of failing
+	store, err = NewSQLStore(database, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []Person{{ID: grace.ID, Email: "grace@example.com"}, {ID: ada.ID, Email: ada.Email}, {ID: again.ID, Email: again.Email}} {
+		found := Person{}
+		if err := store.Persons.Find(want.ID, &found); err != nil || found.Email != want.Email {
+			t.Fatalf("email = %q, %v, want %q", found.Email, err, want.Email)
+		}
+	}
+
+	// later opens leave emails alone
+	alan := Person{ID: bson.NewObjectId(), Email: "Alan@Example.com", Role: RoleStudent}
+	if err := store.Persons.Insert(&alan); err != nil {
+		t.Fatal(err)
+	}
+	store, err = NewSQLStore(database, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := Person{}
+	if err := store.Persons.Find(alan.ID, &found); err != nil || found.Email != alan.Email {
+		t.Fatalf("email = %q, %v, want %q", found.Email, err, alan.Email)
+	}
+}
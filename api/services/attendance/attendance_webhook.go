@@ -0,0 +1,446 @@
+package attendance
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edwintcloud/classmate/api/services/server"
+	"github.com/globalsign/mgo/bson"
+)
+
+// delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// headers of webhook requests. The timestamp is the unix time in seconds
+// of the attempt and the signature the hex HMAC-SHA256, keyed with the
+// webhook secret, of the timestamp and request body joined by a dot.
+const (
+	HeaderWebhookEvent     = "X-Classmate-Event"
+	HeaderWebhookDelivery  = "X-Classmate-Delivery"
+	HeaderWebhookTimestamp = "X-Classmate-Timestamp"
+	HeaderWebhookSignature = "X-Classmate-Signature"
+)
+
+// WebhookTolerance is how far the timestamp of a request may be from
+// the time it is received. Receivers should reject requests signed
+// further from their clock, which are replays of captured requests
+// unless the clocks drifted apart. Retries are signed anew, so
+// rejected requests are not lost.
+const WebhookTolerance = 5 * time.Minute
+
+// webhookEvents are the types of events webhooks can subscribe to
+var webhookEvents = []string{
+	EventCheckedIn,
+	EventLate,
+	EventStatusChanged,
+	EventEnrolled,
+	EventUnenrolled,
+	EventClassUpdated,
+}
+
+// defaults of the dispatcher, which WEBHOOK_MAX_ATTEMPTS and
+// WEBHOOK_RETRY_DELAY override
+const (
+	defaultWebhookAttempts = 8
+	defaultWebhookDelay    = 30 * time.Second
+	maxWebhookDelay        = 6 * time.Hour
+	defaultWebhookLease    = time.Minute
+	defaultWebhookSweep    = time.Minute
+	webhookWorkers         = 4
+	webhookBuffer          = 1024
+	webhookEventBuffer     = 1024
+)
+
+// Dispatcher posts events to the webhooks subscribed to them, logging
+// each delivery and retrying failed attempts after BaseDelay, doubling
+// the delay after each attempt up to MaxDelay, until MaxAttempts.
+//
+// Deliveries of published events are stored in the background and each
+// attempt is claimed in the store for Lease before posting, so replicas
+// sharing a store never post an attempt twice. Every Sweep the store is
+// searched for due deliveries, such as those of replicas that stopped.
+type Dispatcher struct {
+	Client      *http.Client
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Lease       time.Duration
+	Sweep       time.Duration
+
+	events chan server.Event
+	queue  chan bson.ObjectId
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// WebhookPayload is the body posted to webhooks
+type WebhookPayload struct {
+	ID   string      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// dispatcher delivers the events of the service
+var dispatcher *Dispatcher
+
+// NewDispatcher returns a dispatcher configured by WEBHOOK_MAX_ATTEMPTS
+// and WEBHOOK_RETRY_DELAY, a duration such as 30s
+func NewDispatcher() *Dispatcher {
+	d := &Dispatcher{
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: defaultWebhookAttempts,
+		BaseDelay:   defaultWebhookDelay,
+		MaxDelay:    maxWebhookDelay,
+		Lease:       defaultWebhookLease,
+		Sweep:       defaultWebhookSweep,
+	}
+	if value := os.Getenv("WEBHOOK_MAX_ATTEMPTS"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			log.Fatalln("Invalid WEBHOOK_MAX_ATTEMPTS:", value)
+		}
+		d.MaxAttempts = n
+	}
+	if value := os.Getenv("WEBHOOK_RETRY_DELAY"); value != "" {
+		delay, err := time.ParseDuration(value)
+		if err != nil || delay <= 0 {
+			log.Fatalln("Invalid WEBHOOK_RETRY_DELAY:", value)
+		}
+		d.BaseDelay = delay
+	}
+	return d
+}
+
+// Start attempts deliveries in the background until Stop is called,
+// resuming deliveries left pending when the server last stopped
+func (d *Dispatcher) Start() {
+	d.events = make(chan server.Event, webhookEventBuffer)
+	d.queue = make(chan bson.ObjectId, webhookBuffer)
+	d.done = make(chan struct{})
+	d.wg.Add(webhookWorkers + 2)
+	for i := 0; i < webhookWorkers; i++ {
+		go d.work()
+	}
+	go d.record()
+	go d.sweep()
+}
+
+// Stop stops attempting deliveries once those being attempted finish,
+// leaving the others pending for the next start. Deliveries of the
+// events still queued are stored before it returns.
+func (d *Dispatcher) Stop() {
+	close(d.done)
+	d.wg.Wait()
+}
+
+// sweep schedules the pending deliveries of the store now and
+// every Sweep after
+func (d *Dispatcher) sweep() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(d.Sweep)
+	defer ticker.Stop()
+	for {
+		pending, err := FindPendingDeliveries()
+		if err != nil {
+			log.Println("Unable to find pending webhook deliveries:", err.Error())
+		}
+		now := time.Now()
+		for _, delivery := range pending {
+			if delivery.claimable(delivery.Attempts, now) {
+				d.schedule(delivery.ID, delivery.due())
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// Deliver queues an event to have its deliveries created in the
+// background, so publishing does not wait on the store. Events are
+// delivered as they are published rather than from the event bus,
+// which drops subscribers that fall behind, and the deliveries of
+// events that do not fit the queue, or of a dispatcher that is not
+// started, are created before Deliver returns.
+func (d *Dispatcher) Deliver(event server.Event) error {
+	select {
+	case d.events <- event:
+		return nil
+	default:
+		return d.store(event)
+	}
+}
+
+// record creates the deliveries of queued events until Stop is
+// called, then of those still queued
+func (d *Dispatcher) record() {
+	defer d.wg.Done()
+	for {
+		select {
+		case event := <-d.events:
+			d.recordEvent(event)
+		case <-d.done:
+			for {
+				select {
+				case event := <-d.events:
+					d.recordEvent(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// recordEvent creates the deliveries of a queued event
+func (d *Dispatcher) recordEvent(event server.Event) {
+	err := d.store(event)
+	if err != nil {
+		log.Println("Unable to store webhook deliveries:", err.Error())
+	}
+}
+
+// store creates and schedules a delivery of an event for every
+// webhook subscribed to it
+func (d *Dispatcher) store(event server.Event) error {
+	webhooks, err := FindWebhooks()
+	if err != nil {
+		return err
+	}
+
+	// encode payload once for every webhook
+	var payload []byte
+	for _, webhook := range webhooks {
+		if !webhook.Subscribed(event.Type) {
+			continue
+		}
+		if payload == nil {
+			payload, err = json.Marshal(WebhookPayload{ID: event.ID, Type: event.Type, Time: event.Time, Data: event.Data})
+			if err != nil {
+				return err
+			}
+		}
+		delivery := Delivery{
+			Webhook: webhook.ID,
+			Event:   event.ID,
+			Type:    event.Type,
+			Payload: string(payload),
+		}
+		err = delivery.Create(event.Time)
+		if err != nil {
+			return err
+		}
+		d.schedule(delivery.ID, event.Time)
+	}
+
+	return nil
+}
+
+// Redeliver creates a new delivery repeating delivery and schedules it
+func (d *Dispatcher) Redeliver(delivery *Delivery, t time.Time) (*Delivery, error) {
+	redelivery := Delivery{
+		Webhook:    delivery.Webhook,
+		Event:      delivery.Event,
+		Type:       delivery.Type,
+		Payload:    delivery.Payload,
+		Redelivery: delivery.ID,
+	}
+	err := redelivery.Create(t)
+	if err != nil {
+		return nil, err
+	}
+	d.schedule(redelivery.ID, t)
+	return &redelivery, nil
+}
+
+// schedule queues a delivery to be attempted at t
+func (d *Dispatcher) schedule(id bson.ObjectId, t time.Time) {
+	time.AfterFunc(time.Until(t), func() {
+		select {
+		case d.queue <- id:
+		case <-d.done:
+		}
+	})
+}
+
+// work attempts queued deliveries
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for {
+		select {
+		case id := <-d.queue:
+			err := d.attempt(id, time.Now())
+			if err != nil {
+				log.Println("Unable to attempt webhook delivery:", err.Error())
+			}
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// attempt posts a pending delivery to its webhook at t and records
+// the outcome, scheduling a retry if it failed and attempts remain.
+// Deliveries that are not due, or claimed by another attempt, are
+// left alone.
+func (d *Dispatcher) attempt(id bson.ObjectId, t time.Time) error {
+	delivery := Delivery{ID: id}
+	err := delivery.Find()
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !delivery.claimable(delivery.Attempts, t) || delivery.due().After(t) {
+		return nil
+	}
+
+	// claim attempt so no other worker or replica posts it
+	err = delivery.Claim(t, t.Add(d.Lease))
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// fail deliveries of deleted webhooks
+	webhook := Webhook{ID: delivery.Webhook}
+	err = webhook.Find()
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if err == ErrNotFound {
+		delivery.Error = "Webhook was deleted"
+		delivery.Status = DeliveryFailed
+		delivery.NextAttempt = nil
+		delivery.LeasedUntil = nil
+		delivery.UpdatedAt = t
+		return delivery.Update()
+	}
+
+	// post payload and record result
+	delivery.ResponseStatus, err = d.post(&webhook, &delivery, t)
+	delivery.Attempts++
+	delivery.UpdatedAt = t
+	delivery.NextAttempt = nil
+	delivery.LeasedUntil = nil
+	delivery.Error = ""
+	switch {
+	case err == nil:
+		delivery.Status = DeliverySucceeded
+	case delivery.Attempts >= d.MaxAttempts:
+		delivery.Status = DeliveryFailed
+		delivery.Error = err.Error()
+	default:
+		next := t.Add(d.backoff(delivery.Attempts))
+		delivery.NextAttempt = &next
+		delivery.Error = err.Error()
+	}
+	err = delivery.Update()
+	if err != nil {
+		return err
+	}
+
+	if delivery.NextAttempt != nil {
+		d.schedule(delivery.ID, *delivery.NextAttempt)
+	}
+	return nil
+}
+
+// post posts the payload of delivery to webhook signed at t, returning
+// the response status and an error unless it was a 2xx
+func (d *Dispatcher) post(webhook *Webhook, delivery *Delivery, t time.Time) (int, error) {
+	req, err := http.NewRequest("POST", webhook.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Classmate-Webhook")
+	req.Header.Set(HeaderWebhookEvent, delivery.Type)
+	req.Header.Set(HeaderWebhookDelivery, delivery.ID.Hex())
+	req.Header.Set(HeaderWebhookTimestamp, strconv.FormatInt(t.Unix(), 10))
+	req.Header.Set(HeaderWebhookSignature, "sha256="+SignPayload(webhook.Secret, t.Unix(), []byte(delivery.Payload)))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// drain some of the body so the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("Webhook responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns the delay before the attempt after attempt n
+func (d *Dispatcher) backoff(n int) time.Duration {
+	delay := d.BaseDelay
+	for i := 1; i < n && delay < d.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > d.MaxDelay {
+		delay = d.MaxDelay
+	}
+	return delay
+}
+
+// SignPayload returns the hex HMAC-SHA256 of timestamp, a dot and payload
+// keyed with secret, which receivers compare to the signature header of
+// a delivery after checking its timestamp header is within
+// WebhookTolerance of their clock
+func SignPayload(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// due returns when a delivery is next to be attempted
+func (d *Delivery) due() time.Time {
+	if d.NextAttempt != nil {
+		return *d.NextAttempt
+	}
+	return d.CreatedAt
+}
+
+// claimable returns true if a delivery is pending after attempts
+// and not claimed by another attempt at t
+func (d *Delivery) claimable(attempts int, t time.Time) bool {
+	return d.Status == DeliveryPending && d.Attempts == attempts && (d.LeasedUntil == nil || !d.LeasedUntil.After(t))
+}
+
+// Subscribed returns true if the webhook receives events of eventType
+func (w *Webhook) Subscribed(eventType string) bool {
+	for _, e := range w.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
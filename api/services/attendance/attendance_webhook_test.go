@@ -0,0 +1,320 @@
+package attendance
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edwintcloud/classmate/api/services/server"
+	"github.com/globalsign/mgo/bson"
+)
+
+// receiver records the requests posted to a webhook, responding
+// with the next of its statuses or 200 once they run out
+type receiver struct {
+	mutex    sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   []string
+	times    []time.Time
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, string(body))
+	rc.times = append(rc.times, time.Now())
+	status := 200
+	if len(rc.statuses) > 0 {
+		status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+// count returns the number of requests received
+func (rc *receiver) count() int {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	return len(rc.requests)
+}
+
+// testWebhook creates a webhook subscribed to check ins posting to rc
+// in a memory store, returning it with a client for its server
+func testWebhook(t *testing.T, rc *receiver) (*Webhook, *http.Client) {
+	db = NewMemoryStore()
+	receiver := httptest.NewServer(rc)
+	t.Cleanup(receiver.Close)
+
+	webhook := &Webhook{URL: receiver.URL, Events: []string{EventCheckedIn}, Secret: "secret"}
+	if err := webhook.Create(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	return webhook, receiver.Client()
+}
+
+// testDispatcher starts a dispatcher retrying quickly against a memory
+// store with a webhook subscribed to check ins posting to rc
+func testDispatcher(t *testing.T, rc *receiver, attempts int) (*Dispatcher, *Webhook) {
+	webhook, client := testWebhook(t, rc)
+
+	d := NewDispatcher()
+	d.Client = client
+	d.MaxAttempts = attempts
+	d.BaseDelay = 40 * time.Millisecond
+	d.Sweep = time.Hour
+	d.Start()
+	t.Cleanup(d.Stop)
+	return d, webhook
+}
+
+// checkInEvent returns a check in event published now
+func checkInEvent() server.Event {
+	return server.Event{ID: bson.NewObjectId().Hex(), Type: EventCheckedIn, Time: time.Now(), Data: map[string]string{"student": "1"}}
+}
+
+// waitDelivery waits for the only delivery of webhook to leave pending
+func waitDelivery(t *testing.T, webhook *Webhook) Delivery {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		deliveries, err := FindDeliveries(webhook.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) > 0 && deliveries[0].Status != DeliveryPending {
+			return deliveries[0]
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("delivery still pending")
+	return Delivery{}
+}
+
+func TestDeliverySignature(t *testing.T) {
+	rc := &receiver{}
+	d, webhook := testDispatcher(t, rc, 3)
+
+	// events webhooks are not subscribed to are not delivered
+	other := checkInEvent()
+	other.Type = EventLate
+	if err := d.Deliver(other); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Deliver(checkInEvent()); err != nil {
+		t.Fatal(err)
+	}
+	delivery := waitDelivery(t, webhook)
+	if delivery.Status != DeliverySucceeded || delivery.Attempts != 1 || delivery.ResponseStatus != 200 {
+		t.Fatalf("delivery = %s after %d attempts (%d)", delivery.Status, delivery.Attempts, delivery.ResponseStatus)
+	}
+	if rc.count() != 1 {
+		t.Fatalf("received %d requests, want 1", rc.count())
+	}
+
+	// signature covers the timestamp of the attempt
+	req, body := rc.requests[0], rc.bodies[0]
+	timestamp, err := strconv.ParseInt(req.Header.Get(HeaderWebhookTimestamp), 10, 64)
+	if err != nil {
+		t.Fatalf("timestamp header = %q", req.Header.Get(HeaderWebhookTimestamp))
+	}
+	if sent := time.Unix(timestamp, 0); time.Since(sent) > WebhookTolerance || time.Until(sent) > WebhookTolerance {
+		t.Errorf("timestamp = %s, want about now", sent)
+	}
+	if got, want := req.Header.Get(HeaderWebhookSignature), "sha256="+SignPayload("secret", timestamp, []byte(body)); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	if replayed := "sha256=" + SignPayload("secret", timestamp-600, []byte(body)); req.Header.Get(HeaderWebhookSignature) == replayed {
+		t.Errorf("signature does not depend on timestamp")
+	}
+	if got := req.Header.Get(HeaderWebhookEvent); got != EventCheckedIn {
+		t.Errorf("event header = %q", got)
+	}
+	if got := req.Header.Get(HeaderWebhookDelivery); got != delivery.ID.Hex() {
+		t.Errorf("delivery header = %q, want %q", got, delivery.ID.Hex())
+	}
+	if body != delivery.Payload {
+		t.Errorf("body = %s, want payload %s", body, delivery.Payload)
+	}
+}
+
+func TestDeliveryRetriesWithBackoff(t *testing.T) {
+	rc := &receiver{statuses: []int{500, 503}}
+	d, webhook := testDispatcher(t, rc, 5)
+
+	if err := d.Deliver(checkInEvent()); err != nil {
+		t.Fatal(err)
+	}
+	delivery := waitDelivery(t, webhook)
+	if delivery.Status != DeliverySucceeded || delivery.Attempts != 3 {
+		t.Fatalf("delivery = %s after %d attempts, want succeeded after 3", delivery.Status, delivery.Attempts)
+	}
+	if delivery.Error != "" || delivery.NextAttempt != nil {
+		t.Errorf("succeeded delivery kept error %q or next attempt", delivery.Error)
+	}
+
+	// delays double after each failed attempt, counted from when
+	// the attempt started rather than when the request arrived
+	if gap := rc.times[1].Sub(rc.times[0]); gap < d.BaseDelay/2 {
+		t.Errorf("first retry after %s, want about %s", gap, d.BaseDelay)
+	}
+	if gap := rc.times[2].Sub(rc.times[1]); gap < 3*d.BaseDelay/2 {
+		t.Errorf("second retry after %s, want about %s", gap, 2*d.BaseDelay)
+	}
+}
+
+func TestDeliveryFailsAfterMaxAttempts(t *testing.T) {
+	rc := &receiver{statuses: []int{500, 500, 500, 500}}
+	d, webhook := testDispatcher(t, rc, 3)
+
+	if err := d.Deliver(checkInEvent()); err != nil {
+		t.Fatal(err)
+	}
+	delivery := waitDelivery(t, webhook)
+	if delivery.Status != DeliveryFailed || delivery.Attempts != 3 || delivery.ResponseStatus != 500 {
+		t.Fatalf("delivery = %s after %d attempts (%d), want failed after 3", delivery.Status, delivery.Attempts, delivery.ResponseStatus)
+	}
+	if delivery.Error == "" || delivery.NextAttempt != nil {
+		t.Errorf("failed delivery has error %q, next attempt %v", delivery.Error, delivery.NextAttempt)
+	}
+
+	// no attempts after the last
+	time.Sleep(10 * d.BaseDelay)
+	if rc.count() != 3 {
+		t.Fatalf("received %d requests, want 3", rc.count())
+	}
+}
+
+func TestRedeliver(t *testing.T) {
+	rc := &receiver{statuses: []int{500}}
+	d, webhook := testDispatcher(t, rc, 1)
+
+	if err := d.Deliver(checkInEvent()); err != nil {
+		t.Fatal(err)
+	}
+	failed := waitDelivery(t, webhook)
+	if failed.Status != DeliveryFailed {
+		t.Fatalf("delivery = %s, want failed", failed.Status)
+	}
+
+	redelivery, err := d.Redeliver(&failed, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for redelivery.Status == DeliveryPending && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		if err := redelivery.Find(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if redelivery.Status != DeliverySucceeded || redelivery.Redelivery != failed.ID || redelivery.Event != failed.Event {
+		t.Fatalf("redelivery = %+v", redelivery)
+	}
+	if rc.count() != 2 || rc.bodies[1] != rc.bodies[0] {
+		t.Fatalf("redelivery posted a different payload")
+	}
+
+	// the original delivery is left as it was
+	if err := failed.Find(); err != nil || failed.Status != DeliveryFailed {
+		t.Fatalf("original delivery = %s, %v", failed.Status, err)
+	}
+}
+
+func TestDeliveryAttemptedOnce(t *testing.T) {
+	rc := &receiver{}
+	webhook, client := testWebhook(t, rc)
+
+	// replicas sharing the store attempt the same delivery at once
+	delivery := Delivery{Webhook: webhook.ID, Event: "1", Type: EventCheckedIn, Payload: "{}"}
+	if err := delivery.Create(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		replica := NewDispatcher()
+		replica.Client = client
+		wg.Add(1)
+		go func(replica *Dispatcher) {
+			defer wg.Done()
+			if err := replica.attempt(delivery.ID, time.Now()); err != nil {
+				t.Error(err)
+			}
+		}(replica)
+	}
+	wg.Wait()
+
+	if rc.count() != 1 {
+		t.Fatalf("received %d requests, want 1", rc.count())
+	}
+	if err := delivery.Find(); err != nil || delivery.Status != DeliverySucceeded || delivery.LeasedUntil != nil {
+		t.Fatalf("delivery = %+v, %v", delivery, err)
+	}
+}
+
+func TestPublishStoresDeliveries(t *testing.T) {
+	rc := &receiver{}
+	d, webhook := testDispatcher(t, rc, 3)
+	dispatcher = d
+	s = &server.Server{Events: server.NewEventBus()}
+	t.Cleanup(func() { dispatcher = nil })
+
+	// deliveries are stored even without bus subscribers keeping up
+	sub := s.Events.Subscribe("", 1)
+	defer sub.Close()
+	for i := 0; i < 5; i++ {
+		publishEvent(EventCheckedIn, bson.NewObjectId(), nil)
+	}
+
+	// deliveries are stored in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries, err := FindDeliveries(webhook.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) == 5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stored %d deliveries, want 5", len(deliveries))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDeliverStoresQueuedEventsOnStop(t *testing.T) {
+	rc := &receiver{}
+	webhook, client := testWebhook(t, rc)
+
+	// events queued when the dispatcher stops are stored for the next start
+	d := NewDispatcher()
+	d.Client = client
+	d.Start()
+	for i := 0; i < 3; i++ {
+		if err := d.Deliver(checkInEvent()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Stop()
+	deliveries, err := FindDeliveries(webhook.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 3 {
+		t.Fatalf("stored %d deliveries, want 3", len(deliveries))
+	}
+
+	// dispatchers that are not started store deliveries at once
+	if err := NewDispatcher().Deliver(checkInEvent()); err != nil {
+		t.Fatal(err)
+	}
+	if deliveries, err = FindDeliveries(webhook.ID); err != nil || len(deliveries) != 4 {
+		t.Fatalf("stored %d deliveries, want 4 (%v)", len(deliveries), err)
+	}
+}
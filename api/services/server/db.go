@@ -1,14 +1,51 @@
 package server
 
 import (
+	"database/sql"
 	"log"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/globalsign/mgo"
+
+	// import sql drivers
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// storage backends
+const (
+	StorageMongo    = "mongo"
+	StorageMemory   = "memory"
+	StorageSQLite   = "sqlite"
+	StoragePostgres = "postgres"
 )
 
+// LoadStorage sets the storage backend from STORAGE,
+// defaulting to mongo
+func (s *Server) LoadStorage() {
+	s.Storage = os.Getenv("STORAGE")
+	switch s.Storage {
+	case "":
+		s.Storage = StorageMongo
+	case StorageMongo, StorageMemory, StorageSQLite, StoragePostgres:
+	default:
+		log.Fatalln("Unknown storage backend:", s.Storage)
+	}
+}
+
+// Close closes the log file and database connections of the server
+func (s *Server) Close() {
+	if s.Session != nil {
+		s.Session.Close()
+	}
+	if s.SQL != nil {
+		s.SQL.Close()
+	}
+	s.Log.Close()
+}
+
 // ConnectToDb connects to mongodb and sets session
 // so we can defer session close in main routine
 func (s *Server) ConnectToDb() {
@@ -27,3 +64,30 @@ func (s *Server) ConnectToDb() {
 	// set server db
 	s.Db = s.Session.DB(dbName)
 }
+
+// ConnectToSQL opens the sql database at DATABASE_URL, which
+// defaults to a classmate.db file when using sqlite
+func (s *Server) ConnectToSQL() {
+	var err error
+
+	// open database with driver of storage backend
+	driver, dsn := "postgres", os.Getenv("DATABASE_URL")
+	if s.Storage == StorageSQLite {
+		driver = "sqlite"
+		if dsn == "" {
+			dsn = "classmate.db"
+		}
+	}
+	s.SQL, err = sql.Open(driver, dsn)
+	if err == nil {
+		err = s.SQL.Ping()
+	}
+	if err != nil {
+		log.Fatalf("Unable to connect to database: %s", err.Error())
+	}
+
+	// sqlite allows one writer at a time
+	if s.Storage == StorageSQLite {
+		s.SQL.SetMaxOpenConns(1)
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is something that happened in a subsystem, published on the
+// topic of the resource it concerns so subscribers can select it
+type Event struct {
+	ID    string      `json:"id"`
+	Type  string      `json:"type"`
+	Topic string      `json:"topic"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+// EventBus delivers published events to subscribers within the process.
+// Publishing never blocks: a subscriber that falls behind by more events
+// than its buffer holds is dropped and its channel closed.
+type EventBus struct {
+	mutex sync.Mutex
+	subs  map[*Subscription]bool
+}
+
+// Subscription receives the events of a topic on C until it is
+// closed, or of every topic if its topic is empty
+type Subscription struct {
+	C <-chan Event
+
+	bus   *EventBus
+	topic string
+	c     chan Event
+}
+
+// NewEventBus returns an event bus without subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{subs: map[*Subscription]bool{}}
+}
+
+// Subscribe subscribes to the events of topic, buffering up to
+// buffer events the subscriber has not received yet
+func (b *EventBus) Subscribe(topic string, buffer int) *Subscription {
+	c := make(chan Event, buffer)
+	sub := &Subscription{C: c, bus: b, topic: topic, c: c}
+
+	b.mutex.Lock()
+	b.subs[sub] = true
+	b.mutex.Unlock()
+
+	return sub
+}
+
+// Publish delivers e to the subscribers of its topic, setting its
+// time if it has none
+func (b *EventBus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for sub := range b.subs {
+		if sub.topic != "" && sub.topic != e.Topic {
+			continue
+		}
+		select {
+		case sub.c <- e:
+		default:
+			// drop subscribers that fell behind
+			delete(b.subs, sub)
+			close(sub.c)
+		}
+	}
+}
+
+// Close unsubscribes, closing C if the subscription was not dropped
+func (s *Subscription) Close() {
+	s.bus.mutex.Lock()
+	defer s.bus.mutex.Unlock()
+
+	if s.bus.subs[s] {
+		delete(s.bus.subs, s)
+		close(s.c)
+	}
+}
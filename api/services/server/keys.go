@@ -0,0 +1,449 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+)
+
+// supported signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// ErrKeysPinned is returned when rotating keys set by JWT_SECRET
+var ErrKeysPinned = errors.New("Signing key is set by JWT_SECRET and cannot be rotated")
+
+// TokenAccess is the typ claim of access tokens, the only
+// tokens the middleware accepts as bearer tokens
+const TokenAccess = "access"
+
+// reloadInterval limits how often the key file is reloaded
+// when a token signed with an unknown key is seen
+const reloadInterval = time.Second * 10
+
+// SigningKey is a key tokens are signed and verified with
+type SigningKey struct {
+	ID         string    `json:"kid"`
+	Algorithm  string    `json:"alg"`
+	Secret     string    `json:"secret,omitempty"`
+	PrivateKey string    `json:"private_key,omitempty"`
+	Created    time.Time `json:"created"`
+
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// Keyring holds the keys tokens are signed and verified with. The newest
+// key signs new tokens while older keys still verify tokens issued before
+// a rotation. Keys are persisted in a key file so they survive restarts
+// and can be shared by replicas. A keyring set by JWT_SECRET holds just
+// that key, which replicas sharing the secret derive the same id for.
+type Keyring struct {
+	mutex      sync.RWMutex
+	keys       []*SigningKey
+	file       string
+	algorithm  string
+	retain     int
+	pinned     bool
+	lastReload time.Time
+}
+
+// keyFile is the format of the key file
+type keyFile struct {
+	Keys []*SigningKey `json:"keys"`
+}
+
+// LoadKeys loads the keyring from JWT_KEY_FILE, creating the file with
+// JWT_SECRET or a newly generated JWT_ALGORITHM key if it does not exist.
+// If JWT_SECRET is set the key file must hold just that key.
+func (s *Server) LoadKeys() {
+	s.Keys = &Keyring{
+		file:      os.Getenv("JWT_KEY_FILE"),
+		algorithm: os.Getenv("JWT_ALGORITHM"),
+		retain:    3,
+	}
+	if s.Keys.file == "" {
+		s.Keys.file = "jwt_keys.json"
+	}
+	if s.Keys.algorithm == "" {
+		s.Keys.algorithm = AlgHS256
+	}
+	if retain, err := strconv.Atoi(os.Getenv("JWT_RETAIN_KEYS")); err == nil && retain > 0 {
+		s.Keys.retain = retain
+	}
+
+	secret := os.Getenv("JWT_SECRET")
+	err := s.Keys.Reload()
+	if os.IsNotExist(err) {
+		err = s.Keys.create(secret)
+	}
+	if err == nil && secret != "" {
+		err = s.Keys.pin(secret)
+	}
+	if err != nil {
+		log.Fatalln("Unable to load signing keys:", err.Error())
+	}
+}
+
+// Reload replaces the keys of the keyring with those in the key file
+func (k *Keyring) Reload() error {
+	data, err := ioutil.ReadFile(k.file)
+	if err != nil {
+		return err
+	}
+	file := keyFile{}
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return err
+	}
+	if len(file.Keys) == 0 {
+		return errors.New("key file has no keys")
+	}
+	for _, key := range file.Keys {
+		err = key.parse()
+		if err != nil {
+			return fmt.Errorf("key %s: %s", key.ID, err.Error())
+		}
+	}
+
+	k.mutex.Lock()
+	k.keys = file.Keys
+	k.lastReload = time.Now()
+	k.mutex.Unlock()
+
+	return nil
+}
+
+// Rotate generates a new signing key, keeping the previous keys
+// for verification up to the retained number of keys. The key file is
+// reloaded first so keys rotated by other replicas are kept.
+func (k *Keyring) Rotate() (*SigningKey, error) {
+	if k.pinned {
+		return nil, ErrKeysPinned
+	}
+	key, err := GenerateKey(k.algorithm)
+	if err != nil {
+		return nil, err
+	}
+	err = k.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	keys := append([]*SigningKey{key}, k.keys...)
+	if len(keys) > k.retain {
+		keys = keys[:k.retain]
+	}
+	err = k.save(keys)
+	if err != nil {
+		return nil, err
+	}
+	k.keys = keys
+
+	return key, nil
+}
+
+// Sign signs claims with the newest key, setting the kid header
+func (k *Keyring) Sign(claims jwt.MapClaims) (string, error) {
+	k.mutex.RLock()
+	key := k.keys[0]
+	k.mutex.RUnlock()
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signKey)
+}
+
+// Parse verifies a token against the key named by its kid header
+func (k *Keyring) Parse(value string) (*jwt.Token, error) {
+	return jwt.Parse(value, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key := k.find(kid)
+		if key == nil {
+			return nil, fmt.Errorf("Unknown signing key %q", kid)
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("Unexpected signing method %s", token.Method.Alg())
+		}
+		return key.verifyKey, nil
+	})
+}
+
+// Middleware verifies the bearer access token of requests and
+// stores it in the context as user
+func (k *Keyring) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			auth := c.Request().Header.Get(echo.HeaderAuthorization)
+			if !strings.HasPrefix(auth, "Bearer ") {
+				return c.JSON(401, Error("Missing or malformed jwt", 401))
+			}
+			token, err := k.Parse(strings.TrimPrefix(auth, "Bearer "))
+			if err != nil || !token.Valid {
+				return c.JSON(401, Error("Invalid or expired jwt", 401))
+			}
+			if claims, ok := token.Claims.(jwt.MapClaims); !ok || claims["typ"] != TokenAccess {
+				return c.JSON(401, Error("Invalid jwt type", 401))
+			}
+			c.Set("user", token)
+			return next(c)
+		}
+	}
+}
+
+// JWKS returns the public keys of the keyring as a JSON Web Key Set so
+// other services can verify tokens. Symmetric keys are never included.
+func (k *Keyring) JWKS() map[string]interface{} {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	keys := []map[string]string{}
+	for _, key := range k.keys {
+		jwk := map[string]string{"kid": key.ID, "alg": key.Algorithm, "use": "sig"}
+		switch pub := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		keys = append(keys, jwk)
+	}
+
+	return map[string]interface{}{"keys": keys}
+}
+
+// GenerateKey generates a new signing key for algorithm
+func GenerateKey(algorithm string) (*SigningKey, error) {
+	key := &SigningKey{Algorithm: algorithm, Created: time.Now().UTC()}
+
+	var private interface{}
+	var err error
+	switch algorithm {
+	case AlgHS256:
+		secret := make([]byte, 32)
+		_, err = rand.Read(secret)
+		key.Secret = base64.StdEncoding.EncodeToString(secret)
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("Unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// encode private keys as pkcs8 pem
+	if private != nil {
+		der, err := x509.MarshalPKCS8PrivateKey(private)
+		if err != nil {
+			return nil, err
+		}
+		key.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	}
+
+	return key, key.parse()
+}
+
+// find returns the key with id, reloading the key file at most once
+// per reload interval in case another replica rotated keys
+func (k *Keyring) find(id string) *SigningKey {
+	for attempt := 0; attempt < 2; attempt++ {
+		k.mutex.RLock()
+		for _, key := range k.keys {
+			if key.ID == id {
+				k.mutex.RUnlock()
+				return key
+			}
+		}
+		stale := time.Since(k.lastReload) > reloadInterval
+		k.mutex.RUnlock()
+
+		if !stale || k.Reload() != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// create writes a new key file with secret as its HS256 key,
+// or a newly generated key if secret is empty
+func (k *Keyring) create(secret string) error {
+	key, err := secretKey(secret)
+	if secret == "" {
+		key, err = GenerateKey(k.algorithm)
+	}
+	if err != nil {
+		return err
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.keys = []*SigningKey{key}
+	k.lastReload = time.Now()
+	return k.save(k.keys)
+}
+
+// pin ensures the keyring holds just the HS256 key of secret,
+// failing if the key file was created with other keys
+func (k *Keyring) pin(secret string) error {
+	key, err := secretKey(secret)
+	if err != nil {
+		return err
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if len(k.keys) != 1 || k.keys[0].ID != key.ID || k.keys[0].Secret != key.Secret {
+		return fmt.Errorf("JWT_SECRET does not match the keys in %s, remove the file or unset JWT_SECRET", k.file)
+	}
+	k.pinned = true
+	return nil
+}
+
+// secretKey returns the HS256 key of secret
+func secretKey(secret string) (*SigningKey, error) {
+	key := &SigningKey{Algorithm: AlgHS256, Secret: base64.StdEncoding.EncodeToString([]byte(secret)), Created: time.Now().UTC()}
+	return key, key.parse()
+}
+
+// save atomically writes keys to the key file
+func (k *Keyring) save(keys []*SigningKey) error {
+	data, err := json.MarshalIndent(keyFile{Keys: keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := k.file + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, k.file)
+}
+
+// parse decodes the key material of a key and derives its id
+// from the key material if it has none
+func (key *SigningKey) parse() error {
+	var material []byte
+
+	switch key.Algorithm {
+	case AlgHS256:
+		secret, err := base64.StdEncoding.DecodeString(key.Secret)
+		if err != nil || len(secret) == 0 {
+			return errors.New("invalid secret")
+		}
+		key.signKey, key.verifyKey = secret, secret
+		material = secret
+	case AlgRS256, AlgEdDSA:
+		block, _ := pem.Decode([]byte(key.PrivateKey))
+		if block == nil {
+			return errors.New("invalid private key")
+		}
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+		switch private := private.(type) {
+		case *rsa.PrivateKey:
+			key.signKey, key.verifyKey = private, &private.PublicKey
+		case ed25519.PrivateKey:
+			key.signKey, key.verifyKey = private, private.Public()
+		}
+		if key.Algorithm == AlgRS256 && key.verifyKey == nil {
+			return errors.New("private key is not an rsa key")
+		}
+		if _, ok := key.verifyKey.(ed25519.PublicKey); key.Algorithm == AlgEdDSA && !ok {
+			return errors.New("private key is not an ed25519 key")
+		}
+		material = block.Bytes
+	default:
+		return fmt.Errorf("unsupported algorithm %q", key.Algorithm)
+	}
+
+	// secrets are hashed with a keyed mac so the id reveals nothing
+	// about them while replicas sharing a secret agree on its id
+	if key.ID == "" && key.Algorithm == AlgHS256 {
+		mac := hmac.New(sha256.New, material)
+		mac.Write([]byte("classmate signing key id"))
+		key.ID = hex.EncodeToString(mac.Sum(nil)[:8])
+	}
+	if key.ID == "" {
+		sum := sha256.Sum256(material)
+		key.ID = hex.EncodeToString(sum[:8])
+	}
+
+	return nil
+}
+
+// signingMethodEdDSA signs tokens with ed25519 keys
+// since jwt-go does not support them
+type signingMethodEdDSA struct{}
+
+// Alg returns the jwt alg header value of the method
+func (m *signingMethodEdDSA) Alg() string {
+	return AlgEdDSA
+}
+
+// Verify verifies the signature of signingString with an ed25519 public key
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(public, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+// Sign signs signingString with an ed25519 private key
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(private, []byte(signingString))), nil
+}
+
+func init() {
+	jwt.RegisterSigningMethod(AlgEdDSA, func() jwt.SigningMethod {
+		return &signingMethodEdDSA{}
+	})
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// testKeyring returns a keyring with a key file in a temporary directory
+func testKeyring(t *testing.T, name string) *Keyring {
+	return &Keyring{file: filepath.Join(t.TempDir(), name), algorithm: AlgHS256, retain: 3}
+}
+
+func TestSecretKeysShareIDs(t *testing.T) {
+	// replicas with their own key files but the same secret
+	a, b := testKeyring(t, "a.json"), testKeyring(t, "b.json")
+	for _, k := range []*Keyring{a, b} {
+		if err := k.create("shared secret"); err != nil {
+			t.Fatal(err)
+		}
+		if err := k.pin("shared secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.keys[0].ID != b.keys[0].ID {
+		t.Fatalf("kids differ: %s, %s", a.keys[0].ID, b.keys[0].ID)
+	}
+
+	token, err := a.Sign(jwt.MapClaims{"id": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := b.Parse(token)
+	if err != nil || !parsed.Valid {
+		t.Fatalf("Parse() = %v", err)
+	}
+
+	// the id reveals nothing about other secrets
+	other, err := secretKey("other secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.ID == a.keys[0].ID {
+		t.Fatal("different secrets share a kid")
+	}
+}
+
+func TestPinRejectsMismatchedKeyFile(t *testing.T) {
+	k := testKeyring(t, "keys.json")
+	if err := k.create(""); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.pin("secret"); err == nil {
+		t.Fatal("pin() accepted a key file without the secret")
+	}
+
+	k = testKeyring(t, "keys.json")
+	if err := k.create("secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.pin("changed"); err == nil {
+		t.Fatal("pin() accepted a changed secret")
+	}
+	if err := k.pin("secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Rotate(); err != ErrKeysPinned {
+		t.Fatalf("Rotate() = %v, want ErrKeysPinned", err)
+	}
+}
+
+func TestRotateKeepsKeysOfOtherReplicas(t *testing.T) {
+	a := testKeyring(t, "keys.json")
+	if err := a.create(""); err != nil {
+		t.Fatal(err)
+	}
+	b := &Keyring{file: a.file, algorithm: AlgHS256, retain: 3}
+	if err := b.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	// each replica rotates once
+	first, err := a.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := b.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.keys) != 3 || a.keys[0].ID != second.ID || a.keys[1].ID != first.ID {
+		t.Fatalf("keys after rotations = %d, want both rotated keys", len(a.keys))
+	}
+	token, err := a.Sign(jwt.MapClaims{"id": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Parse(token); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGeneratedKeysSignAndVerify(t *testing.T) {
+	for _, alg := range []string{AlgHS256, AlgRS256, AlgEdDSA} {
+		key, err := GenerateKey(alg)
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		k := &Keyring{keys: []*SigningKey{key}}
+		token, err := k.Sign(jwt.MapClaims{"id": "1"})
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		if _, err := k.Parse(token); err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		jwks := k.JWKS()["keys"].([]map[string]string)
+		if alg == AlgHS256 && len(jwks) != 0 || alg != AlgHS256 && len(jwks) != 1 {
+			t.Fatalf("%s: jwks has %d keys", alg, len(jwks))
+		}
+	}
+}
@@ -34,10 +34,25 @@ func Error(err interface{}, status int) bson.M {
 		msg = v
 	}
 	log.Println(msg)
-	return bson.M{
+	res := bson.M{
 		"error":  msg,
 		"status": status,
 	}
+
+	// list field errors of failed validation
+	if v, ok := err.(ValidationErrors); ok {
+		res["errors"] = v
+	}
+
+	return res
+}
+
+// ErrorCode handles errors that carry a machine readable
+// code so clients can tell failures apart
+func ErrorCode(code string, err interface{}, status int) bson.M {
+	res := Error(err, status)
+	res["code"] = code
+	return res
 }
 
 // Success handles success messages for our server
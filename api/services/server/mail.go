@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is an email message
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers email messages
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// SMTPMailer delivers messages through an smtp server
+type SMTPMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+// OutboxMailer writes messages to files in a directory instead of
+// delivering them, for local development and tests
+type OutboxMailer struct {
+	Dir  string
+	From string
+
+	mutex sync.Mutex
+	count int
+}
+
+// LoadMailer sets up the mailer configured by MAIL_DRIVER, which is
+// either smtp or outbox, defaulting to outbox
+func (s *Server) LoadMailer() {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "classmate@localhost"
+	}
+
+	switch os.Getenv("MAIL_DRIVER") {
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		mailer := &SMTPMailer{Addr: net.JoinHostPort(host, port), From: from}
+		if username := os.Getenv("SMTP_USERNAME"); username != "" {
+			mailer.Auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+		}
+		s.Mailer = mailer
+	case "", "outbox":
+		dir := os.Getenv("MAIL_OUTBOX")
+		if dir == "" {
+			dir = "outbox"
+		}
+		s.Mailer = &OutboxMailer{Dir: dir, From: from}
+	default:
+		log.Fatalln("Unknown mail driver:", os.Getenv("MAIL_DRIVER"))
+	}
+}
+
+// Send delivers msg through the smtp server
+func (m *SMTPMailer) Send(msg Message) error {
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{msg.To}, encode(m.From, msg, time.Now()))
+}
+
+// Send writes msg to a new .eml file in the outbox directory
+func (m *OutboxMailer) Send(msg Message) error {
+	err := os.MkdirAll(m.Dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	// name files by time so they list in order sent
+	m.mutex.Lock()
+	m.count++
+	now := time.Now()
+	name := fmt.Sprintf("%s-%04d.eml", now.UTC().Format("20060102T150405.000000000"), m.count)
+	m.mutex.Unlock()
+
+	return ioutil.WriteFile(filepath.Join(m.Dir, name), encode(m.From, msg, now), 0600)
+}
+
+// encode formats msg as a plain text rfc 5322 message
+func encode(from string, msg Message, t time.Time) []byte {
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		// strip line breaks so values can not inject headers
+		value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	header("From", from)
+	header("To", msg.To)
+	header("Subject", msg.Subject)
+	header("Date", t.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.Replace(strings.Replace(msg.Body, "\r\n", "\n", -1), "\n", "\r\n", -1))
+	return buf.Bytes()
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// LoadTrustedProxies parses the comma separated list of proxy
+// ips and cidr blocks in TRUSTED_PROXIES
+func (s *Server) LoadTrustedProxies() {
+	s.TrustedProxies = []*net.IPNet{}
+
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		network, err := ParseNetwork(entry)
+		if err != nil {
+			log.Fatalf("Invalid trusted proxy %q: %s", entry, err.Error())
+		}
+		s.TrustedProxies = append(s.TrustedProxies, network)
+	}
+}
+
+// ParseNetwork parses a cidr block, treating a bare ip
+// as a network containing only that ip
+func ParseNetwork(value string) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, &net.ParseError{Type: "IP address", Text: value}
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(value)
+	return network, err
+}
+
+// ClientIP returns the ip of the client that made the request.
+// X-Forwarded-For is only honoured when the request was made by
+// a trusted proxy, in which case the rightmost untrusted address
+// in the chain is the client.
+func (s *Server) ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if !s.isTrustedProxy(ip) {
+		return ip
+	}
+
+	// walk forwarded chain from the closest hop
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !s.isTrustedProxy(hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// isTrustedProxy returns true if ip is in one of the trusted proxy networks
+func (s *Server) isTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range s.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.1,fd00::/8")
+	s := &Server{}
+	s.LoadTrustedProxies()
+
+	cases := []struct {
+		name, remote, forwarded, want string
+	}{
+		{"direct client", "203.0.113.5:1234", "", "203.0.113.5"},
+		{"untrusted peer spoofing header", "203.0.113.5:1234", "198.51.100.7", "203.0.113.5"},
+		{"trusted proxy", "10.1.2.3:1234", "198.51.100.7", "198.51.100.7"},
+		{"bare ip proxy", "192.168.1.1:1234", "198.51.100.7", "198.51.100.7"},
+		{"chain of trusted proxies", "10.1.2.3:1234", "198.51.100.7, 192.168.1.1, 10.9.9.9", "198.51.100.7"},
+		{"client spoofing start of chain", "10.1.2.3:1234", "1.1.1.1, 198.51.100.7", "198.51.100.7"},
+		{"garbage in chain", "10.1.2.3:1234", "198.51.100.7, nonsense", "10.1.2.3"},
+		{"trusted proxy without header", "10.1.2.3:1234", "", "10.1.2.3"},
+		{"only trusted hops", "10.1.2.3:1234", "10.4.4.4", "10.4.4.4"},
+		{"ipv6 proxy", "[fd00::1]:1234", "2001:db8::7", "2001:db8::7"},
+		{"remote addr without port", "203.0.113.5", "", "203.0.113.5"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remote
+		if c.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if got := s.ClientIP(r); got.String() != c.want {
+			t.Errorf("%s: ClientIP() = %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestParseNetwork(t *testing.T) {
+	for value, want := range map[string]string{
+		"192.168.1.1":    "192.168.1.1/32",
+		"10.1.2.3/8":     "10.0.0.0/8",
+		"2001:db8::1":    "2001:db8::1/128",
+		"2001:db8::/32":  "2001:db8::/32",
+		"::ffff:1.2.3.4": "1.2.3.4/32",
+	} {
+		network, err := ParseNetwork(value)
+		if err != nil || network.String() != want {
+			t.Errorf("ParseNetwork(%q) = %v, %v, want %s", value, network, err, want)
+		}
+	}
+	for _, value := range []string{"", "nonsense", "10.0.0.0/33", "300.1.1.1"} {
+		if _, err := ParseNetwork(value); err == nil {
+			t.Errorf("ParseNetwork(%q) succeeded", value)
+		}
+	}
+}
@@ -1,12 +1,12 @@
 package server
 
 import (
+	"database/sql"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 
-	uuid "github.com/satori/go.uuid"
-
 	"github.com/globalsign/mgo"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
@@ -14,11 +14,17 @@ import (
 
 // Server is our echo server struct
 type Server struct {
-	Echo      *echo.Echo
-	Db        *mgo.Database
-	Log       *os.File
-	Session   *mgo.Session
-	JwtSecret []byte
+	Echo           *echo.Echo
+	Db             *mgo.Database
+	Log            *os.File
+	Session        *mgo.Session
+	SQL            *sql.DB
+	Storage        string
+	Keys           *Keyring
+	Mailer         Mailer
+	Events         *EventBus
+	TrustedProxies []*net.IPNet
+	PublicURL      string
 }
 
 // EchoHandler registers echo controllers with echo
@@ -28,11 +34,29 @@ func EchoHandler() *Server {
 	// initilaize logger/error handling
 	server.InitLogger("server_logs.txt")
 
-	// connect to db
-	server.ConnectToDb()
+	// connect to db unless keeping data in memory
+	server.LoadStorage()
+	switch server.Storage {
+	case StorageMongo:
+		server.ConnectToDb()
+	case StorageSQLite, StoragePostgres:
+		server.ConnectToSQL()
+	}
+
+	// load proxies allowed to set X-Forwarded-For
+	server.LoadTrustedProxies()
+
+	// load the base url used in links we send
+	server.LoadPublicURL()
+
+	// load jwt signing keys
+	server.LoadKeys()
 
-	// set JwtSecret to random uuid
-	server.JwtSecret = []byte(uuid.NewV4().String())
+	// setup mail delivery
+	server.LoadMailer()
+
+	// setup in process events
+	server.Events = NewEventBus()
 
 	// create new instance of echo web serer
 	server.Echo = echo.New()
@@ -42,6 +66,11 @@ func EchoHandler() *Server {
 		Format: "${method}  ${uri}  ${latency_human}  ${status}\n",
 	}))
 
+	// publish public keys for services verifying tokens
+	server.Echo.GET("/.well-known/jwks.json", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, server.Keys.JWKS())
+	})
+
 	// catch all route
 	server.Echo.Any("*", func(c echo.Context) error {
 		err := fmt.Sprintf("Bad Request - %s %s", c.Request().Method, c.Request().RequestURI)
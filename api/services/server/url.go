@@ -0,0 +1,23 @@
+package server
+
+import (
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// LoadPublicURL sets the base url clients reach the api at from
+// PUBLIC_URL. It is required because links in emails must never be
+// built from request headers a client controls.
+func (s *Server) LoadPublicURL() {
+	base := strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+	if base == "" {
+		log.Fatalln("PUBLIC_URL is required")
+	}
+	parsed, err := url.Parse(base)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		log.Fatalf("Invalid PUBLIC_URL %q, expected an absolute http or https url", base)
+	}
+	s.PublicURL = base
+}
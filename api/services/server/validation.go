@@ -0,0 +1,36 @@
+package server
+
+import "strings"
+
+// FieldError is a validation failure of a single request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors is a list of field errors that is itself an
+// error, returned by Error as a structured errors list
+type ValidationErrors []FieldError
+
+// Add appends a field error
+func (v *ValidationErrors) Add(field, code, message string) {
+	*v = append(*v, FieldError{Field: field, Code: code, Message: message})
+}
+
+// Err returns v as an error or nil if it has no field errors
+func (v ValidationErrors) Err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+// Error joins the messages of the field errors
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, err := range v {
+		msgs[i] = err.Message
+	}
+	return strings.Join(msgs, "; ")
+}
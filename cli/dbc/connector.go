@@ -1,9 +1,14 @@
 package dbc
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/globalsign/mgo/bson"
@@ -15,9 +20,26 @@ var (
 	client = &http.Client{
 		Timeout: time.Second * 10,
 	}
-	host = "http://localhost:9000/api/v1"
+	streamClient = &http.Client{}
+	host         = "http://localhost:9000/api/v1"
 )
 
+// Enrollment is the result of enrolling a student in a class
+type Enrollment struct {
+	Student string `json:"student"`
+	Status  string `json:"status"`
+	Error   string `json:"error"`
+}
+
+// Person is a user as listed by the api
+type Person struct {
+	ID        string `json:"_id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+}
+
 // CreatePerson makes a post request to create a new person
 func CreatePerson(person *bson.M) error {
 	result := User{}
@@ -73,3 +95,250 @@ func LoginPerson(person *bson.M) error {
 
 	return err
 }
+
+// Logout revokes the login of the current user and removes them from localdb
+func Logout() error {
+	user := GetUser()
+	err := request("POST", "/persons/logout", nil, nil)
+	user.Delete()
+	return err
+}
+
+// refresh exchanges the refresh token of user for new tokens
+func refresh(user *User) error {
+	result := User{}
+
+	// marshal data into json string
+	bodyBytes, err := json.Marshal(map[string]string{"refresh_token": user.Refresh})
+	if err != nil {
+		return err
+	}
+
+	// make post request
+	resp, err := client.Post(host+"/persons/refresh", "application/json", bytes.NewBuffer(bodyBytes))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("Session expired, please login again")
+	}
+
+	// unmarshal result and save new tokens
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return err
+	}
+	user.UpdateTokens(result.Token, result.Refresh)
+
+	return nil
+}
+
+// EnrollStudents makes a post request to enroll students in a class
+func EnrollStudents(class string, students []string) ([]Enrollment, error) {
+	results := []Enrollment{}
+	err := request("POST", "/classes/"+class+"/enroll", map[string][]string{"students": students}, &results)
+	return results, err
+}
+
+// ListPersons makes get requests for every page of users
+// matching search and returns them sorted by name
+func ListPersons(search string) ([]Person, error) {
+	persons := []Person{}
+	cursor := ""
+	for {
+		page := struct {
+			Data       []Person `json:"data"`
+			Pagination struct {
+				NextCursor string `json:"next_cursor"`
+			} `json:"pagination"`
+		}{}
+		query := url.Values{"limit": {"100"}, "q": {search}}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		err := request("GET", "/persons?"+query.Encode(), nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		persons = append(persons, page.Data...)
+		cursor = page.Pagination.NextCursor
+		if cursor == "" {
+			return persons, nil
+		}
+	}
+}
+
+// RosterEntry is the attendance of a student in a session
+type RosterEntry struct {
+	Student   string `json:"student"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Status    string `json:"status"`
+}
+
+// Snapshot is the attendance of a session when a stream starts
+type Snapshot struct {
+	Session struct {
+		ID string `json:"id"`
+	} `json:"session"`
+	Roster []RosterEntry `json:"roster"`
+}
+
+// Attendance is a check in or status change of a student
+type Attendance struct {
+	Student string `json:"student"`
+	Status  string `json:"status"`
+}
+
+// AttendanceEvent is an event of an attendance stream, holding a
+// snapshot or an attendance record depending on its type
+type AttendanceEvent struct {
+	Type       string
+	Snapshot   *Snapshot
+	Attendance *Attendance
+}
+
+// StreamAttendance streams the attendance of the current session of a
+// class, calling handle with each event until stop is closed
+func StreamAttendance(class string, stop <-chan struct{}, handle func(AttendanceEvent)) error {
+	resp, err := sendAuthorized(streamClient, "GET", "/classes/"+class+"/sessions/current/stream", nil, GetUser())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// close stream when stopped
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+			resp.Body.Close()
+		case <-done:
+		}
+	}()
+
+	// read events, which end with a blank line
+	event := AttendanceEvent{}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event.Type = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data := []byte(strings.TrimPrefix(line, "data: "))
+			if event.Type == "snapshot" {
+				event.Snapshot = &Snapshot{}
+				err = json.Unmarshal(data, event.Snapshot)
+			} else {
+				event.Attendance = &Attendance{}
+				err = json.Unmarshal(data, event.Attendance)
+			}
+			if err != nil {
+				return err
+			}
+		case line == "" && event.Type != "":
+			handle(event)
+			event = AttendanceEvent{}
+		}
+	}
+
+	// stopping closes the stream early
+	select {
+	case <-stop:
+		return nil
+	default:
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("Stream ended, please try again")
+}
+
+// request makes an authenticated request as the current user and
+// unmarshals the json result into result, refreshing the user
+// tokens once if the access token has expired
+func request(method, path string, body interface{}, result interface{}) error {
+	var bodyBytes []byte
+	var err error
+	user := GetUser()
+
+	// marshal data into json string
+	if body != nil {
+		bodyBytes, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	// make request
+	resp, err := sendAuthorized(client, method, path, bodyBytes, user)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// unmarshal result
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// sendAuthorized makes a request with the token of user, refreshing
+// the user tokens once if the access token has expired, and returns
+// the api error message of failed requests
+func sendAuthorized(c *http.Client, method, path string, body []byte, user *User) (*http.Response, error) {
+
+	// make request with user token
+	resp, err := send(c, method, path, body, user.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	// refresh expired token and retry
+	if resp.StatusCode == http.StatusUnauthorized && user.Refresh != "" {
+		resp.Body.Close()
+		err = refresh(user)
+		if err != nil {
+			return nil, err
+		}
+		resp, err = send(c, method, path, body, user.Token)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// return api error message
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		apiErr := struct {
+			Error string `json:"error"`
+		}{}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		if apiErr.Error == "" {
+			apiErr.Error = resp.Status
+		}
+		return nil, errors.New(apiErr.Error)
+	}
+
+	return resp, nil
+}
+
+// send makes a request with a bearer token
+func send(c *http.Client, method, path string, body []byte, token string) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, host+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	return c.Do(req)
+}
@@ -20,6 +20,7 @@ type User struct {
 	LastName  string `json:"last_name"`
 	Role      string `json:"role"`
 	Token     string `json:"token"`
+	Refresh   string `json:"refresh_token"`
 }
 
 // Open opens sqlite db
@@ -41,12 +42,24 @@ func Open() *gorm.DB {
 
 // Save saves user
 func (u *User) Save() {
-	DB.FirstOrCreate(&u, User{Email: u.Email})
+	DB.Where(User{Email: u.Email}).Assign(map[string]interface{}{"token": u.Token, "refresh": u.Refresh}).FirstOrCreate(&u)
+}
+
+// UpdateTokens saves new tokens of the user
+func (u *User) UpdateTokens(token, refresh string) {
+	u.Token = token
+	u.Refresh = refresh
+	DB.Model(u).Updates(map[string]interface{}{"token": token, "refresh": refresh})
+}
+
+// Delete removes user from local db
+func (u *User) Delete() {
+	DB.Unscoped().Delete(u)
 }
 
 // GetUser gets user from local db
 func GetUser() *User {
 	u := User{}
-	DB.First(&u, 1)
+	DB.First(&u)
 	return &u
 }
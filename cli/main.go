@@ -60,7 +60,8 @@ func studentDashboard() {
 	print(format.Underline("\nWelcome to Classmate "+user.FirstName+"!"), "\n")
 	print(format.Green("1.) Check in to class"))
 	print(format.Cyan("2.) List classes"))
-	print(format.Red("3.) Exit"), "\n")
+	print(format.Cyan("3.) Logout"))
+	print(format.Red("4.) Exit"), "\n")
 	print("Please make a selection:")
 	choice := getInput()
 	switch choice {
@@ -69,6 +70,8 @@ func studentDashboard() {
 	case "2":
 		login()
 	case "3":
+		logout()
+	case "4":
 		stop = true
 	default:
 		print(format.Magenta("\nInvalid input!"))
@@ -80,15 +83,18 @@ func teacherDashboard() {
 	print(format.Underline("\nWelcome to Classmate "+user.FirstName+"!"), "\n")
 	print(format.Green("1.) View current attendance"))
 	print(format.Cyan("2.) List classes"))
-	print(format.Red("3.) Exit"), "\n")
+	print(format.Cyan("3.) Logout"))
+	print(format.Red("4.) Exit"), "\n")
 	print("Please make a selection:")
 	choice := getInput()
 	switch choice {
 	case "1":
-		signup()
+		viewAttendance()
 	case "2":
 		login()
 	case "3":
+		logout()
+	case "4":
 		stop = true
 	default:
 		print(format.Magenta("\nInvalid input!"))
@@ -102,15 +108,20 @@ func adminDashboard() {
 	print(format.Cyan("2.) Enroll students"))
 	print(format.Cyan("3.) List classes"))
 	print(format.Cyan("4.) List users"))
-	print(format.Red("5.) Exit"), "\n")
+	print(format.Cyan("5.) Logout"))
+	print(format.Red("6.) Exit"), "\n")
 	print("Please make a selection:")
 	choice := getInput()
 	switch choice {
 	case "1":
 		signup()
 	case "2":
-		login()
+		enroll()
+	case "4":
+		listUsers()
 	case "5":
+		logout()
+	case "6":
 		stop = true
 	default:
 		print(format.Magenta("\nInvalid input!"))
@@ -188,6 +199,112 @@ func login() {
 
 }
 
+// Logout
+func logout() {
+	err := dbc.Logout()
+	if err != nil {
+		print(format.Red("\n" + err.Error()))
+	}
+	start()
+}
+
+// Enroll students
+func enroll() {
+
+	// get input
+	print(format.Underline("\nEnroll Students\n"))
+	print(format.Cyan("Please enter class id:"))
+	class := getInput()
+	print(format.Cyan("Please enter student emails separated by commas:"))
+	students := []string{}
+	for _, email := range strings.Split(getInput(), ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			students = append(students, email)
+		}
+	}
+
+	// enroll students
+	results, err := dbc.EnrollStudents(class, students)
+	if err != nil {
+		print(format.Red("\n" + err.Error()))
+		return
+	}
+
+	// print result for each student
+	print("")
+	for _, result := range results {
+		if result.Error != "" {
+			print(format.Red(result.Student + ": " + result.Error))
+		} else {
+			print(format.Green(result.Student + ": " + result.Status))
+		}
+	}
+}
+
+// View current attendance
+func viewAttendance() {
+
+	// get input
+	print(format.Underline("\nCurrent Attendance\n"))
+	print(format.Cyan("Please enter class id:"))
+	class := getInput()
+	print(format.Cyan("Press enter to stop watching\n"))
+
+	// print attendance as it changes
+	names := map[string]string{}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		err := dbc.StreamAttendance(class, done, func(event dbc.AttendanceEvent) {
+			if event.Snapshot != nil {
+				print(format.Underline("Session " + event.Snapshot.Session.ID))
+				for _, entry := range event.Snapshot.Roster {
+					names[entry.Student] = entry.FirstName + " " + entry.LastName
+					print(format.Cyan(names[entry.Student] + ": " + entry.Status))
+				}
+				return
+			}
+			if event.Attendance != nil {
+				print(format.Green(names[event.Attendance.Student] + ": " + event.Attendance.Status))
+			}
+		})
+		if err != nil {
+			print(format.Red("\n" + err.Error()))
+		}
+	}()
+
+	// stop on enter
+	getInput()
+	close(done)
+	<-stopped
+}
+
+// List users
+func listUsers() {
+
+	// get input
+	print(format.Underline("\nList Users\n"))
+	print(format.Cyan("Please enter a name or email to search for (leave blank for all):"))
+	search := strings.TrimSpace(getInput())
+
+	// get users
+	persons, err := dbc.ListPersons(search)
+	if err != nil {
+		print(format.Red("\n" + err.Error()))
+		return
+	}
+
+	// print each user
+	print("")
+	if len(persons) == 0 {
+		print(format.Magenta("No users found"))
+	}
+	for _, person := range persons {
+		print(format.Green(person.LastName + ", " + person.FirstName + " <" + person.Email + "> " + person.Role))
+	}
+}
+
 // Wait for input
 func getInput() string {
 	buf := bufio.NewReader(os.Stdin)